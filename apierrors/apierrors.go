@@ -0,0 +1,170 @@
+package apierrors
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/xectich/paymentGateway/constants"
+)
+
+//categories group the error codes by what the client can do about them
+const (
+	CategoryValidation     = "validation_error"
+	CategoryAuthentication = "authentication_error"
+	CategoryPermission     = "permission_error"
+	CategoryNotFound       = "not_found_error"
+	CategoryConflict       = "conflict_error"
+	CategoryCard           = "card_error"
+	CategoryRateLimit      = "rate_limit_error"
+	CategoryService        = "service_error"
+	CategoryInternal       = "internal_error"
+)
+
+// generic information about an error returned to API clients
+// Code is stable and documented, Message is the human readable text from constants/errors.go
+// AuthorizationID is set when the error was recorded as a declined authorization
+type Error struct {
+	Code            string
+	Category        string
+	Status          int
+	Message         string
+	AuthorizationID string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// the code, category and HTTP status of an error message
+type definition struct {
+	Code     string
+	Category string
+	Status   int
+}
+
+//definitions maps the messages in constants/errors.go to their codes, messages built from a prefix are keyed by the prefix
+var definitions = map[string]definition{
+	constants.InvalidMonth:                  {Code: "invalid_expiry_month", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.CreditCardExpired:             {Code: "expired_card", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.NoMatchCardExpirationDate:     {Code: "incorrect_expiry", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.InvalidCVV:                    {Code: "invalid_cvv", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.NoMatchCVV:                    {Code: "incorrect_cvv", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.InvalidCreditCardNumber:       {Code: "invalid_card_number", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.CardNotFound:                  {Code: "card_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.CardTokenNotFound:             {Code: "card_token_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.CardNumberOrTokenRequired:     {Code: "card_required", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.CardWiped:                     {Code: "card_wiped", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.UnknownCardBrand:              {Code: "unsupported_card_brand", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.CardBrandNotAccepted:          {Code: "card_brand_not_accepted", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.VaultNotConfigured:            {Code: "vault_not_configured", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidVaultKey:               {Code: "invalid_vault_key", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.CardDecryptionFailed:          {Code: "card_decryption_failed", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.BankAccountNotFound:           {Code: "card_declined", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.AmountExeedsBalance:           {Code: "insufficient_funds", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.AmountExeedsAuthorizedBalance: {Code: "amount_exceeds_authorized", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.InvalidAmount:                 {Code: "invalid_amount", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.AuthorizationNotFound:         {Code: "authorization_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.UnableToCreateJWTToken:        {Code: "token_creation_failed", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidStatusTransition:       {Code: "invalid_status_transition", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.ExchangeRateNotFound:          {Code: "exchange_rate_not_found", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.ExchangeRateUnavailable:       {Code: "exchange_rate_unavailable", Category: CategoryService, Status: http.StatusServiceUnavailable},
+	constants.UnknownFXProvider:             {Code: "unknown_fx_provider", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidFXRatesFile:            {Code: "invalid_fx_rates_file", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidCurrency:               {Code: "invalid_currency", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.MerchantNotFound:              {Code: "merchant_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.MerchantDisabled:              {Code: "merchant_disabled", Category: CategoryPermission, Status: http.StatusForbidden},
+	constants.InvalidMerchantName:           {Code: "invalid_merchant_name", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.InvalidMerchantCredentials:    {Code: "invalid_credentials", Category: CategoryAuthentication, Status: http.StatusUnauthorized},
+	constants.Unauthorized:                  {Code: "unauthorized", Category: CategoryAuthentication, Status: http.StatusUnauthorized},
+	constants.InvalidQueryParameter:         {Code: "invalid_query_parameter", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.LedgerMismatch:                {Code: "ledger_mismatch", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.HoldNotFound:                  {Code: "hold_not_found", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.AuthorizationExpired:          {Code: "authorization_expired", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.AuthorizationBusy:             {Code: "authorization_busy", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.InvalidAuthorizationTTL:       {Code: "invalid_authorization_ttl", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.InvalidWebhookURL:             {Code: "invalid_webhook_url", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.WebhookURLNotPublic:           {Code: "webhook_url_not_public", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.WebhookEndpointNotFound:       {Code: "webhook_endpoint_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.WebhookDeliveryNotFound:       {Code: "webhook_delivery_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.InvalidIdempotencyKey:         {Code: "invalid_idempotency_key", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.IdempotencyKeyReused:          {Code: "idempotency_key_reused", Category: CategoryValidation, Status: http.StatusUnprocessableEntity},
+	constants.IdempotencyKeyInProgress:      {Code: "idempotency_key_in_progress", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.IdempotencyKeyNotFound:        {Code: "idempotency_key_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.ProcessorDeclined:             {Code: "processor_declined", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.ProcessorUnavailable:          {Code: "processor_unavailable", Category: CategoryService, Status: http.StatusServiceUnavailable},
+	constants.ProcessorTimeout:              {Code: "processor_timeout", Category: CategoryService, Status: http.StatusGatewayTimeout},
+	constants.ProcessorOperationConflict:    {Code: "processor_operation_conflict", Category: CategoryConflict, Status: http.StatusConflict},
+	constants.UnknownProcessor:              {Code: "unknown_processor", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidProcessorRoutesFile:    {Code: "invalid_processor_routes_file", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.SuspectedFraud:                {Code: "suspected_fraud", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.UnknownSimulatorOutcome:       {Code: "unknown_simulator_outcome", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidSimulatorScenario:      {Code: "invalid_simulator_scenario", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.RiskBlocked:                   {Code: "risk_blocked", Category: CategoryCard, Status: http.StatusPaymentRequired},
+	constants.RiskRuleNotFound:              {Code: "risk_rule_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.InvalidRiskRule:               {Code: "invalid_risk_rule", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.UnknownRiskRuleType:           {Code: "unknown_risk_rule_type", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.InvalidCountry:                {Code: "invalid_country", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.InvalidBINPrefix:              {Code: "invalid_bin_prefix", Category: CategoryValidation, Status: http.StatusBadRequest},
+	constants.BINCountryNotFound:            {Code: "bin_country_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.CardLocked:                    {Code: "card_locked", Category: CategoryCard, Status: http.StatusLocked},
+	constants.CardLockoutNotFound:           {Code: "card_lockout_not_found", Category: CategoryNotFound, Status: http.StatusNotFound},
+	constants.RateLimited:                   {Code: "rate_limited", Category: CategoryRateLimit, Status: http.StatusTooManyRequests},
+	constants.UnknownRateLimitStore:         {Code: "unknown_rate_limit_store", Category: CategoryInternal, Status: http.StatusInternalServerError},
+	constants.InvalidRateLimits:             {Code: "invalid_rate_limits", Category: CategoryInternal, Status: http.StatusInternalServerError},
+}
+
+//statusDefinitions describe errors that have no code of their own, e.g. a request body that is not valid JSON
+var statusDefinitions = map[int]definition{
+	http.StatusBadRequest:          {Code: "invalid_request", Category: CategoryValidation},
+	http.StatusUnauthorized:        {Code: "unauthorized", Category: CategoryAuthentication},
+	http.StatusForbidden:           {Code: "forbidden", Category: CategoryPermission},
+	http.StatusNotFound:            {Code: "not_found", Category: CategoryNotFound},
+	http.StatusConflict:            {Code: "conflict", Category: CategoryConflict},
+	http.StatusUnprocessableEntity: {Code: "invalid_request_body", Category: CategoryValidation},
+	http.StatusServiceUnavailable:  {Code: "service_unavailable", Category: CategoryService},
+}
+
+//New returns the typed error for a message from constants/errors.go
+func New(message string) error {
+	return newError(message, message)
+}
+
+//WithDetail returns the typed error for a message prefix from constants/errors.go followed by a detail
+func WithDetail(prefix string, detail string) error {
+	return newError(prefix, prefix+detail)
+}
+
+func newError(key string, message string) *Error {
+	def, ok := definitions[key]
+	if !ok {
+		def = definition{Code: "internal_error", Category: CategoryInternal, Status: http.StatusInternalServerError}
+	}
+	return &Error{Code: def.Code, Category: def.Category, Status: def.Status, Message: message}
+}
+
+//From returns the typed error in err's chain, errors without a type get a generic code for the given status
+func From(err error, status int) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+
+	def, ok := statusDefinitions[status]
+	if !ok {
+		def = definition{Code: "internal_error", Category: CategoryInternal}
+	}
+	return &Error{Code: def.Code, Category: def.Category, Status: status, Message: err.Error()}
+}
+
+//ForAuthorization returns err as a typed error that refers to the authorization it was recorded on
+func ForAuthorization(err error, authorizationID string) error {
+	typed := *From(err, http.StatusInternalServerError)
+	typed.AuthorizationID = authorizationID
+	return &typed
+}
+
+//Is tells whether err is the typed error for the message
+func Is(err error, message string) bool {
+	var typed *Error
+	return errors.As(err, &typed) && typed.Code == definitions[message].Code
+}
@@ -1,9 +1,7 @@
 package auth
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -18,6 +16,7 @@ func CreateToken(merchant_id uint32) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["merchant_id"] = merchant_id
+	claims["iat"] = time.Now().Unix()
 	claims["exp"] = time.Now().Add(time.Hour * 2).Unix() //Token expires after 2 hour
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(os.Getenv("API_SECRET")))
@@ -36,8 +35,8 @@ func TokenValid(r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+	if !token.Valid {
+		return fmt.Errorf("Invalid token")
 	}
 	return nil
 }
@@ -79,13 +78,25 @@ func ExtractTokenID(r *http.Request) (uint32, error) {
 	return 0, nil
 }
 
-//Pretty display the claims nicely in the terminal
-func Pretty(data interface{}) {
-	b, err := json.MarshalIndent(data, "", " ")
+//ExtractTokenIssuedAt extracts when the token was issued, used to reject tokens created before a key rotation
+func ExtractTokenIssuedAt(r *http.Request) (time.Time, error) {
+	tokenString := ExtractToken(r)
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("API_SECRET")), nil
+	})
 	if err != nil {
-		log.Println(err)
-		return
+		return time.Time{}, err
 	}
-
-	fmt.Println(string(b))
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if ok && token.Valid {
+		iat, ok := claims["iat"].(float64)
+		if !ok {
+			return time.Time{}, nil
+		}
+		return time.Unix(int64(iat), 0), nil
+	}
+	return time.Time{}, nil
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/xectich/paymentGateway/mockacquirer"
+)
+
+//main runs the mock acquirer on MOCK_ACQUIRER_ADDR, :9090 unless set
+//requests must carry ACQUIRER_API_KEY as a bearer token when it is set
+//amounts ending in 97 are answered after MOCK_ACQUIRER_DELAY, 10s unless set, longer than the gateway waits by default
+func main() {
+	addr := os.Getenv("MOCK_ACQUIRER_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	acquirer := mockacquirer.NewAcquirer(os.Getenv("ACQUIRER_API_KEY"))
+	acquirer.Delay = 10 * time.Second
+	if delay, err := time.ParseDuration(os.Getenv("MOCK_ACQUIRER_DELAY")); err == nil {
+		acquirer.Delay = delay
+	}
+	fmt.Println("Mock acquirer listening on " + addr)
+	log.Fatal(http.ListenAndServe(addr, acquirer.Handler()))
+}
@@ -7,8 +7,12 @@ const (
 	Voided
 	Refunded
 	Captured
+	Expired
+	PartiallyCaptured
+	PartiallyRefunded
+	Declined
 )
 
 func (as AuthStatus) String() string {
-	return [...]string{"Authorized", "Voided", "Refunded", "Captured"}[as-1]
+	return [...]string{"Authorized", "Voided", "Refunded", "Captured", "Expired", "PartiallyCaptured", "PartiallyRefunded", "Declined"}[as-1]
 }
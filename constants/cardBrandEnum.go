@@ -0,0 +1,18 @@
+package constants
+
+type CardBrand int
+
+const (
+	Visa = iota + 1
+	Mastercard
+	Amex
+	Discover
+	JCB
+	UnionPay
+	Diners
+	Maestro
+)
+
+func (cb CardBrand) String() string {
+	return [...]string{"visa", "mastercard", "amex", "discover", "jcb", "unionpay", "diners", "maestro"}[cb-1]
+}
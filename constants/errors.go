@@ -8,11 +8,64 @@ const (
 	NoMatchCVV                    = "CVV doesn't match"
 	InvalidCreditCardNumber       = "Invalid credit card number"
 	CardNotFound                  = "Card Not Found"
+	CardTokenNotFound             = "Card token Not Found"
+	CardNumberOrTokenRequired     = "Either a card number or a card token is required"
+	CardWiped                     = "Card has been wiped"
+	UnknownCardBrand              = "Card brand is not supported"
+	CardBrandNotAccepted          = "Card brand is not accepted by the merchant"
+	VaultNotConfigured            = "Card vault is not configured"
+	InvalidVaultKey               = "Card vault key must be 32 bytes encoded in base64"
+	CardDecryptionFailed          = "Card could not be decrypted"
 	BankAccountNotFound           = "Bank Account Not Found"
 	AmountExeedsBalance           = "Amount is higher than current balance"
 	AmountExeedsAuthorizedBalance = "Amount is higher than the authorized balance"
 	InvalidAmount                 = "Amount is invalid"
 	AuthorizationNotFound         = "Authorization Not Found"
 	UnableToCreateJWTToken        = "Unable to create authorization token"
-	InvalidStatus                 = "Authorization has already been "
+	InvalidStatusTransition       = "Authorization status cannot change from "
+	ExchangeRateNotFound          = "Exchange rate is not available for the requested currency"
+	ExchangeRateUnavailable       = "Exchange rate service is unavailable"
+	UnknownFXProvider             = "Unknown exchange rate provider "
+	InvalidFXRatesFile            = "Exchange rates file must define a base currency"
+	InvalidCurrency               = "Currency is invalid"
+	MerchantNotFound              = "Merchant Not Found"
+	MerchantDisabled              = "Merchant is disabled"
+	InvalidMerchantName           = "Merchant name is invalid"
+	InvalidMerchantCredentials    = "Invalid merchant credentials"
+	Unauthorized                  = "Unauthorized"
+	InvalidQueryParameter         = "Invalid query parameter "
+	LedgerMismatch                = "Authorization balances do not match its transactions"
+	HoldNotFound                  = "Hold Not Found"
+	AuthorizationExpired          = "Authorization has expired"
+	AuthorizationBusy             = "Another operation on the authorization is still waiting for the processor"
+	InvalidAuthorizationTTL       = "Authorization validity is invalid"
+	InvalidWebhookURL             = "Webhook URL must be an absolute http or https URL"
+	WebhookURLNotPublic           = "Webhook URL must resolve to a public address"
+	WebhookEndpointNotFound       = "Webhook endpoint Not Found"
+	WebhookDeliveryNotFound       = "Webhook delivery Not Found"
+	InvalidIdempotencyKey         = "Idempotency key is invalid"
+	IdempotencyKeyReused          = "Idempotency key has already been used with a different request"
+	IdempotencyKeyInProgress      = "A request with this idempotency key is still being processed"
+	IdempotencyKeyNotFound        = "Idempotency key Not Found"
+	ProcessorDeclined             = "Card was declined by the processor"
+	ProcessorUnavailable          = "Processor is unavailable"
+	ProcessorTimeout              = "Processor did not answer, retry the operation unchanged"
+	ProcessorOperationConflict    = "An earlier attempt of the operation with different details may have been applied by the processor"
+	UnknownProcessor              = "Unknown processor "
+	InvalidProcessorRoutesFile    = "Processor routes must name a known processor"
+	SuspectedFraud                = "Card was declined as suspected fraud"
+	UnknownSimulatorOutcome       = "Unknown simulator outcome "
+	InvalidSimulatorScenario      = "Simulator scenario is invalid"
+	RiskBlocked                   = "Authorization was blocked by fraud screening"
+	RiskRuleNotFound              = "Risk rule Not Found"
+	InvalidRiskRule               = "Risk rule is invalid"
+	UnknownRiskRuleType           = "Unknown risk rule type "
+	InvalidCountry                = "Country must be a 2 letter ISO 3166 code"
+	InvalidBINPrefix              = "BIN prefix must be 1 to 8 digits"
+	BINCountryNotFound            = "BIN country Not Found"
+	CardLocked                    = "Card is temporarily locked after too many failed verification attempts"
+	CardLockoutNotFound           = "Card lockout Not Found"
+	RateLimited                   = "Too many requests, retry later"
+	UnknownRateLimitStore         = "Unknown rate limit store "
+	InvalidRateLimits             = "Rate limits must allow at least one request per period"
 )
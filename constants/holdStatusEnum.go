@@ -0,0 +1,13 @@
+package constants
+
+type HoldStatus int
+
+const (
+	HoldActive = iota + 1
+	HoldReleased
+	HoldCaptured
+)
+
+func (hs HoldStatus) String() string {
+	return [...]string{"active", "released", "captured"}[hs-1]
+}
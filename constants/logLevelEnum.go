@@ -0,0 +1,14 @@
+package constants
+
+type LogLevel int
+
+const (
+	LogDebug = iota + 1
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (ll LogLevel) String() string {
+	return [...]string{"debug", "info", "warn", "error"}[ll-1]
+}
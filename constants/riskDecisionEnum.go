@@ -0,0 +1,13 @@
+package constants
+
+type RiskDecision int
+
+const (
+	RiskAllow = iota + 1
+	RiskReview
+	RiskBlock
+)
+
+func (rd RiskDecision) String() string {
+	return [...]string{"allow", "review", "block"}[rd-1]
+}
@@ -0,0 +1,16 @@
+package constants
+
+type RiskRuleType int
+
+const (
+	CardVelocityRule = iota + 1
+	MerchantVelocityRule
+	AmountThresholdRule
+	CurrencyMismatchRule
+	CVVFailuresRule
+	CountryMismatchRule
+)
+
+func (rt RiskRuleType) String() string {
+	return [...]string{"card_velocity", "merchant_velocity", "amount_threshold", "currency_mismatch", "cvv_failures", "country_mismatch"}[rt-1]
+}
@@ -0,0 +1,16 @@
+package constants
+
+type SimulatorOutcome int
+
+const (
+	OutcomeApprove = iota + 1
+	OutcomeInsufficientFunds
+	OutcomeSuspectedFraud
+	OutcomeTimeout
+	OutcomePartialApproval
+	OutcomeDelayed
+)
+
+func (so SimulatorOutcome) String() string {
+	return [...]string{"approve", "insufficient_funds", "suspected_fraud", "timeout", "partial_approval", "delayed"}[so-1]
+}
@@ -0,0 +1,15 @@
+package constants
+
+type TransactionType int
+
+const (
+	AuthorizeTransaction = iota + 1
+	CaptureTransaction
+	VoidTransaction
+	RefundTransaction
+	ExpireTransaction
+)
+
+func (tt TransactionType) String() string {
+	return [...]string{"authorize", "capture", "void", "refund", "expire"}[tt-1]
+}
@@ -0,0 +1,14 @@
+package constants
+
+type WebhookDeliveryStatus int
+
+const (
+	DeliveryPending = iota + 1
+	DeliveryDelivered
+	DeliveryFailed
+	DeliveryInFlight
+)
+
+func (ds WebhookDeliveryStatus) String() string {
+	return [...]string{"pending", "delivered", "failed", "in_flight"}[ds-1]
+}
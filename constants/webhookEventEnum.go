@@ -0,0 +1,16 @@
+package constants
+
+type WebhookEventType int
+
+const (
+	AuthorizationCreatedEvent = iota + 1
+	AuthorizationCapturedEvent
+	AuthorizationVoidedEvent
+	AuthorizationRefundedEvent
+	AuthorizationExpiredEvent
+	AuthorizationDeclinedEvent
+)
+
+func (et WebhookEventType) String() string {
+	return [...]string{"authorization.created", "authorization.captured", "authorization.voided", "authorization.refunded", "authorization.expired", "authorization.declined"}[et-1]
+}
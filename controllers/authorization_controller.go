@@ -2,68 +2,34 @@ package controllers
 
 import (
 	"encoding/json"
-	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
-	// "log"
-	// "fmt"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/apierrors"
 	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/metrics"
 	"github.com/xectich/paymentGateway/models"
 	"github.com/xectich/paymentGateway/responses"
 )
 
-type Merchant struct {
-	ID uint32 `json:"mid"`
-}
-
-//Login creates a token based on the merchantID, can be implemented with a proper login system
-func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
-		return
-	}
-	merchant := Merchant{}
-	err = json.Unmarshal(body, &merchant)
-	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
-		return
-	}
-	token, err := auth.CreateToken(merchant.ID)
-	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New(constants.UnableToCreateJWTToken))
-		return
-	}
-	responses.JSON(w, http.StatusOK, token)
-}
-
 //RequestAuthorization handles the request/response for new authorization requests
 func (server *Server) RequestAuthorization(w http.ResponseWriter, r *http.Request) {
 	//get the request body and umarshall it into request struct
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
-	}
-
-	//get the merchant ID and token and validate
-	vars := mux.Vars(r)
-	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
-	if err != nil {
-		responses.ERROR(w, http.StatusBadRequest, err)
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	//get the merchant ID and token and validate
+	mid, status, err := server.authenticateMerchant(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-	if tokenID != uint32(mid) {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		responses.ERROR(w, status, err)
 		return
 	}
 
@@ -77,7 +43,8 @@ func (server *Server) RequestAuthorization(w http.ResponseWriter, r *http.Reques
 	//call the function to request authorization from the auth interface
 	authI := models.NewAuthI()
 
-	auth, err := authI.RequestAuthorization(authRequest, server.DB)
+	auth, err := authI.RequestAuthorization(mid, authRequest, server.requestDB(r))
+	recordOperation("authorize", mid, auth, authRequest.Currency, err)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
@@ -86,6 +53,8 @@ func (server *Server) RequestAuthorization(w http.ResponseWriter, r *http.Reques
 	//construct the response
 	authResponse := models.AuthorizationResponse{
 		ID:              auth.ID,
+		CardToken:       auth.CardToken,
+		CardBrand:       auth.CardBrand,
 		Currency:        auth.CurrencyCard,
 		AmountAvailable: auth.BalanceAuthorised,
 	}
@@ -95,41 +64,75 @@ func (server *Server) RequestAuthorization(w http.ResponseWriter, r *http.Reques
 
 //Capture handles the request/response for capturing funds on a customers bank
 func (server *Server) Capture(w http.ResponseWriter, r *http.Request) {
-	//get the request body and umarshall it into request struct
-	body, err := ioutil.ReadAll(r.Body)
+	mid, actionRequest, status, err := server.merchantActionRequest(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		responses.ERROR(w, status, err)
+		return
 	}
 
-	//get the merchant ID and token and validate
-	vars := mux.Vars(r)
-	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	//call the function to request authorization from the auth interface
+	authI := models.NewAuthI()
+
+	auth, err := authI.Capture(mid, actionRequest.ID, actionRequest.Amount, actionRequest.Currency, actionRequest.Final, server.requestDB(r))
+	recordOperation("capture", mid, auth, actionRequest.Currency, err)
+
 	if err != nil {
-		responses.ERROR(w, http.StatusBadRequest, err)
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	//construct the response
+	authResponse := models.AuthorizationResponse{
+		ID:              auth.ID,
+		Currency:        auth.CurrencyCard,
+		AmountAvailable: auth.BalanceAuthorised - auth.BalanceCaptured,
+	}
+
+	responses.JSON(w, http.StatusCreated, authResponse)
+}
+
+//Refund handles the request/response for refunding funds
+func (server *Server) Refund(w http.ResponseWriter, r *http.Request) {
+	mid, actionRequest, status, err := server.merchantActionRequest(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		responses.ERROR(w, status, err)
 		return
 	}
-	if tokenID != uint32(mid) {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+
+	//call the function to request authorization from the auth interface
+	authI := models.NewAuthI()
+
+	auth, err := authI.Refund(mid, actionRequest.ID, actionRequest.Amount, actionRequest.Currency, actionRequest.Final, server.requestDB(r))
+	recordOperation("refund", mid, auth, actionRequest.Currency, err)
+
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	actionRequest := models.ActionRequest{}
-	err = json.Unmarshal(body, &actionRequest)
+	//construct the response
+	authResponse := models.AuthorizationResponse{
+		ID:              auth.ID,
+		Currency:        auth.CurrencyCard,
+		AmountAvailable: auth.BalanceAuthorised - auth.BalanceCaptured,
+	}
+
+	responses.JSON(w, http.StatusCreated, authResponse)
+}
+
+//Void handles the request/response for voiding a transaction
+func (server *Server) Void(w http.ResponseWriter, r *http.Request) {
+	mid, actionRequest, status, err := server.merchantActionRequest(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		responses.ERROR(w, status, err)
 		return
 	}
 
 	//call the function to request authorization from the auth interface
 	authI := models.NewAuthI()
 
-	auth, err := authI.Capture(actionRequest.ID, actionRequest.Amount, actionRequest.Final, server.DB)
+	auth, err := authI.Void(mid, actionRequest.ID, server.requestDB(r))
+	recordOperation("void", mid, auth, actionRequest.Currency, err)
 
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
@@ -138,113 +141,236 @@ func (server *Server) Capture(w http.ResponseWriter, r *http.Request) {
 
 	//construct the response
 	authResponse := models.AuthorizationResponse{
+		ID:              auth.ID,
 		Currency:        auth.CurrencyCard,
-		AmountAvailable: auth.BalanceAuthorised - auth.BalanceCaptured,
+		AmountAvailable: 0,
 	}
 
 	responses.JSON(w, http.StatusCreated, authResponse)
 }
 
-//Refynd handles the request/response for refunding funds 
-func (server *Server) Refund(w http.ResponseWriter, r *http.Request) {
+//recordOperation counts the outcome of an authorize, capture, void or refund request in gateway_operations_total
+//the currency of the authorization is used when there is one, declined authorizations have it too
+//a request is declined when it was recorded as a declined authorization or its error refuses the card or the payment
+func recordOperation(operation string, merchantID uint32, auth *models.Authorization, currency string, err error) {
+	if auth != nil && auth.CurrencyRequested != "" {
+		currency = auth.CurrencyRequested
+	}
+	//anything but an ISO 4217 code would add a series per value the merchant sends, requests without one keep it empty
+	currency = strings.ToUpper(currency)
+	if currency != "" && !models.IsCurrency(currency) {
+		currency = "invalid"
+	}
+
+	outcome, code := "success", ""
+	if err != nil {
+		outcome, code = "error", apierrors.From(err, http.StatusInternalServerError).Code
+		if declined, ok := models.DeclineCode(err); ok {
+			outcome, code = "declined", declined
+		}
+		if auth != nil && auth.IsDeclined() {
+			outcome, code = "declined", auth.DeclineCode
+		}
+	}
+	metrics.Operations.WithLabelValues(operation, strconv.FormatUint(uint64(merchantID), 10), currency, outcome, code).Inc()
+}
+
+//merchantActionRequest authenticates the merchant and reads the action request
+func (server *Server) merchantActionRequest(r *http.Request) (uint32, models.ActionRequest, int, error) {
 	//get the request body and umarshall it into request struct
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return 0, models.ActionRequest{}, http.StatusUnprocessableEntity, err
 	}
 
 	//get the merchant ID and token and validate
-	vars := mux.Vars(r)
-	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	mid, status, err := server.authenticateMerchant(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusBadRequest, err)
-		return
+		return 0, models.ActionRequest{}, status, err
+	}
+
+	actionRequest := models.ActionRequest{}
+	err = json.Unmarshal(body, &actionRequest)
+	if err != nil {
+		return 0, models.ActionRequest{}, http.StatusUnprocessableEntity, err
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	return mid, actionRequest, http.StatusOK, nil
+}
+
+//GetAuthorization handles the request/response for retrieving a single authorization
+func (server *Server) GetAuthorization(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		responses.ERROR(w, status, err)
 		return
 	}
-	if tokenID != uint32(mid) {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+
+	authI := models.NewAuthI()
+	auth, err := authI.FindAuthorizationByID(mid, mux.Vars(r)["id"], server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	actionRequest := models.ActionRequest{}
-	err = json.Unmarshal(body, &actionRequest)
+	detail, err := auth.DetailWithCard(server.requestDB(r))
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	//call the function to request authorization from the auth interface
-	authI := models.NewAuthI()
+	responses.JSON(w, http.StatusOK, detail)
+}
 
-	auth, err := authI.Refund(actionRequest.ID, actionRequest.Amount, actionRequest.Final, server.DB)
+//ListAuthorizations handles the request/response for listing the merchant's authorizations
+//supports the status, decline_code, risk_decision, currency, created_from, created_to, min_amount, max_amount, starting_after and limit query parameters
+func (server *Server) ListAuthorizations(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	filter, err := authorizationFilterFromQuery(r.URL.Query())
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
 
+	authI := models.NewAuthI()
+	page, err := authI.ListAuthorizations(mid, filter, server.requestDB(r))
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	//construct the response
-	authResponse := models.AuthorizationResponse{
-		Currency:        auth.CurrencyCard,
-		AmountAvailable: auth.BalanceAuthorised - auth.BalanceCaptured,
+	responses.JSON(w, http.StatusOK, page)
+}
+
+//authorizationFilterFromQuery parses the list filters, timestamps are RFC 3339 and amounts are in minor units
+func authorizationFilterFromQuery(query url.Values) (filter models.AuthorizationFilter, err error) {
+	filter = models.AuthorizationFilter{
+		Status:        query.Get("status"),
+		DeclineCode:   query.Get("decline_code"),
+		RiskDecision:  query.Get("risk_decision"),
+		Currency:      strings.ToUpper(query.Get("currency")),
+		StartingAfter: query.Get("starting_after"),
 	}
 
-	responses.JSON(w, http.StatusCreated, authResponse)
+	if filter.CreatedFrom, err = timeQueryParam(query, "created_from"); err != nil {
+		return models.AuthorizationFilter{}, err
+	}
+	if filter.CreatedTo, err = timeQueryParam(query, "created_to"); err != nil {
+		return models.AuthorizationFilter{}, err
+	}
+	if filter.MinAmount, err = moneyQueryParam(query, "min_amount"); err != nil {
+		return models.AuthorizationFilter{}, err
+	}
+	if filter.MaxAmount, err = moneyQueryParam(query, "max_amount"); err != nil {
+		return models.AuthorizationFilter{}, err
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return models.AuthorizationFilter{}, apierrors.WithDetail(constants.InvalidQueryParameter, "limit")
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
 }
 
-//Void handles the request/response for voiding a transaction
-func (server *Server) Void(w http.ResponseWriter, r *http.Request) {
-	//get the request body and umarshall it into request struct
-	body, err := ioutil.ReadAll(r.Body)
+//timeQueryParam parses an optional RFC 3339 timestamp
+func timeQueryParam(query url.Values, param string) (*time.Time, error) {
+	value := query.Get(param)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, apierrors.WithDetail(constants.InvalidQueryParameter, param)
 	}
+	return &t, nil
+}
 
-	//get the merchant ID and token and validate
-	vars := mux.Vars(r)
-	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+//moneyQueryParam parses an optional amount in minor units
+func moneyQueryParam(query url.Values, param string) (*models.Money, error) {
+	value := query.Get(param)
+	if value == "" {
+		return nil, nil
+	}
+	amount, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, apierrors.WithDetail(constants.InvalidQueryParameter, param)
+	}
+	money := models.Money(amount)
+	return &money, nil
+}
+
+//ListTransactions handles the request/response for listing the operations performed on an authorization
+func (server *Server) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	authI := models.NewAuthI()
+	auth, err := authI.FindAuthorizationByID(mid, mux.Vars(r)["id"], server.requestDB(r))
 	if err != nil {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
-	if tokenID != uint32(mid) {
-		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+
+	transactionI := models.NewTransactionI()
+	transactions, err := transactionI.ListTransactions(mid, auth.ID, server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	actionRequest := models.ActionRequest{}
-	err = json.Unmarshal(body, &actionRequest)
+	responses.JSON(w, http.StatusOK, transactions)
+}
+
+//ListStatusHistory handles the request/response for listing the status changes of an authorization
+func (server *Server) ListStatusHistory(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
 	if err != nil {
-		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		responses.ERROR(w, status, err)
 		return
 	}
 
-	//call the function to request authorization from the auth interface
 	authI := models.NewAuthI()
+	auth, err := authI.FindAuthorizationByID(mid, mux.Vars(r)["id"], server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 
-	auth, err := authI.Void(actionRequest.ID, server.DB)
-
+	history, err := authI.ListStatusHistory(mid, auth.ID, server.requestDB(r))
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	//construct the response
-	authResponse := models.AuthorizationResponse{
-		Currency:        auth.CurrencyCard,
-		AmountAvailable: 0,
+	responses.JSON(w, http.StatusOK, history)
+}
+
+//GetRiskAssessment handles the request/response for retrieving the fraud screening of an authorization
+func (server *Server) GetRiskAssessment(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
 	}
 
-	responses.JSON(w, http.StatusCreated, authResponse)
+	authI := models.NewAuthI()
+	assessment, err := authI.RiskAssessment(mid, mux.Vars(r)["id"], server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, assessment)
 }
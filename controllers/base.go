@@ -2,20 +2,25 @@ package controllers
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres database driver
 
+	"github.com/xectich/paymentGateway/logger"
+	"github.com/xectich/paymentGateway/metrics"
 	"github.com/xectich/paymentGateway/models"
 )
 
 type Server struct {
-	DB     *gorm.DB
-	Router *mux.Router
+	DB          *gorm.DB
+	Router      *mux.Router
+	Sweeper     *models.AuthorizationSweeper
+	Dispatcher  *models.WebhookDispatcher
+	RateLimiter *models.RateLimiter
 }
 
 //Initialize starts the server by initializing the datebase and routes
@@ -26,13 +31,59 @@ func (server *Server) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, D
 	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", DbHost, DbPort, DbUser, DbName, DbPassword)
 	server.DB, err = gorm.Open(Dbdriver, DBURL)
 	if err != nil {
-		fmt.Printf("Cannot connect to %s database", Dbdriver)
-		log.Fatal("This is the error:", err)
-	} else {
-		fmt.Printf("We are connected to the %s database", Dbdriver)
+		logger.Default().Fatal("cannot connect to the database", logger.Fields{"driver": Dbdriver, "error": err})
 	}
+	logger.Default().Info("connected to the database", logger.Fields{"driver": Dbdriver})
 
-	server.DB.Debug().AutoMigrate(&models.BankAccount{}, &models.Authorization{}, &models.Card{}) //database migration
+	//SQL statements are only logged with DB_DEBUG=true, database errors always are
+	server.DB.SetLogger(logger.GormLogger{Logger: logger.Default()})
+	if os.Getenv("DB_DEBUG") == "true" {
+		server.DB.LogMode(true)
+	}
+	metrics.RegisterDBCallbacks(server.DB)
+
+	vault, err := models.NewVaultFromEnv()
+	if err != nil {
+		logger.Default().Fatal("cannot configure the card vault", logger.Fields{"error": err})
+	}
+	models.SetVault(vault)
+
+	if err = models.MigrateMoneyColumns(server.DB); err != nil {
+		logger.Default().Fatal("cannot migrate money columns", logger.Fields{"error": err})
+	}
+
+	server.DB.AutoMigrate(&models.BankAccount{}, &models.Authorization{}, &models.Card{}, &models.IdempotencyKey{}, &models.FXRate{}, &models.Merchant{}, &models.Transaction{}, &models.Hold{}, &models.CardToken{}, &models.AuthorizationStatusHistory{}, &models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.WebhookAttempt{}, &models.SimulatorScenario{}, &models.RiskRule{}, &models.RiskRuleMatch{}, &models.BINCountry{}, &models.CardVerificationFailure{}, &models.CardLockout{}, &models.RateLimitBucket{}) //database migration
+	if err = models.MigrateCardVault(server.DB); err != nil {
+		logger.Default().Fatal("cannot migrate cards to the vault", logger.Fields{"error": err})
+	}
+	if err = models.MigrateCardBrands(server.DB); err != nil {
+		logger.Default().Fatal("cannot migrate card brands", logger.Fields{"error": err})
+	}
+	if err = models.MigrateSchemaChanges(server.DB); err != nil {
+		logger.Default().Fatal("cannot migrate schema changes", logger.Fields{"error": err})
+	}
+	if err = models.MigrateForeignKeys(server.DB); err != nil {
+		logger.Default().Fatal("cannot migrate foreign keys", logger.Fields{"error": err})
+	}
+
+	fxRateProvider, err := models.NewFXRateProviderFromEnv(server.DB)
+	if err != nil {
+		logger.Default().Fatal("cannot configure the exchange rate provider", logger.Fields{"error": err})
+	}
+	models.SetFXRateProvider(fxRateProvider)
+
+	processorRouter, err := models.NewProcessorRouterFromEnv()
+	if err != nil {
+		logger.Default().Fatal("cannot configure the processors", logger.Fields{"error": err})
+	}
+	models.SetProcessorRouter(processorRouter)
+
+	server.RateLimiter, err = models.NewRateLimiterFromEnv(server.DB)
+	if err != nil {
+		logger.Default().Fatal("cannot configure the rate limits", logger.Fields{"error": err})
+	}
+
+	server.registerGauges()
 
 	server.Router = mux.NewRouter()
 
@@ -40,7 +91,54 @@ func (server *Server) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, D
 }
 
 //Run starts the server and exposes port 8080
+//stale authorizations are expired and webhooks are delivered in the background while the server is running
 func (server *Server) Run(addr string) {
-	fmt.Println("Listening to port 8080")
-	log.Fatal(http.ListenAndServe(addr, server.Router))
+	server.Sweeper = models.NewAuthorizationSweeperFromEnv(server.DB)
+	server.Sweeper.Start()
+	server.Dispatcher = models.NewWebhookDispatcherFromEnv(server.DB)
+	server.Dispatcher.Start()
+
+	logger.Default().Info("listening", logger.Fields{"addr": addr})
+	if err := http.ListenAndServe(addr, server.Router); err != nil {
+		logger.Default().Fatal("server stopped", logger.Fields{"error": err})
+	}
+}
+
+//registerGauges exposes the open authorizations and the held balances, they are read from the DB on every scrape
+func (server *Server) registerGauges() {
+	openStats := func() (*models.OpenAuthorizationStats, error) {
+		stats, err := models.NewAuthI().OpenAuthorizationStats(server.DB)
+		if err != nil {
+			logger.Default().Error("cannot read the open authorizations", logger.Fields{"error": err})
+		}
+		return stats, err
+	}
+
+	metrics.NewGaugeFunc("gateway_open_authorizations", "Authorizations that can still be captured by status.", []string{"status"}, func() ([]metrics.Sample, error) {
+		stats, err := openStats()
+		if err != nil {
+			return nil, err
+		}
+		samples := []metrics.Sample{}
+		for status, count := range stats.OpenAuthorizations {
+			samples = append(samples, metrics.Sample{LabelValues: []string{status}, Value: float64(count)})
+		}
+		return samples, nil
+	})
+	metrics.NewGaugeFunc("gateway_held_balance", "Funds still held on bank accounts for open authorizations by currency, in minor units.", []string{"currency"}, func() ([]metrics.Sample, error) {
+		stats, err := openStats()
+		if err != nil {
+			return nil, err
+		}
+		samples := []metrics.Sample{}
+		for currency, amount := range stats.HeldBalances {
+			samples = append(samples, metrics.Sample{LabelValues: []string{currency}, Value: float64(amount)})
+		}
+		return samples, nil
+	})
+}
+
+//requestDB returns the database handle of the request, its queries and the models it is passed to log with the request's fields
+func (server *Server) requestDB(r *http.Request) *gorm.DB {
+	return logger.WithDB(server.DB, logger.FromContext(r.Context()))
 }
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//RegisterCard handles the request/response for storing a card and returning the merchant's token for it
+func (server *Server) RegisterCard(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	cardRequest := models.CardRequest{}
+	err = json.Unmarshal(body, &cardRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	cardI := models.NewCardI()
+	card, err := cardI.RegisterCard(server.requestDB(r), mid, cardRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, card)
+}
+
+//ListCards handles the request/response for listing the merchant's cards
+func (server *Server) ListCards(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	cardI := models.NewCardI()
+	cards, err := cardI.ListCards(server.requestDB(r), mid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, cards)
+}
+
+//UpdateCardExpiry handles the request/response for setting a new expiry on a card
+func (server *Server) UpdateCardExpiry(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	expiryRequest := models.CardExpiryRequest{}
+	err = json.Unmarshal(body, &expiryRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	cardI := models.NewCardI()
+	card, err := cardI.UpdateCardExpiry(server.requestDB(r), mid, mux.Vars(r)["token"], expiryRequest.ExpirationMonth, expiryRequest.ExpirationYear)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, card)
+}
+
+//DeleteCard handles the request/response for deleting the merchant's token of a card
+func (server *Server) DeleteCard(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	cardI := models.NewCardI()
+	err = cardI.DeleteCard(server.requestDB(r), mid, mux.Vars(r)["token"])
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//WipeCard handles the request/response for removing a card's number from the vault
+func (server *Server) WipeCard(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	cardI := models.NewCardI()
+	err = cardI.WipeCard(server.requestDB(r), mid, mux.Vars(r)["token"])
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//ListCardLockouts handles the admin request for listing the cards locked after failed verification attempts
+//supports the card_token query parameter
+func (server *Server) ListCardLockouts(w http.ResponseWriter, r *http.Request) {
+	cardLockoutI := models.NewCardLockoutI()
+	lockouts, err := cardLockoutI.ListCardLockouts(server.requestDB(r), r.URL.Query().Get("card_token"), time.Now())
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, lockouts)
+}
+
+//UnlockCard handles the admin request for ending a card lockout before it runs out
+func (server *Server) UnlockCard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cardLockoutI := models.NewCardLockoutI()
+	lockout, err := cardLockoutI.UnlockCard(server.requestDB(r), uint32(id))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, lockout)
+}
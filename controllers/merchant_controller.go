@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//Login verifies the merchant's API key and creates a token for the merchant
+func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	loginRequest := models.LoginRequest{}
+	err = json.Unmarshal(body, &loginRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	_, err = merchantI.VerifyCredentials(server.requestDB(r), loginRequest.ID, loginRequest.APIKey)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	token, err := auth.CreateToken(loginRequest.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, apierrors.New(constants.UnableToCreateJWTToken))
+		return
+	}
+	responses.JSON(w, http.StatusOK, token)
+}
+
+//RotateAPIKey issues a new API key for the authenticated merchant, tokens issued before the rotation stop working
+func (server *Server) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, apiKey, err := merchantI.RotateAPIKey(server.requestDB(r), mid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, models.MerchantCredentialsResponse{Merchant: merchant, APIKey: apiKey})
+}
+
+//CreateMerchant handles the admin request for onboarding a new merchant
+func (server *Server) CreateMerchant(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	merchantRequest := models.MerchantRequest{}
+	err = json.Unmarshal(body, &merchantRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, apiKey, err := merchantI.CreateMerchant(server.requestDB(r), merchantRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, models.MerchantCredentialsResponse{Merchant: merchant, APIKey: apiKey})
+}
+
+//DisableMerchant handles the admin request for disabling a merchant
+func (server *Server) DisableMerchant(w http.ResponseWriter, r *http.Request) {
+	server.setMerchantDisabled(w, r, true)
+}
+
+//EnableMerchant handles the admin request for re-enabling a merchant
+func (server *Server) EnableMerchant(w http.ResponseWriter, r *http.Request) {
+	server.setMerchantDisabled(w, r, false)
+}
+
+func (server *Server) setMerchantDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	vars := mux.Vars(r)
+	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, err := merchantI.SetDisabled(server.requestDB(r), uint32(mid), disabled)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, merchant)
+}
+
+//SetAuthorizationTTL handles the admin request for changing how long a merchant's authorizations stay open
+func (server *Server) SetAuthorizationTTL(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	ttlRequest := models.AuthorizationTTLRequest{}
+	err = json.Unmarshal(body, &ttlRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, err := merchantI.SetAuthorizationTTL(server.requestDB(r), uint32(mid), ttlRequest.AuthorizationTTL)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, merchant)
+}
+
+//SetMerchantCountry handles the admin request for changing the country of a merchant
+func (server *Server) SetMerchantCountry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	countryRequest := models.CountryRequest{}
+	err = json.Unmarshal(body, &countryRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, err := merchantI.SetCountry(server.requestDB(r), uint32(mid), countryRequest.Country)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, merchant)
+}
+
+//SetAcceptedCardBrands handles the request/response for restricting the card brands the merchant accepts
+func (server *Server) SetAcceptedCardBrands(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	brandsRequest := models.CardBrandsRequest{}
+	err = json.Unmarshal(body, &brandsRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, err := merchantI.SetAcceptedCardBrands(server.requestDB(r), mid, brandsRequest.AcceptedCardBrands)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, merchant)
+}
+
+//authenticateMerchant checks that the token belongs to the {mid} in the path and that the merchant may still use it
+//returns the merchant ID or the status code and error to respond with
+func (server *Server) authenticateMerchant(r *http.Request) (uint32, int, error) {
+	vars := mux.Vars(r)
+	mid, err := strconv.ParseUint(vars["mid"], 10, 32)
+	if err != nil {
+		return 0, http.StatusBadRequest, err
+	}
+
+	tokenID, err := auth.ExtractTokenID(r)
+	if err != nil {
+		return 0, http.StatusUnauthorized, apierrors.New(constants.Unauthorized)
+	}
+	if tokenID != uint32(mid) {
+		return 0, http.StatusUnauthorized, apierrors.New(constants.Unauthorized)
+	}
+
+	issuedAt, err := auth.ExtractTokenIssuedAt(r)
+	if err != nil {
+		return 0, http.StatusUnauthorized, apierrors.New(constants.Unauthorized)
+	}
+
+	merchantI := models.NewMerchantI()
+	if _, err = merchantI.VerifyToken(server.requestDB(r), tokenID, issuedAt); err != nil {
+		return 0, apierrors.From(err, http.StatusUnauthorized).Status, err
+	}
+
+	return tokenID, http.StatusOK, nil
+}
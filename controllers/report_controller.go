@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//ApprovalRate handles the request/response for the approval rate of the merchant's authorizations
+//supports the created_from and created_to query parameters
+func (server *Server) ApprovalRate(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	filter, err := approvalRateFilterFromQuery(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.MerchantID = mid
+
+	authI := models.NewAuthI()
+	report, err := authI.ApprovalRates(filter, server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	//the merchant's own rate is the total of a report on the merchant alone
+	rate := report.Total
+	rate.MerchantID = mid
+	responses.JSON(w, http.StatusOK, rate)
+}
+
+//ApprovalRates handles the admin request for the approval rates of every merchant
+//supports the merchant_id, created_from and created_to query parameters
+func (server *Server) ApprovalRates(w http.ResponseWriter, r *http.Request) {
+	filter, err := approvalRateFilterFromQuery(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if value := r.URL.Query().Get("merchant_id"); value != "" {
+		mid, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || mid == 0 {
+			responses.ERROR(w, http.StatusBadRequest, apierrors.WithDetail(constants.InvalidQueryParameter, "merchant_id"))
+			return
+		}
+		filter.MerchantID = uint32(mid)
+	}
+
+	authI := models.NewAuthI()
+	report, err := authI.ApprovalRates(filter, server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, report)
+}
+
+//approvalRateFilterFromQuery parses the report period, timestamps are RFC 3339
+func approvalRateFilterFromQuery(r *http.Request) (filter models.ApprovalRateFilter, err error) {
+	query := r.URL.Query()
+	if filter.CreatedFrom, err = timeQueryParam(query, "created_from"); err != nil {
+		return models.ApprovalRateFilter{}, err
+	}
+	if filter.CreatedTo, err = timeQueryParam(query, "created_to"); err != nil {
+		return models.ApprovalRateFilter{}, err
+	}
+	return filter, nil
+}
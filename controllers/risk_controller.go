@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//CreateRiskRule handles the admin request for adding a fraud screening rule
+func (server *Server) CreateRiskRule(w http.ResponseWriter, r *http.Request) {
+	ruleRequest, err := riskRuleRequestFromBody(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	rule, err := riskRuleI.CreateRiskRule(server.requestDB(r), ruleRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, rule)
+}
+
+//ListRiskRules handles the admin request for listing the fraud screening rules
+func (server *Server) ListRiskRules(w http.ResponseWriter, r *http.Request) {
+	riskRuleI := models.NewRiskRuleI()
+	rules, err := riskRuleI.ListRiskRules(server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rules)
+}
+
+//GetRiskRule handles the admin request for retrieving a fraud screening rule
+func (server *Server) GetRiskRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	rule, err := riskRuleI.FindRiskRuleByID(server.requestDB(r), uint32(id))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rule)
+}
+
+//UpdateRiskRule handles the admin request for replacing the settings of a fraud screening rule
+func (server *Server) UpdateRiskRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ruleRequest, err := riskRuleRequestFromBody(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	rule, err := riskRuleI.UpdateRiskRule(server.requestDB(r), uint32(id), ruleRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, rule)
+}
+
+//DeleteRiskRule handles the admin request for removing a fraud screening rule
+func (server *Server) DeleteRiskRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	if err = riskRuleI.DeleteRiskRule(server.requestDB(r), uint32(id)); err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//SetBINCountry handles the admin request for setting the issuing country of a BIN prefix
+func (server *Server) SetBINCountry(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	binCountry := models.BINCountry{}
+	err = json.Unmarshal(body, &binCountry)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	stored, err := riskRuleI.SetBINCountry(server.requestDB(r), binCountry)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, stored)
+}
+
+//ListBINCountries handles the admin request for listing the issuing countries of the BIN prefixes
+func (server *Server) ListBINCountries(w http.ResponseWriter, r *http.Request) {
+	riskRuleI := models.NewRiskRuleI()
+	binCountries, err := riskRuleI.ListBINCountries(server.requestDB(r))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, binCountries)
+}
+
+//DeleteBINCountry handles the admin request for removing the issuing country of a BIN prefix
+func (server *Server) DeleteBINCountry(w http.ResponseWriter, r *http.Request) {
+	riskRuleI := models.NewRiskRuleI()
+	if err := riskRuleI.DeleteBINCountry(server.requestDB(r), mux.Vars(r)["prefix"]); err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//riskRuleRequestFromBody decodes the rule settings of a request
+func riskRuleRequestFromBody(r *http.Request) (models.RiskRuleRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return models.RiskRuleRequest{}, err
+	}
+	ruleRequest := models.RiskRuleRequest{}
+	if err = json.Unmarshal(body, &ruleRequest); err != nil {
+		return models.RiskRuleRequest{}, err
+	}
+	return ruleRequest, nil
+}
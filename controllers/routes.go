@@ -1,15 +1,73 @@
 package controllers
 
-import "github.com/xectich/paymentGateway/middlewares"
+import (
+	"github.com/xectich/paymentGateway/metrics"
+	"github.com/xectich/paymentGateway/middlewares"
+)
 
 //initializeRoute: used when creating the server to init the routes
 func (s *Server) initializeRoutes() {
+	s.Router.Use(middlewares.SetMiddlewareRequestID)
+	s.Router.Use(middlewares.SetMiddlewareLogging)
+	s.Router.Use(middlewares.SetMiddlewareMetrics)
+	s.Router.Use(middlewares.SetMiddlewareRateLimit(s.RateLimiter))
+
+	//Metrics route, scraped by Prometheus
+	s.Router.HandleFunc("/metrics", middlewares.SetMiddlewareMetricsToken(metrics.Handler().ServeHTTP)).Methods("GET")
+
 	// Login Route
 	s.Router.HandleFunc("/login", middlewares.SetMiddlewareJSON(s.Login)).Methods("POST")
 
+	//Merchant routes
+	s.Router.HandleFunc("/{mid}/rotate-key", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.RotateAPIKey))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/card-brands", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.SetAcceptedCardBrands))).Methods("PUT")
+
+	//Admin routes
+	s.Router.HandleFunc("/admin/merchants", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.CreateMerchant))).Methods("POST")
+	s.Router.HandleFunc("/admin/merchants/{mid}/disable", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.DisableMerchant))).Methods("POST")
+	s.Router.HandleFunc("/admin/merchants/{mid}/enable", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.EnableMerchant))).Methods("POST")
+	s.Router.HandleFunc("/admin/merchants/{mid}/authorization-ttl", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.SetAuthorizationTTL))).Methods("PUT")
+	s.Router.HandleFunc("/admin/merchants/{mid}/country", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.SetMerchantCountry))).Methods("PUT")
+	s.Router.HandleFunc("/admin/reports/approval-rates", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.ApprovalRates))).Methods("GET")
+	s.Router.HandleFunc("/admin/card-lockouts", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.ListCardLockouts))).Methods("GET")
+	s.Router.HandleFunc("/admin/card-lockouts/{id}/unlock", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.UnlockCard))).Methods("POST")
+
+	//Risk routes
+	s.Router.HandleFunc("/admin/risk/rules", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.CreateRiskRule))).Methods("POST")
+	s.Router.HandleFunc("/admin/risk/rules", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.ListRiskRules))).Methods("GET")
+	s.Router.HandleFunc("/admin/risk/rules/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.GetRiskRule))).Methods("GET")
+	s.Router.HandleFunc("/admin/risk/rules/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.UpdateRiskRule))).Methods("PUT")
+	s.Router.HandleFunc("/admin/risk/rules/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.DeleteRiskRule))).Methods("DELETE")
+	s.Router.HandleFunc("/admin/risk/bin-countries", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.SetBINCountry))).Methods("PUT")
+	s.Router.HandleFunc("/admin/risk/bin-countries", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.ListBINCountries))).Methods("GET")
+	s.Router.HandleFunc("/admin/risk/bin-countries/{prefix}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAdmin(s.DeleteBINCountry))).Methods("DELETE")
+
 	//Authorization routes
-	s.Router.HandleFunc("/{mid}/authorize", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.RequestAuthorization))).Methods("PUT")
-	s.Router.HandleFunc("/{mid}/capture", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.Capture))).Methods("POST")
-	s.Router.HandleFunc("/{mid}/void", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.Void))).Methods("POST")
-	s.Router.HandleFunc("/{mid}/refund", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.Refund))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/authorize", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.SetMiddlewareIdempotency(s.DB, s.RequestAuthorization)))).Methods("PUT")
+	s.Router.HandleFunc("/{mid}/capture", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.SetMiddlewareIdempotency(s.DB, s.Capture)))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/void", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.SetMiddlewareIdempotency(s.DB, s.Void)))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/refund", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.SetMiddlewareIdempotency(s.DB, s.Refund)))).Methods("POST")
+
+	//Card routes
+	s.Router.HandleFunc("/{mid}/cards", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.RegisterCard))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/cards", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListCards))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/cards/{token}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateCardExpiry))).Methods("PUT")
+	s.Router.HandleFunc("/{mid}/cards/{token}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.DeleteCard))).Methods("DELETE")
+	s.Router.HandleFunc("/{mid}/cards/{token}/wipe", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.WipeCard))).Methods("POST")
+
+	//Webhook routes
+	s.Router.HandleFunc("/{mid}/webhooks", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.RegisterWebhook))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/webhooks", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListWebhooks))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/webhooks/deliveries", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListWebhookDeliveries))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/webhooks/deliveries/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetWebhookDelivery))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/webhooks/deliveries/{id}/redeliver", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.RedeliverWebhook))).Methods("POST")
+	s.Router.HandleFunc("/{mid}/webhooks/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.DeleteWebhook))).Methods("DELETE")
+
+	//Reporting routes
+	s.Router.HandleFunc("/{mid}/authorizations", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListAuthorizations))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/authorizations/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetAuthorization))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/authorizations/{id}/transactions", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListTransactions))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/authorizations/{id}/history", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ListStatusHistory))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/authorizations/{id}/risk", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetRiskAssessment))).Methods("GET")
+	s.Router.HandleFunc("/{mid}/reports/approval-rate", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ApprovalRate))).Methods("GET")
 }
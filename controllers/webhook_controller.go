@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//RegisterWebhook handles the request/response for registering a webhook endpoint
+func (server *Server) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	endpointRequest := models.WebhookEndpointRequest{}
+	err = json.Unmarshal(body, &endpointRequest)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	endpoint, secret, err := webhookI.RegisterEndpoint(server.requestDB(r), mid, endpointRequest.URL)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusCreated, models.WebhookEndpointResponse{Endpoint: endpoint, Secret: secret})
+}
+
+//ListWebhooks handles the request/response for listing the merchant's webhook endpoints
+func (server *Server) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	endpoints, err := webhookI.ListEndpoints(server.requestDB(r), mid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, endpoints)
+}
+
+//DeleteWebhook handles the request/response for removing a webhook endpoint
+func (server *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	err = webhookI.DeleteEndpoint(server.requestDB(r), mid, uint32(id))
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//ListWebhookDeliveries handles the request/response for listing the merchant's most recent deliveries
+func (server *Server) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	deliveries, err := webhookI.ListDeliveries(server.requestDB(r), mid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, deliveries)
+}
+
+//GetWebhookDelivery handles the request/response for retrieving a delivery with the log of its attempts
+func (server *Server) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	delivery, err := webhookI.FindDeliveryByID(server.requestDB(r), mid, id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, delivery)
+}
+
+//RedeliverWebhook handles the request/response for sending a delivery again
+func (server *Server) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	mid, status, err := server.authenticateMerchant(r)
+	if err != nil {
+		responses.ERROR(w, status, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	webhookI := models.NewWebhookI()
+	delivery, err := webhookI.Redeliver(server.requestDB(r), mid, id)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusAccepted, delivery)
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const dbLoggerKey = "paymentGateway:logger"
+
+// gorm logger writing SQL statements at the debug level and database errors at the error level
+// statements are logged with their placeholders, the values sent with them are never logged
+type GormLogger struct {
+	Logger *Logger
+}
+
+//Print receives gorm's log lines, "sql" lines come with the source, duration, statement, values and rows affected
+func (g GormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+
+	switch values[0] {
+	case "sql":
+		if len(values) < 6 {
+			return
+		}
+		fields := Fields{"source": values[1], "sql": values[3], "rows": values[5]}
+		if duration, ok := values[2].(time.Duration); ok {
+			fields["duration_ms"] = float64(duration) / float64(time.Millisecond)
+		}
+		g.Logger.Debug("sql", fields)
+	default:
+		g.Logger.Error("database error", Fields{"source": values[1], "error": fmt.Sprint(values[2:]...)})
+	}
+}
+
+//WithDB returns a copy of db whose queries and errors are logged by the logger
+//models called with it can log with the same fields, e.g. the request ID, through FromDB
+func WithDB(db *gorm.DB, l *Logger) *gorm.DB {
+	db = db.Set(dbLoggerKey, l)
+	db.SetLogger(GormLogger{Logger: l})
+	return db
+}
+
+//FromDB returns the logger of the request db was made for, the default logger when there is none
+func FromDB(db *gorm.DB) *Logger {
+	if value, ok := db.Get(dbLoggerKey); ok {
+		if l, ok := value.(*Logger); ok {
+			return l
+		}
+	}
+	return Default()
+}
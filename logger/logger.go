@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// fields added to a log line, e.g. logger.Fields{"merchant_id": 123456}
+type Fields map[string]interface{}
+
+// leveled logger writing one JSON object per line, card numbers and CVVs are redacted from every line
+type Logger struct {
+	level  constants.LogLevel
+	fields Fields
+	out    io.Writer
+	mu     *sync.Mutex
+}
+
+type contextKey struct{}
+
+var (
+	defaultLoggerMu sync.RWMutex
+	defaultLogger   = New(os.Stdout, constants.LogInfo)
+)
+
+//New writes the lines from the level up to out
+func New(out io.Writer, level constants.LogLevel) *Logger {
+	return &Logger{level: level, fields: Fields{}, out: out, mu: &sync.Mutex{}}
+}
+
+//NewFromEnv writes to stdout from the level set by LOG_LEVEL (debug, info, warn or error), info when unset
+func NewFromEnv() *Logger {
+	level := constants.LogLevel(constants.LogInfo)
+	for l := constants.LogLevel(constants.LogDebug); l <= constants.LogError; l++ {
+		if l.String() == strings.ToLower(os.Getenv("LOG_LEVEL")) {
+			level = l
+		}
+	}
+	return New(os.Stdout, level)
+}
+
+//SetDefault replaces the logger used outside of requests
+func SetDefault(l *Logger) {
+	defaultLoggerMu.Lock()
+	defer defaultLoggerMu.Unlock()
+	defaultLogger = l
+}
+
+//Default returns the logger used outside of requests
+func Default() *Logger {
+	defaultLoggerMu.RLock()
+	defer defaultLoggerMu.RUnlock()
+	return defaultLogger
+}
+
+//NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+//FromContext returns the logger of the request, the default logger when there is none
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+//With returns a logger adding the fields to every line
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{level: l.level, fields: merged, out: l.out, mu: l.mu}
+}
+
+//Enabled tells whether lines of the level are written
+func (l *Logger) Enabled(level constants.LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.write(constants.LogDebug, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.write(constants.LogInfo, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Fields) {
+	l.write(constants.LogWarn, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.write(constants.LogError, msg, fields)
+}
+
+//Fatal writes the line at the error level and stops the program
+func (l *Logger) Fatal(msg string, fields ...Fields) {
+	l.write(constants.LogError, msg, fields)
+	os.Exit(1)
+}
+
+//write encodes the line with time, level and msg first and the fields in alphabetical order
+func (l *Logger) write(level constants.LogLevel, msg string, fields []Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	lineFields := Fields{}
+	for k, v := range l.fields {
+		lineFields[k] = v
+	}
+	for _, f := range fields {
+		for k, v := range f {
+			lineFields[k] = v
+		}
+	}
+	keys := make([]string, 0, len(lineFields))
+	for k := range lineFields {
+		if k != "time" && k != "level" && k != "msg" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	line.Write(encode(time.Now().UTC().Format(time.RFC3339Nano)))
+	line.WriteString(`,"level":`)
+	line.Write(encode(constants.LogLevel(level).String()))
+	line.WriteString(`,"msg":`)
+	line.Write(encode(msg))
+	for _, k := range keys {
+		line.WriteString(",")
+		line.Write(encode(k))
+		line.WriteString(":")
+		line.Write(encode(redactField(k, lineFields[k])))
+	}
+	line.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write([]byte(Redact(line.String())))
+}
+
+//encode returns the JSON of the value, errors and values that cannot be encoded are written as text
+func encode(value interface{}) []byte {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return b
+}
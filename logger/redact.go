@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+//sensitiveFields are the field names whose values are never logged, compared without case, "_" and "-"
+var sensitiveFields = map[string]bool{
+	"cvv":           true,
+	"cvc":           true,
+	"number":        true,
+	"cardnumber":    true,
+	"pan":           true,
+	"password":      true,
+	"apikey":        true,
+	"authorization": true,
+}
+
+var (
+	//cardNumberPattern finds 13 to 19 digits, optionally grouped with spaces or dashes
+	cardNumberPattern = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+	//cvvPattern finds CVVs written as key and value, e.g. "cvv":"123" or cvv=123, also inside escaped JSON
+	cvvPattern = regexp.MustCompile(`(?i)(\b(?:cvv|cvc)\\?"?\s*[:=]\s*\\?"?)\d{3,4}`)
+)
+
+//Redact masks the card numbers and CVVs found in s, card numbers keep their last 4 digits
+//every run of 13 to 19 digits is taken for a card number, a mistyped one failing the Luhn check is masked as well
+func Redact(s string) string {
+	s = cardNumberPattern.ReplaceAllStringFunc(s, func(match string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	})
+	return cvvPattern.ReplaceAllString(s, "${1}***")
+}
+
+//redactField hides the value of a sensitive field
+func redactField(key string, value interface{}) interface{} {
+	name := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	if sensitiveFields[name] {
+		return redacted
+	}
+	return value
+}
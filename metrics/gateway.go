@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const dbStartKey = "metrics:start"
+
+//the metrics fed by the controllers, the middlewares and the models
+var (
+	Operations = NewCounterVec("gateway_operations_total",
+		"Authorize, capture, void and refund requests by outcome (success, declined or error) and error code.",
+		"operation", "merchant", "currency", "outcome", "code")
+	HTTPRequestDuration = NewHistogramVec("gateway_http_request_duration_seconds",
+		"Time taken to handle requests by route and status.",
+		DefaultBuckets, "method", "route", "status")
+	DBQueryDuration = NewHistogramVec("gateway_db_query_duration_seconds",
+		"Time taken by DB queries by operation and table.",
+		DefaultBuckets, "operation", "table")
+	FXLookupDuration = NewHistogramVec("gateway_fx_lookup_duration_seconds",
+		"Time taken to look up exchange rates by provider.",
+		DefaultBuckets, "provider")
+	FXLookupFailures = NewCounterVec("gateway_fx_lookup_failures_total",
+		"Exchange rate lookups that failed by provider and error code.",
+		"provider", "code")
+)
+
+//RegisterDBCallbacks times every create, query, update and delete made through db in DBQueryDuration
+func RegisterDBCallbacks(db *gorm.DB) {
+	callbacks := db.Callback()
+	callbacks.Create().Before("gorm:create").Register("metrics:before_create", startDBTimer)
+	callbacks.Create().After("gorm:create").Register("metrics:after_create", observeDBTimer("create"))
+	callbacks.Query().Before("gorm:query").Register("metrics:before_query", startDBTimer)
+	callbacks.Query().After("gorm:query").Register("metrics:after_query", observeDBTimer("query"))
+	callbacks.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", startDBTimer)
+	callbacks.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", observeDBTimer("row_query"))
+	callbacks.Update().Before("gorm:update").Register("metrics:before_update", startDBTimer)
+	callbacks.Update().After("gorm:update").Register("metrics:after_update", observeDBTimer("update"))
+	callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", startDBTimer)
+	callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", observeDBTimer("delete"))
+}
+
+func startDBTimer(scope *gorm.Scope) {
+	scope.Set(dbStartKey, time.Now())
+}
+
+func observeDBTimer(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(dbStartKey)
+		if !ok {
+			return
+		}
+		if start, ok := value.(time.Time); ok {
+			DBQueryDuration.WithLabelValues(operation, scope.TableName()).Observe(time.Since(start).Seconds())
+		}
+	}
+}
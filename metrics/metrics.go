@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//DefaultBuckets are the upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//DefaultRegistry holds the metrics created with the New functions of this package, it is the only one written on /metrics
+var DefaultRegistry = prometheus.NewRegistry()
+
+//Handler serves the metrics of the default registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(DefaultRegistry, promhttp.HandlerOpts{})
+}
+
+//register adds the metric, replacing an earlier one with the same name
+func register(c prometheus.Collector) {
+	if err := DefaultRegistry.Register(c); err != nil {
+		if already, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			DefaultRegistry.Unregister(already.ExistingCollector)
+			DefaultRegistry.MustRegister(c)
+			return
+		}
+		panic(err)
+	}
+}
+
+//NewCounterVec creates a counter split by label values in the default registry, e.g. operations by merchant and outcome
+func NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	register(c)
+	return c
+}
+
+//NewHistogramVec creates a histogram split by label values in the default registry, buckets are the upper bounds in increasing order
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	register(h)
+	return h
+}
+
+// generic information about a gauge value, LabelValues are in the order of the gauge's labels
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// gauge read when the metrics are scraped, e.g. from the DB
+type GaugeFunc struct {
+	desc    *prometheus.Desc
+	collect func() ([]Sample, error)
+}
+
+//NewGaugeFunc creates a gauge in the default registry, a scrape where collect fails leaves the gauge out
+func NewGaugeFunc(name, help string, labels []string, collect func() ([]Sample, error)) *GaugeFunc {
+	g := &GaugeFunc{desc: prometheus.NewDesc(name, help, labels, nil), collect: collect}
+	register(g)
+	return g
+}
+
+//Describe implements prometheus.Collector
+func (g *GaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+//Collect implements prometheus.Collector
+func (g *GaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	samples, err := g.collect()
+	if err != nil {
+		return
+	}
+	for _, sample := range samples {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, sample.Value, sample.LabelValues...)
+	}
+}
@@ -0,0 +1,165 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+	defaultIdempotencyLease  = 2 * time.Minute
+)
+
+//responseRecorder keeps a copy of the status code and body written by the wrapped handler
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+//SetMiddlewareIdempotency replays the stored response when a merchant retries a request with the same Idempotency-Key
+//requests without the header are passed through untouched, the merchant is checked before a response is replayed
+func SetMiddlewareIdempotency(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			responses.ERROR(w, http.StatusBadRequest, apierrors.New(constants.InvalidIdempotencyKey))
+			return
+		}
+
+		merchantID, err := auth.ExtractTokenID(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, apierrors.New(constants.Unauthorized))
+			return
+		}
+		issuedAt, err := auth.ExtractTokenIssuedAt(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, apierrors.New(constants.Unauthorized))
+			return
+		}
+
+		//a disabled merchant or a token revoked by a key rotation must not get stored responses either
+		db := logger.WithDB(db, logger.FromContext(r.Context()))
+		if _, err = models.NewMerchantI().VerifyToken(db, merchantID, issuedAt); err != nil {
+			responses.ERROR(w, apierrors.From(err, http.StatusUnauthorized).Status, err)
+			return
+		}
+
+		//read the body for hashing and put it back for the handler
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+		hash := requestHash(r, body)
+		idempotencyI := models.NewIdempotencyKeyI()
+		record, created, err := idempotencyI.ReserveIdempotencyKey(db, merchantID, key, hash, idempotencyKeyTTL(), idempotencyKeyLease())
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !created {
+			if record.RequestHash != hash {
+				responses.ERROR(w, http.StatusUnprocessableEntity, apierrors.New(constants.IdempotencyKeyReused))
+				return
+			}
+			if record.StatusCode == 0 {
+				responses.ERROR(w, http.StatusConflict, apierrors.New(constants.IdempotencyKeyInProgress))
+				return
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write([]byte(record.ResponseBody))
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		//a handler that panicked gives the key back before the panic goes on
+		defer func() {
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(db, idempotencyI, record.ID)
+				panic(p)
+			}
+		}()
+		next(rec, r)
+
+		//server errors are not stored so the merchant can safely retry them
+		if rec.statusCode == 0 || rec.statusCode >= http.StatusInternalServerError {
+			releaseIdempotencyKey(db, idempotencyI, record.ID)
+			return
+		}
+		if err = idempotencyI.SaveIdempotentResponse(db, record.ID, rec.statusCode, rec.body.String()); err != nil {
+			logger.FromDB(db).Error("cannot store idempotent response", logger.Fields{"idempotency_key_id": record.ID, "error": err})
+		}
+	}
+}
+
+//releaseIdempotencyKey gives the key back for a retry, a key that cannot be released is freed once its lease ran out
+func releaseIdempotencyKey(db *gorm.DB, idempotencyI models.IdempotencyKeyI, id uint32) {
+	if err := idempotencyI.ReleaseIdempotencyKey(db, id); err != nil {
+		logger.FromDB(db).Error("cannot release idempotency key", logger.Fields{"idempotency_key_id": id, "error": err})
+	}
+}
+
+//requestHash fingerprints the request so a reused key with a different payload can be detected
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//idempotencyKeyLease reads from IDEMPOTENCY_KEY_LEASE how long a request keeps its key before a retry may take it over
+//it must be longer than a request can take, e.g. a capture waiting for an operation lease and then for the processor
+func idempotencyKeyLease() time.Duration {
+	lease, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_LEASE"))
+	if err != nil || lease <= 0 {
+		return defaultIdempotencyLease
+	}
+	return lease
+}
+
+//idempotencyKeyTTL reads how long keys are kept from IDEMPOTENCY_KEY_TTL, e.g. "24h"
+func idempotencyKeyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultIdempotencyKeyTTL
+	}
+	return ttl
+}
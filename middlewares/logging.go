@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/logger"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//statusRecorder keeps the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+//SetMiddlewareLogging puts a logger tagged with the request ID in the request context and writes an access log line
+//once the request is done, it has to run after SetMiddlewareRequestID
+func SetMiddlewareLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		l := logger.Default().With(logger.Fields{"request_id": r.Header.Get(responses.RequestIDHeader)})
+		r = r.WithContext(logger.NewContext(r.Context(), l))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+
+		fields := logger.Fields{
+			"method":     r.Method,
+			"route":      routeName(r),
+			"status":     rec.statusCode,
+			"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			"ip":         clientIP(r),
+		}
+		if auth.ExtractToken(r) != "" {
+			if merchantID, err := auth.ExtractTokenID(r); err == nil && merchantID != 0 {
+				fields["merchant_id"] = merchantID
+			}
+		}
+
+		switch {
+		case rec.statusCode >= http.StatusInternalServerError:
+			l.Error("request", fields)
+		case rec.statusCode >= http.StatusBadRequest:
+			l.Warn("request", fields)
+		default:
+			l.Info("request", fields)
+		}
+	})
+}
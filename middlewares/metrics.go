@@ -0,0 +1,22 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xectich/paymentGateway/metrics"
+)
+
+//SetMiddlewareMetrics records how long every request took by method, route template and status
+func SetMiddlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+		metrics.HTTPRequestDuration.WithLabelValues(r.Method, routeTemplate(r), strconv.Itoa(rec.statusCode)).Observe(time.Since(start).Seconds())
+	})
+}
@@ -1,10 +1,13 @@
 package middlewares
 
 import (
-	"errors"
+	"crypto/subtle"
 	"net/http"
+	"os"
 
+	"github.com/xectich/paymentGateway/apierrors"
 	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
 	"github.com/xectich/paymentGateway/responses"
 )
 
@@ -19,9 +22,34 @@ func SetMiddlewareAuthentication(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := auth.TokenValid(r)
 		if err != nil {
-			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			responses.ERROR(w, http.StatusUnauthorized, apierrors.New(constants.Unauthorized))
 			return
 		}
 		next(w, r)
 	}
-}
\ No newline at end of file
+}
+
+//SetMiddlewareMetricsToken only lets requests through with "Authorization: Bearer <METRICS_TOKEN>"
+//the metrics show every merchant's volumes so they are never served while METRICS_TOKEN is unset
+func SetMiddlewareMetricsToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("METRICS_TOKEN")
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			responses.ERROR(w, http.StatusUnauthorized, apierrors.New(constants.Unauthorized))
+			return
+		}
+		next(w, r)
+	}
+}
+
+//SetMiddlewareAdmin only lets requests through when the X-Admin-Key header matches ADMIN_API_KEY
+func SetMiddlewareAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminKey := os.Getenv("ADMIN_API_KEY")
+		if adminKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(adminKey)) != 1 {
+			responses.ERROR(w, http.StatusUnauthorized, apierrors.New(constants.Unauthorized))
+			return
+		}
+		next(w, r)
+	}
+}
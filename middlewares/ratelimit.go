@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+//SetMiddlewareRateLimit refuses requests with 429 once the client has used up its limit for the route
+//clients are the merchant of the JWT, or the IP address for requests without one such as /login
+func SetMiddlewareRateLimit(limiter *models.RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, merchantID := rateLimitClient(r)
+			result, limited, err := limiter.Allow(client, merchantID, routeName(r), time.Now())
+			if err != nil {
+				//the request goes through rather than failing because the limits could not be checked
+				logger.FromContext(r.Context()).Error("cannot check the rate limit", logger.Fields{"client": client, "error": err})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !limited {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+			w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+			w.Header().Set(RateLimitResetHeader, strconv.FormatInt(int64(math.Ceil(float64(result.Reset.UnixNano())/float64(time.Second))), 10))
+			if !result.Allowed {
+				retryAfter := int64(math.Ceil(result.RetryAfter.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set(RetryAfterHeader, strconv.FormatInt(retryAfter, 10))
+				responses.ERROR(w, http.StatusTooManyRequests, apierrors.New(constants.RateLimited))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//rateLimitClient returns who the request is counted against with the merchant ID, 0 for requests counted by IP
+func rateLimitClient(r *http.Request) (string, uint32) {
+	if auth.ExtractToken(r) != "" {
+		if merchantID, err := auth.ExtractTokenID(r); err == nil && merchantID != 0 {
+			return "merchant:" + strconv.FormatUint(uint64(merchantID), 10), merchantID
+		}
+	}
+	return "ip:" + clientIP(r), 0
+}
+
+//clientIP returns the address the request came from
+//behind a load balancer, when RATE_LIMIT_TRUST_PROXY is true, it is the X-Forwarded-For address added by the outermost
+//of the RATE_LIMIT_PROXY_HOPS trusted proxies, 1 unless set, the addresses before it are sent by the client and can be anything
+func clientIP(r *http.Request) string {
+	if os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true" {
+		if forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ","); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			hops, err := strconv.Atoi(os.Getenv("RATE_LIMIT_PROXY_HOPS"))
+			if err != nil || hops < 1 {
+				hops = 1
+			}
+			if hops > len(addresses) {
+				hops = len(addresses)
+			}
+			return strings.TrimSpace(addresses[len(addresses)-hops])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//routeName returns the method and path template of the matched route, e.g. "PUT /{mid}/authorize"
+func routeName(r *http.Request) string {
+	return r.Method + " " + routeTemplate(r)
+}
+
+//routeTemplate returns the path template of the matched route, the path when there is none
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
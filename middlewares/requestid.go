@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/responses"
+)
+
+//requestIDPattern limits the request IDs taken from clients to short values that are safe to log and echo back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+//SetMiddlewareRequestID tags every request with an ID that is returned in the X-Request-Id header and in error responses
+//a valid ID sent by the client is kept so requests can be traced across systems
+func SetMiddlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(responses.RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = "req_" + ksuid.New().String()
+			r.Header.Set(responses.RequestIDHeader, requestID)
+		}
+		w.Header().Set(responses.RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
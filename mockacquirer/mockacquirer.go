@@ -0,0 +1,287 @@
+package mockacquirer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/models"
+)
+
+// generic information about an authorization kept by the mock acquirer
+type authorization struct {
+	Amount   models.Money
+	Captured models.Money
+	Refunded models.Money
+	Closed   bool
+}
+
+// generic information about an approved operation, a request with the same operation ID gets the same answer
+type operation struct {
+	ID     string
+	Amount models.Money
+	Final  bool
+}
+
+// acquirer keeping its authorizations in memory, used to run the gateway against the HTTP processor locally
+// the outcome of an authorization is chosen by the last two digits of the amount in minor units:
+// 51 declines for insufficient funds, 05 declines with do not honor, 14 declines the card and 96 fails
+// any operation for an amount ending in 97 is applied after Delay, so the gateway can give up waiting for it
+type Acquirer struct {
+	APIKey string
+	Delay  time.Duration
+
+	mu             sync.Mutex
+	authorizations map[string]*authorization
+	operations     map[string]operation
+	gatewayIDs     map[string]string
+	reversed       map[string]bool
+}
+
+func NewAcquirer(apiKey string) *Acquirer {
+	return &Acquirer{
+		APIKey:         apiKey,
+		authorizations: map[string]*authorization{},
+		operations:     map[string]operation{},
+		gatewayIDs:     map[string]string{},
+		reversed:       map[string]bool{},
+	}
+}
+
+//Handler returns the routes of the acquirer API
+func (a *Acquirer) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/authorizations", a.authorize).Methods("POST")
+	router.HandleFunc("/authorizations/{id}/captures", a.capture).Methods("POST")
+	router.HandleFunc("/authorizations/{id}/voids", a.void).Methods("POST")
+	router.HandleFunc("/authorizations/{id}/refunds", a.refund).Methods("POST")
+	router.HandleFunc("/reversals", a.reverse).Methods("POST")
+	return router
+}
+
+//Held returns the amount still held by the authorizations that were not closed or reversed
+func (a *Acquirer) Held() models.Money {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var held models.Money
+	for _, auth := range a.authorizations {
+		if !auth.Closed {
+			held += auth.Amount - auth.Captured
+		}
+	}
+	return held
+}
+
+//Captured returns the amount captured for an authorization of the gateway
+func (a *Acquirer) Captured(authorizationID string) models.Money {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	auth, ok := a.authorizations[a.gatewayIDs[authorizationID]]
+	if !ok {
+		return 0
+	}
+	return auth.Captured
+}
+
+func (a *Acquirer) authorize(w http.ResponseWriter, r *http.Request) {
+	request, ok := a.readRequest(w, r)
+	if !ok {
+		return
+	}
+	if request.Card == nil || request.Amount <= 0 {
+		respond(w, "", models.ResponseInvalidAmount)
+		return
+	}
+
+	switch request.Amount % 100 {
+	case 51:
+		respond(w, "", models.ResponseInsufficientFunds)
+		return
+	case 5:
+		respond(w, "", models.ResponseDoNotHonor)
+		return
+	case 14:
+		respond(w, "", models.ResponseInvalidCard)
+		return
+	case 96:
+		respond(w, "", models.ResponseSystemError)
+		return
+	}
+
+	a.delay(request)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id, responseCode, ok := a.repeated(request); ok {
+		respond(w, id, responseCode)
+		return
+	}
+	//the gateway reversed the authorization before it got here, nothing is held for it
+	if a.reversed[request.AuthorizationID] {
+		respond(w, "", models.ResponseDoNotHonor)
+		return
+	}
+
+	id := "acq_" + ksuid.New().String()
+	a.authorizations[id] = &authorization{Amount: request.Amount}
+	if request.AuthorizationID != "" {
+		a.gatewayIDs[request.AuthorizationID] = id
+	}
+	a.approved(request, id)
+	respond(w, id, models.ResponseApproved)
+}
+
+//reverse releases an authorization the gateway got no answer for, it is found by the gateway's authorization ID
+//an authorization that has not arrived yet is declined once it does
+func (a *Acquirer) reverse(w http.ResponseWriter, r *http.Request) {
+	request, ok := a.readRequest(w, r)
+	if !ok {
+		return
+	}
+	if request.AuthorizationID == "" {
+		respond(w, "", models.ResponseNoOriginal)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reversed[request.AuthorizationID] = true
+	if auth, ok := a.authorizations[a.gatewayIDs[request.AuthorizationID]]; ok {
+		auth.Closed = true
+	}
+	respond(w, "acq_"+ksuid.New().String(), models.ResponseApproved)
+}
+
+func (a *Acquirer) capture(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(auth *authorization, request models.AcquirerRequest) string {
+		if auth.Closed || request.Amount <= 0 || auth.Captured+request.Amount > auth.Amount {
+			return models.ResponseInvalidAmount
+		}
+		auth.Captured += request.Amount
+		auth.Closed = request.Final || auth.Captured == auth.Amount
+		return models.ResponseApproved
+	})
+}
+
+func (a *Acquirer) void(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(auth *authorization, request models.AcquirerRequest) string {
+		auth.Closed = true
+		return models.ResponseApproved
+	})
+}
+
+func (a *Acquirer) refund(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(auth *authorization, request models.AcquirerRequest) string {
+		if request.Amount <= 0 || auth.Refunded+request.Amount > auth.Captured {
+			return models.ResponseInvalidAmount
+		}
+		auth.Refunded += request.Amount
+		auth.Closed = auth.Closed || request.Final
+		return models.ResponseApproved
+	})
+}
+
+//update applies an operation to the authorization in the path and answers with a new reference for the operation
+func (a *Acquirer) update(w http.ResponseWriter, r *http.Request, operation func(auth *authorization, request models.AcquirerRequest) string) {
+	request, ok := a.readRequest(w, r)
+	if !ok {
+		return
+	}
+
+	a.delay(request)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id, responseCode, ok := a.repeated(request); ok {
+		respond(w, id, responseCode)
+		return
+	}
+	auth, ok := a.authorizations[mux.Vars(r)["id"]]
+	if !ok {
+		respond(w, "", models.ResponseNoOriginal)
+		return
+	}
+	responseCode := operation(auth, request)
+	if responseCode != models.ResponseApproved {
+		respond(w, "", responseCode)
+		return
+	}
+	id := "acq_" + ksuid.New().String()
+	a.approved(request, id)
+	respond(w, id, responseCode)
+}
+
+//delay waits before an operation for an amount ending in 97 is applied, an operation that was already approved is answered right away
+func (a *Acquirer) delay(request models.AcquirerRequest) {
+	a.mu.Lock()
+	_, known := a.operations[request.OperationID]
+	a.mu.Unlock()
+	if request.Amount%100 == 97 && !known {
+		time.Sleep(a.Delay)
+	}
+}
+
+//repeated answers a request whose operation was already approved, with the same reference when it asks for the same
+//declined operations changed nothing and are tried again, the caller holds the lock
+func (a *Acquirer) repeated(request models.AcquirerRequest) (string, string, bool) {
+	if request.OperationID == "" {
+		return "", "", false
+	}
+	op, ok := a.operations[request.OperationID]
+	if !ok {
+		return "", "", false
+	}
+	if op.Amount != request.Amount || op.Final != request.Final {
+		return "", models.ResponseDuplicate, true
+	}
+	return op.ID, models.ResponseApproved, true
+}
+
+//approved remembers an approved operation so it is not applied twice, the caller holds the lock
+func (a *Acquirer) approved(request models.AcquirerRequest, id string) {
+	if request.OperationID != "" {
+		a.operations[request.OperationID] = operation{ID: id, Amount: request.Amount, Final: request.Final}
+	}
+}
+
+//readRequest checks the API key and decodes the body, answering the request itself when either is invalid
+func (a *Acquirer) readRequest(w http.ResponseWriter, r *http.Request) (models.AcquirerRequest, bool) {
+	if a.APIKey != "" && strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") != a.APIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.AcquirerRequest{}, false
+	}
+
+	request := models.AcquirerRequest{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &request)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return models.AcquirerRequest{}, false
+	}
+	return request, true
+}
+
+//respond writes the response code with the reference of the operation, declines have no reference
+func respond(w http.ResponseWriter, id, responseCode string) {
+	status := http.StatusOK
+	switch responseCode {
+	case models.ResponseApproved:
+	case models.ResponseNoOriginal:
+		status = http.StatusNotFound
+	case models.ResponseSystemError:
+		status = http.StatusInternalServerError
+	case models.ResponseDuplicate:
+		status = http.StatusConflict
+	default:
+		status = http.StatusPaymentRequired
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.AcquirerResponse{ID: id, ResponseCode: responseCode})
+}
@@ -1,23 +1,24 @@
 package models
 
 import (
-	"errors"
+	"strings"
 	"time"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
 
 	"github.com/jinzhu/gorm"
 	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
 	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
 )
 
 // generic information about the authorization request
+// the card is given either by its number, CVV and expiry or by a token from an earlier authorization
 type AuthorizationRequest struct {
 	CardNumber      string  `json:"cardNumber"`
+	CardToken       string  `json:"cardToken"`
 	Currency        string  `json:"currency"`
 	CVV             string  `json:"cvv"`
-	Amount          float64 `json:"amount"`
+	Amount          Money   `json:"amount"`
 	ExpirationMonth int     `json:"expirationMonth"`
 	ExpirationYear  int     `json:"expirationYear"`
 }
@@ -25,37 +26,65 @@ type AuthorizationRequest struct {
 // generic information about the authorization response
 type AuthorizationResponse struct {
 	ID              string  `json:"id"`
+	CardToken       string  `json:"cardToken"`
+	CardBrand       string  `json:"cardBrand"`
 	Currency        string  `json:"currency"`
-	AmountAvailable float64 `json:"amountAvailable"`
+	AmountAvailable Money   `json:"amountAvailable"`
 }
 
 // generic information about the action request
 type ActionRequest struct {
-	ID     string  `json:"id"`
-	Amount float64 `json:"amount"`
-	Final  bool    `json:"final"`
+	ID       string  `json:"id"`
+	Amount   Money   `json:"amount"`
+	Currency string  `json:"currency"`
+	Final    bool    `json:"final"`
 }
 
 // generic information about the authorization
 type Authorization struct {
-	ID                string    `gorm:"primary_key;unique" json:"id"`
-	CardNumber        string    `gorm:"size:16;not null;unique" json:"cardNumber"`
-	BalanceCaptured   float64   `gorm:"not null;" json:"balanceCaptured"`
-	BalanceAuthorised float64   `gorm:"not null;" json:"balanceAuthorised"`
-	BalanceRefunded   float64   `gorm:"not null;" json:"balanceRefunded"`
-	CurrencyRequested string    `gorm:"size:4;not null;" json:"currencyRequested"`
-	CurrencyCard      string    `gorm:"size:4;not null;" json:"currencyCard"`
-	Status            string    `gorm:"size:16;not null;unique" json:"status"`
-	CreatedAt         time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt         time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID                    string     `gorm:"primary_key;unique" json:"id"`
+	MerchantID            uint32     `gorm:"index" json:"merchantId"`
+	CardToken             string     `gorm:"size:32;index" json:"cardToken"`
+	CardBrand             string     `gorm:"size:20" json:"cardBrand"`
+	AmountRequested       Money      `gorm:"not null;default:0" json:"amountRequested"`
+	BalanceCaptured       Money      `gorm:"not null;" json:"balanceCaptured"`
+	BalanceAuthorised     Money      `gorm:"not null;" json:"balanceAuthorised"`
+	BalanceRefunded       Money      `gorm:"not null;" json:"balanceRefunded"`
+	CurrencyRequested     string     `gorm:"size:4;not null;" json:"currencyRequested"`
+	CurrencyCard          string     `gorm:"size:4;not null;" json:"currencyCard"`
+	FXRate                float64    `gorm:"not null;default:1" json:"fxRate"`
+	FXRateSource          string     `gorm:"size:16;not null;default:'none'" json:"fxRateSource"`
+	Status                string     `gorm:"size:20;not null;index" json:"status"`
+	Processor             string     `gorm:"size:20" json:"processor"`
+	ProcessorReference    string     `gorm:"size:64" json:"processorReference"`
+	ProcessorResponseCode string     `gorm:"size:4" json:"processorResponseCode"`
+	DeclineCode           string     `gorm:"size:40;index" json:"declineCode,omitempty"`
+	RiskDecision          string     `gorm:"size:10;index" json:"riskDecision,omitempty"`
+	RiskScore             int        `gorm:"not null;default:0" json:"riskScore"`
+	ExpiresAt             *time.Time `gorm:"index" json:"expiresAt"`
+	LeaseID               string     `gorm:"size:27;not null;default:''" json:"-"`
+	LeasedUntil           *time.Time `json:"-"`
+	CreatedAt             time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt             time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	//rules matched by fraud screening, stored with the authorization in risk_rule_matches
+	riskMatches []RiskRuleMatch
 }
 
+//Interface to call Authorization functions
+//every method is scoped to the merchant, another merchant's authorizations are reported as not found
 type AuthorizationI interface {
-	RequestAuthorization(authRequest AuthorizationRequest, db *gorm.DB) (*Authorization, error)
-	Capture(authId string, amount float64, finalCapture bool, db *gorm.DB) (*Authorization, error)
-	Void(authId string, db *gorm.DB) (*Authorization, error)
-	Refund(authId string, amount float64, finalRefund bool, db *gorm.DB) (*Authorization, error)
-	FindAuthorizationByID(authId string, db *gorm.DB) (*Authorization, error)
+	RequestAuthorization(merchantID uint32, authRequest AuthorizationRequest, db *gorm.DB) (*Authorization, error)
+	Capture(merchantID uint32, authId string, amount Money, currency string, finalCapture bool, db *gorm.DB) (*Authorization, error)
+	Void(merchantID uint32, authId string, db *gorm.DB) (*Authorization, error)
+	Refund(merchantID uint32, authId string, amount Money, currency string, finalRefund bool, db *gorm.DB) (*Authorization, error)
+	FindAuthorizationByID(merchantID uint32, authId string, db *gorm.DB) (*Authorization, error)
+	ListAuthorizations(merchantID uint32, filter AuthorizationFilter, db *gorm.DB) (*AuthorizationListResponse, error)
+	ExpireAuthorizations(now time.Time, db *gorm.DB) (int, error)
+	ListStatusHistory(merchantID uint32, authId string, db *gorm.DB) ([]AuthorizationStatusHistory, error)
+	ApprovalRates(filter ApprovalRateFilter, db *gorm.DB) (*ApprovalReport, error)
+	OpenAuthorizationStats(db *gorm.DB) (*OpenAuthorizationStats, error)
+	RiskAssessment(merchantID uint32, authId string, db *gorm.DB) (*RiskAssessment, error)
 }
 
 func NewAuthI() AuthorizationI {
@@ -63,240 +92,391 @@ func NewAuthI() AuthorizationI {
 }
 
 //RequestAuthorization performs necessary checks and authorizes the amount on the customers Bank based on the auth request data
-func (a *Authorization) RequestAuthorization(authRequest AuthorizationRequest, db *gorm.DB) (auth *Authorization, err error) {
+func (a *Authorization) RequestAuthorization(merchantID uint32, authRequest AuthorizationRequest, db *gorm.DB) (auth *Authorization, err error) {
 	cardI := NewCardI()
-	bankI := NewBankAccountI() //Assumption: normally this will be done by invoking an API in a real life scenario to update the BankAccount
 
 	if authRequest.Amount <= 0 {
-		return &Authorization{}, errors.New(constants.InvalidAmount)
+		return &Authorization{}, apierrors.New(constants.InvalidAmount)
 	}
 
-	if !cardI.ValidateLuhnNumber(authRequest.CardNumber) {
-		return &Authorization{}, errors.New(constants.InvalidCreditCardNumber)
+	card, err := a.findRequestedCard(merchantID, authRequest, db)
+	if err == nil {
+		err = merchantAcceptsCard(merchantID, card, db)
+	}
+	if err != nil {
+		return a.declineIfRefused(Authorization{MerchantID: merchantID}, authRequest, card, err, db)
 	}
 
-	card, err := cardI.FindCardByNumber(db, authRequest.CardNumber)
+	//fraud screening runs before the processor is asked for the hold
+	assessment, err := assessRisk(db, riskRequest{
+		MerchantID: merchantID,
+		Card:       card,
+		Amount:     authRequest.Amount,
+		Currency:   authRequest.Currency,
+		Now:        time.Now(),
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
+	if assessment.Decision == constants.RiskDecision(constants.RiskBlock).String() {
+		blocked := Authorization{MerchantID: merchantID, RiskDecision: assessment.Decision, RiskScore: assessment.Score, riskMatches: assessment.Rules}
+		return a.declineIfRefused(blocked, authRequest, card, apierrors.New(constants.RiskBlocked), db)
+	}
 
-	if err = cardI.Validate(card, authRequest.CVV, authRequest.ExpirationMonth, authRequest.ExpirationYear); err != nil {
+	//lock the exchange rate for the lifetime of the authorization
+	rate, err := lookupFXRate(authRequest.Currency, card.Currency)
+	if err != nil {
 		return &Authorization{}, err
 	}
+	requestedAmount := authRequest.Amount
+	authRequest.Amount = authRequest.Amount.Convert(rate.Rate, authRequest.Currency, card.Currency)
 
-	if card.Currency != authRequest.Currency {
-		authRequest.Amount,err = convertCurrency(authRequest.Amount, authRequest.Currency, card.Currency)
-		if err != nil {
-			return &Authorization{}, err
-		}
+	expiresAt, err := authorizationExpiry(merchantID, time.Now(), db)
+	if err != nil {
+		return &Authorization{}, err
 	}
 
-	if err = bankI.AuthorizeBalance(db, card.Number, authRequest.Amount); err != nil {
+	processor, err := getProcessorRouter().Route(merchantID, authRequest.Currency, card.Brand)
+	if err != nil {
 		return &Authorization{}, err
 	}
 
 	authorization := Authorization{
 		ID:                ksuid.New().String(),
-		CardNumber:        card.Number,
+		MerchantID:        merchantID,
+		CardToken:         authRequest.CardToken,
+		CardBrand:         card.Brand,
+		AmountRequested:   requestedAmount,
 		BalanceAuthorised: authRequest.Amount,
 		BalanceCaptured:   0,
 		CurrencyRequested: authRequest.Currency,
 		CurrencyCard:      card.Currency,
+		FXRate:            rate.Rate,
+		FXRateSource:      rate.Source,
 		Status:            constants.AuthStatus(constants.Authorized).String(),
+		Processor:         processor.Name(),
+		RiskDecision:      assessment.Decision,
+		RiskScore:         assessment.Score,
+		ExpiresAt:         &expiresAt,
+		riskMatches:       assessment.Rules,
 	}
 
-	if err = db.Debug().Create(&authorization).Error; err != nil {
+	if authorization.CardToken == "" {
+		cardToken, err := cardI.Tokenize(db, merchantID, card)
+		if err != nil {
+			return &Authorization{}, err
+		}
+		authorization.CardToken = cardToken.Token
+	}
+
+	//the processor is asked before anything is stored so no transaction is open while it answers
+	response, err := processor.Authorize(db, ProcessorRequest{
+		AuthorizationID: authorization.ID,
+		OperationID:     authorization.ID,
+		MerchantID:      merchantID,
+		Card:            card,
+		CVV:             authRequest.CVV,
+		CardToken:       authorization.CardToken,
+		Amount:          authorization.BalanceAuthorised,
+		Currency:        authorization.CurrencyCard,
+	})
+	authorization.ProcessorReference, authorization.ProcessorResponseCode = response.Reference, response.ResponseCode
+	if apierrors.Is(err, constants.ProcessorTimeout) {
+		//the processor may hold the funds although it did not answer, a retry is a new authorization so they are released
+		releaseUnstoredAuthorization(db, processor, &authorization)
 		return &Authorization{}, err
 	}
+	if err != nil {
+		//a CVV refused by the issuer counts towards locking the card
+		err = countVerificationFailure(db, merchantID, card, err, time.Now())
+		return a.declineIfRefused(authorization, authRequest, card, err, db)
+	}
+	//a partial approval only authorizes part of the amount, the merchant sees it as the available amount
+	if response.ApprovedAmount > 0 && response.ApprovedAmount < authorization.BalanceAuthorised {
+		authorization.BalanceAuthorised = response.ApprovedAmount
+	}
 
-	return &authorization, nil
-}
-
-//Capture performs necessary checks and captures the amount on the customers Bank based on the request amount
-func (a *Authorization) Capture(authId string, amount float64, finalCapture bool, db *gorm.DB) (auth *Authorization, err error) {
-	auth, err = a.FindAuthorizationByID(authId, db)
+	//the authorization, its ledger entry, its first status and its event are stored together or not at all
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&authorization).Error; err != nil {
+			return err
+		}
+		if err := recordRiskMatches(tx, authorization.ID, authorization.riskMatches); err != nil {
+			return err
+		}
+		if _, err := NewTransactionI().RecordTransaction(tx, &authorization, constants.AuthorizeTransaction, authorization.BalanceAuthorised); err != nil {
+			return err
+		}
+		if err := recordStatusChange(tx, &authorization, "", authorization.Status, ReasonApproved); err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, authorization.ID, constants.AuthorizationCreatedEvent)
+	})
 	if err != nil {
+		//the processor holds the funds of an authorization that was not stored, they are released again
+		releaseUnstoredAuthorization(db, processor, &authorization)
 		return &Authorization{}, err
 	}
 
-	if auth.Status != constants.AuthStatus(constants.Authorized).String() {
-		return &Authorization{}, errors.New(constants.InvalidStatus + auth.Status)
-	}
+	return &authorization, nil
+}
 
-	//verify if amount is valid
-	if amount <= 0 || (amount > auth.BalanceAuthorised || (amount+auth.BalanceCaptured) > auth.BalanceAuthorised) {
-		return &Authorization{}, errors.New(constants.InvalidAmount)
+//releaseUnstoredAuthorization voids an authorization the processor may hold but the gateway did not store
+//without a reference of the processor the void refers to the authorization by its ID
+func releaseUnstoredAuthorization(db *gorm.DB, processor Processor, authorization *Authorization) {
+	request := authorization.processorRequest(authorization.BalanceAuthorised, true)
+	request.OperationID = authorization.ID + "-void"
+	if _, err := processor.Void(db, request); err != nil {
+		logger.FromDB(db).Error("cannot void an authorization that was not stored", logger.Fields{"authorization_id": authorization.ID, "processor": processor.Name(), "error": err})
 	}
+}
 
-	//capture the amount on the customers bank
-	bankI := NewBankAccountI()
-	if err = bankI.CaptureBalance(db, auth.CardNumber, amount); err != nil {
-		return &Authorization{}, err
+//Capture performs necessary checks and captures the amount on the customers Bank based on the request amount
+//the processor is asked outside of any transaction while the authorization is leased to the capture
+func (a *Authorization) Capture(merchantID uint32, authId string, amount Money, currency string, finalCapture bool, db *gorm.DB) (auth *Authorization, err error) {
+	op, err := a.waitForOperation(merchantID, authId, db, func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error) {
+		amount, err := locked.toCardCurrency(amount, currency)
+		if err != nil {
+			return nil, err
+		}
 
-	}
+		//the sweeper may not have picked it up yet so the expiry is checked here as well
+		if locked.isExpired(time.Now()) {
+			return nil, apierrors.New(constants.AuthorizationExpired)
+		}
 
-	status := auth.Status
-	if finalCapture || amount == auth.BalanceAuthorised {
-		status = constants.AuthStatus(constants.Captured).String()
-	}
+		status, reason := constants.PartiallyCaptured, ReasonPartialCapture
+		if finalCapture {
+			status, reason = constants.Captured, ReasonFinalCapture
+		} else if amount+locked.BalanceCaptured == locked.BalanceAuthorised {
+			status, reason = constants.Captured, ReasonFullCapture
+		}
+		if err = ValidateTransition(locked.Status, authStatus(status)); err != nil {
+			return nil, err
+		}
 
-	//update the auth object in db
-	db = db.Debug().Model(&BankAccount{}).Where("id = ?", authId).Take(&Authorization{}).UpdateColumns(
-		map[string]interface{}{
-			"balanceCaptured": auth.BalanceCaptured + amount,
-			"status":          status,
-			"updated_at":       time.Now(),
-		},
-	)
+		//verify if amount is valid
+		if amount <= 0 || (amount > locked.BalanceAuthorised || (amount+locked.BalanceCaptured) > locked.BalanceAuthorised) {
+			return nil, apierrors.New(constants.InvalidAmount)
+		}
 
-	//refresh auth object
-	auth, err = a.FindAuthorizationByID(authId, db)
+		if err = NewTransactionI().VerifyBalances(locked, tx); err != nil {
+			return nil, err
+		}
+		//when nothing more can be captured the rest of the hold goes back to the customer
+		return &authorizationOperation{Amount: amount, Status: status, Reason: reason, Final: status == constants.Captured}, nil
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
 
-	return auth, nil
-}
-
-//Void voids the authorization by chaning the status to void
-func (a *Authorization) Void(authId string, db *gorm.DB) (auth *Authorization, err error) {
-	auth, err = a.FindAuthorizationByID(authId, db)
+	_, err = op.Processor.Capture(db, op.processorRequest())
+	err = a.completeOperation(db, op, err, func(tx *gorm.DB, locked *Authorization) error {
+		if _, err := NewTransactionI().RecordTransaction(tx, locked, constants.CaptureTransaction, op.Amount); err != nil {
+			return err
+		}
+		err := a.transitionStatus(tx, locked, op.Status, op.Reason, map[string]interface{}{
+			"balance_captured": locked.BalanceCaptured + op.Amount,
+		})
+		if err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, authId, constants.AuthorizationCapturedEvent)
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
 
-	if auth.Status != constants.AuthStatus(constants.Authorized).String() {
-		return &Authorization{}, errors.New(constants.InvalidStatus + auth.Status)
-	}
+	//refresh auth object
+	return a.FindAuthorizationByID(merchantID, authId, db)
+}
 
-	bankI := NewBankAccountI()
-	if err = bankI.VoidAuthorization(db, auth.CardNumber); err != nil {
+//Void voids the authorization by chaning the status to void
+//the processor is asked outside of any transaction while the authorization is leased to the void
+func (a *Authorization) Void(merchantID uint32, authId string, db *gorm.DB) (auth *Authorization, err error) {
+	op, err := a.waitForOperation(merchantID, authId, db, func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error) {
+		if err := ValidateTransition(locked.Status, authStatus(constants.Voided)); err != nil {
+			return nil, err
+		}
+		if err := NewTransactionI().VerifyBalances(locked, tx); err != nil {
+			return nil, err
+		}
+		//the void releases whatever was still held
+		return &authorizationOperation{Amount: locked.BalanceAuthorised - locked.BalanceCaptured, Status: constants.Voided, Reason: ReasonVoided, Final: true}, nil
+	})
+	if err != nil {
 		return &Authorization{}, err
 	}
 
-	status := constants.AuthStatus(constants.Voided).String()
-	//update the auth object in db
-	db = db.Debug().Model(&BankAccount{}).Where("id = ?", authId).Take(&Authorization{}).UpdateColumns(
-		map[string]interface{}{
-			"status":    status,
-			"updated_at": time.Now(),
-		},
-	)
-
-	//refresh auth object
-	auth, err = a.FindAuthorizationByID(authId, db)
+	_, err = op.Processor.Void(db, op.processorRequest())
+	err = a.completeOperation(db, op, err, func(tx *gorm.DB, locked *Authorization) error {
+		if _, err := NewTransactionI().RecordTransaction(tx, locked, constants.VoidTransaction, op.Amount); err != nil {
+			return err
+		}
+		if err := a.transitionStatus(tx, locked, op.Status, op.Reason, map[string]interface{}{}); err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, authId, constants.AuthorizationVoidedEvent)
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
 
-	return auth, nil
+	//refresh auth object
+	return a.FindAuthorizationByID(merchantID, authId, db)
 }
 
 //Refund refunds the specified amount to the customer if it does not exceed the captured balance
-func (a *Authorization) Refund(authId string, amount float64, finalRefund bool, db *gorm.DB) (auth *Authorization, err error) {
-	auth, err = a.FindAuthorizationByID(authId, db)
-	if err != nil {
-		return &Authorization{}, err
-	}
-
-	if auth.Status != constants.AuthStatus(constants.Authorized).String() {
-		return &Authorization{}, errors.New(constants.InvalidStatus + auth.Status)
-	}
-
-	//verify if amount is valid, checking againts authrozed balance to cpature edge case
-	if amount <= 0 ||
-		(amount > auth.BalanceAuthorised ||
-			(amount+auth.BalanceRefunded) > auth.BalanceAuthorised ||
-			(amount+auth.BalanceRefunded) > auth.BalanceCaptured) {
-		return &Authorization{}, errors.New(constants.InvalidAmount)
-	}
-
-	//capture the amount on the customers bank
-	bankI := NewBankAccountI()
-	if err = bankI.RefundBalance(db, auth.CardNumber, amount); err != nil {
-		return &Authorization{}, err
-	}
+//refunding a partially captured authorization closes it for further captures and releases the rest of the hold
+//the processor is asked outside of any transaction while the authorization is leased to the refund
+func (a *Authorization) Refund(merchantID uint32, authId string, amount Money, currency string, finalRefund bool, db *gorm.DB) (auth *Authorization, err error) {
+	op, err := a.waitForOperation(merchantID, authId, db, func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error) {
+		amount, err := locked.toCardCurrency(amount, currency)
+		if err != nil {
+			return nil, err
+		}
 
-	status := auth.Status
-	if finalRefund || amount == auth.BalanceAuthorised {
-		status = constants.AuthStatus(constants.Refunded).String()
-	}
+		status, reason := constants.PartiallyRefunded, ReasonPartialRefund
+		if finalRefund {
+			status, reason = constants.Refunded, ReasonFinalRefund
+		} else if amount == locked.BalanceCaptured {
+			status, reason = constants.Refunded, ReasonFullRefund
+		}
+		if err = ValidateTransition(locked.Status, authStatus(status)); err != nil {
+			return nil, err
+		}
 
-	//update the auth object in db
-	db = db.Debug().Model(&BankAccount{}).Where("id = ?", authId).Take(&Authorization{}).UpdateColumns(
-		map[string]interface{}{
-			"balanceCaptured": auth.BalanceCaptured - amount,
-			"balanceRefunded": auth.BalanceRefunded + amount,
-			"status":          status,
-			"updated_at":      time.Now(),
-		},
-	)
+		//only what is still captured can be refunded
+		if amount <= 0 || amount > locked.BalanceCaptured {
+			return nil, apierrors.New(constants.InvalidAmount)
+		}
 
-	//refresh auth object
-	auth, err = a.FindAuthorizationByID(authId, db)
+		if err = NewTransactionI().VerifyBalances(locked, tx); err != nil {
+			return nil, err
+		}
+		//refunding an authorization that could still be captured releases the rest of its hold
+		return &authorizationOperation{Amount: amount, Status: status, Reason: reason, Final: locked.isCapturable()}, nil
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
 
-	return auth, nil
-}
-
-//FindAuthorizationByID retrieves an Authorization by ID from the DB
-func (a *Authorization) FindAuthorizationByID(authId string, db *gorm.DB) (auth *Authorization, err error) {
-	var authorization Authorization
-	err = db.Debug().Model(Authorization{}).Where("id = ?", authId).Take(&authorization).Error
+	_, err = op.Processor.Refund(db, op.processorRequest())
+	err = a.completeOperation(db, op, err, func(tx *gorm.DB, locked *Authorization) error {
+		if _, err := NewTransactionI().RecordTransaction(tx, locked, constants.RefundTransaction, op.Amount); err != nil {
+			return err
+		}
+		err := a.transitionStatus(tx, locked, op.Status, op.Reason, map[string]interface{}{
+			"balance_captured": locked.BalanceCaptured - op.Amount,
+			"balance_refunded": locked.BalanceRefunded + op.Amount,
+		})
+		if err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, authId, constants.AuthorizationRefundedEvent)
+	})
 	if err != nil {
 		return &Authorization{}, err
 	}
-	if gorm.IsRecordNotFoundError(err) {
-		return &Authorization{}, errors.New(constants.AuthorizationNotFound)
-	}
-	return &authorization, err
-}
 
-//convertCurrency converts between the authorization request currency and the card currency
-//returns the converted amount (could round it)
-func convertCurrency(amount float64, baseCur, targetCur string) (amt float64, err error) {
-
-	curConvert := baseCur + "_" + targetCur
+	//refresh auth object
+	return a.FindAuthorizationByID(merchantID, authId, db)
+}
 
-	url := "https://free.currconv.com/api/v7/convert?q=" + curConvert + "&compact=ultra&apiKey=424d5c06590ef708b468"
+//findRequestedCard returns the card of the authorization request after checking the details the merchant sent
+//a token can be used without the CVV and expiry, which are only checked when they are sent
+//the CVV's value is verified by the issuer when the processor is asked for the hold
+//a card that was found is returned with the error when its details do not match so the decline can be recorded on it
+func (a *Authorization) findRequestedCard(merchantID uint32, authRequest AuthorizationRequest, db *gorm.DB) (*Card, error) {
+	cardI := NewCardI()
 
-	spaceClient := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
+	if authRequest.CardToken != "" {
+		card, err := cardI.FindCardByToken(db, merchantID, authRequest.CardToken)
+		if err != nil {
+			return &Card{}, err
+		}
+		if card.isWiped() {
+			return card, apierrors.New(constants.CardWiped)
+		}
+		return card, verifyCardAttempt(db, merchantID, card, func() error {
+			//the card must not have expired even when no expiry was sent
+			expirationMonth, expirationYear := card.ExpirationMonth, card.ExpirationYear
+			if authRequest.ExpirationMonth != 0 || authRequest.ExpirationYear != 0 {
+				expirationMonth, expirationYear = authRequest.ExpirationMonth, authRequest.ExpirationYear
+			}
+			if err := card.verifyExpiry(expirationMonth, expirationYear, time.Now()); err != nil {
+				return err
+			}
+			if authRequest.CVV != "" {
+				return card.validateCVV(authRequest.CVV)
+			}
+			return nil
+		})
+	}
+
+	if authRequest.CardNumber == "" {
+		return &Card{}, apierrors.New(constants.CardNumberOrTokenRequired)
+	}
+	if !cardI.ValidateLuhnNumber(authRequest.CardNumber) {
+		return &Card{}, apierrors.New(constants.InvalidCreditCardNumber)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	card, err := cardI.FindCardByNumber(db, authRequest.CardNumber)
 	if err != nil {
-		return amount, err
+		return &Card{}, err
 	}
-
-	req.Header.Set("User-Agent", "spacecount-tutorial")
-
-	res, err := spaceClient.Do(req)
+	hasToken, err := card.withMerchantExpiry(db, merchantID)
 	if err != nil {
-		return amount, err
+		return &Card{}, err
+	}
+	if card.isWiped() {
+		return card, apierrors.New(constants.CardWiped)
 	}
+	return card, verifyCardAttempt(db, merchantID, card, func() error {
+		//a merchant without a token for the card has no expiry of its own to compare with
+		if !hasToken {
+			if err := card.adoptExpiry(authRequest.ExpirationMonth, authRequest.ExpirationYear); err != nil {
+				return err
+			}
+		}
+		return cardI.Validate(card, authRequest.CVV, authRequest.ExpirationMonth, authRequest.ExpirationYear)
+	})
+}
 
-	if res.Body != nil {
-		defer res.Body.Close()
+//toCardCurrency converts an amount given in the requested currency with the rate locked on the authorization
+//amounts without a currency or already in the card currency are returned as they are
+func (a *Authorization) toCardCurrency(amount Money, currency string) (Money, error) {
+	if currency == "" || strings.EqualFold(currency, a.CurrencyCard) {
+		return amount, nil
 	}
+	if !strings.EqualFold(currency, a.CurrencyRequested) {
+		return 0, apierrors.New(constants.InvalidCurrency)
+	}
+	return amount.Convert(a.FXRate, a.CurrencyRequested, a.CurrencyCard), nil
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+//findAuthorizationForUpdate retrieves the merchant's Authorization by ID and locks the row for the rest of the transaction
+func (a *Authorization) findAuthorizationForUpdate(merchantID uint32, authId string, tx *gorm.DB) (auth *Authorization, err error) {
+	var authorization Authorization
+	err = forUpdate(tx).Model(Authorization{}).Where("id = ? AND merchant_id = ?", authId, merchantID).Take(&authorization).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Authorization{}, apierrors.New(constants.AuthorizationNotFound)
+	}
 	if err != nil {
-		return amount, err
+		return &Authorization{}, err
 	}
+	return &authorization, nil
+}
 
-	convert := make(map[string]float32)
-
-	err = json.Unmarshal(body, &convert)
+//FindAuthorizationByID retrieves the merchant's Authorization by ID from the DB
+func (a *Authorization) FindAuthorizationByID(merchantID uint32, authId string, db *gorm.DB) (auth *Authorization, err error) {
+	var authorization Authorization
+	err = db.Model(Authorization{}).Where("id = ? AND merchant_id = ?", authId, merchantID).Take(&authorization).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Authorization{}, apierrors.New(constants.AuthorizationNotFound)
+	}
 	if err != nil {
-		return amount, err
+		return &Authorization{}, err
 	}
-
-	value := convert[curConvert]
-
-	amount = amount * float64(value)
-
-	return amount, nil
+	return &authorization, nil
 }
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+//declineErrors lists the errors that refuse the card or the payment, they are recorded as declined authorizations
+//errors in the request itself, e.g. a missing card or an unknown currency, are returned without a record
+var declineErrors = map[string]bool{
+	constants.InvalidCreditCardNumber:   true,
+	constants.CardNotFound:              true,
+	constants.CardWiped:                 true,
+	constants.InvalidMonth:              true,
+	constants.CreditCardExpired:         true,
+	constants.NoMatchCardExpirationDate: true,
+	constants.InvalidCVV:                true,
+	constants.NoMatchCVV:                true,
+	constants.CardBrandNotAccepted:      true,
+	constants.BankAccountNotFound:       true,
+	constants.AmountExeedsBalance:       true,
+	constants.ProcessorDeclined:         true,
+	constants.SuspectedFraud:            true,
+	constants.RiskBlocked:               true,
+	constants.CardLocked:                true,
+}
+
+//declineCode returns the code a declined authorization is recorded with, the code of the error returned to the merchant
+func declineCode(err error) (string, bool) {
+	if !declineErrors[err.Error()] {
+		return "", false
+	}
+	return apierrors.From(err, http.StatusPaymentRequired).Code, true
+}
+
+//DeclineCode returns the code of an error refusing the card or the payment, false for any other error
+func DeclineCode(err error) (string, bool) {
+	return declineCode(err)
+}
+
+//IsDeclined tells whether the authorization was recorded as declined
+func (a *Authorization) IsDeclined() bool {
+	return a.Status == authStatus(constants.Declined)
+}
+
+//declineIfRefused records a refused authorization request as a declined authorization and returns it with the error
+//attempt holds what was known when the request was refused, the ID and answer of the processor once it was asked
+//and the requested amount and locked rate once the amount was converted to the card currency
+//the error then refers to the declined authorization, other errors are returned as they are
+func (a *Authorization) declineIfRefused(attempt Authorization, authRequest AuthorizationRequest, card *Card, err error, db *gorm.DB) (*Authorization, error) {
+	code, ok := declineCode(err)
+	if !ok {
+		return &Authorization{}, err
+	}
+	merchantID := attempt.MerchantID
+
+	declined := Authorization{
+		ID:                    attempt.ID,
+		MerchantID:            merchantID,
+		CardToken:             authRequest.CardToken,
+		CardBrand:             card.Brand,
+		AmountRequested:       authRequest.Amount,
+		CurrencyRequested:     declinedCurrency(authRequest.Currency),
+		CurrencyCard:          card.Currency,
+		FXRate:                1,
+		FXRateSource:          FXSourceNone,
+		Status:                authStatus(constants.Declined),
+		DeclineCode:           code,
+		Processor:             attempt.Processor,
+		ProcessorReference:    attempt.ProcessorReference,
+		ProcessorResponseCode: attempt.ProcessorResponseCode,
+		RiskDecision:          attempt.RiskDecision,
+		RiskScore:             attempt.RiskScore,
+	}
+	if declined.ID == "" {
+		declined.ID = ksuid.New().String()
+	}
+	//authRequest then holds the converted amount, the merchant asked for the one on the attempt
+	if attempt.AmountRequested != 0 {
+		declined.AmountRequested = attempt.AmountRequested
+		declined.FXRate, declined.FXRateSource = attempt.FXRate, attempt.FXRateSource
+	}
+	//cards that were not found still show their brand
+	if declined.CardBrand == "" && authRequest.CardNumber != "" {
+		if brand, err := DetectCardBrand(authRequest.CardNumber); err == nil {
+			declined.CardBrand = brand.String()
+		}
+	}
+
+	recordErr := db.Transaction(func(tx *gorm.DB) error {
+		//cards that were found are referred to by token like approved authorizations
+		//a wiped card must not get a live token again, it keeps the merchant's last token if it had one
+		if declined.CardToken == "" && card.ID != 0 {
+			if card.isWiped() || apierrors.Is(err, constants.CardWiped) {
+				lastToken, err := lastCardToken(tx, merchantID, card.ID)
+				if err != nil {
+					return err
+				}
+				declined.CardToken = lastToken
+			} else {
+				cardToken, err := NewCardI().Tokenize(tx, merchantID, card)
+				if err != nil {
+					return err
+				}
+				declined.CardToken = cardToken.Token
+			}
+		}
+		if err := tx.Create(&declined).Error; err != nil {
+			return err
+		}
+		if err := recordRiskMatches(tx, declined.ID, attempt.riskMatches); err != nil {
+			return err
+		}
+		if err := recordStatusChange(tx, &declined, "", declined.Status, ReasonDeclined+": "+code); err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, declined.ID, constants.AuthorizationDeclinedEvent)
+	})
+	if recordErr != nil {
+		return &Authorization{}, recordErr
+	}
+
+	logger.FromDB(db).Info("authorization declined", logger.Fields{"authorization_id": declined.ID, "merchant_id": merchantID, "decline_code": code})
+	return &declined, apierrors.ForAuthorization(err, declined.ID)
+}
+
+//declinedCurrency returns the requested currency if it fits the column, declines are recorded before the currency is checked
+func declinedCurrency(currency string) string {
+	currency = strings.ToUpper(currency)
+	if len(currency) > 4 {
+		return ""
+	}
+	return currency
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+const (
+	defaultAuthorizationTTL           = 7 * 24 * time.Hour
+	defaultAuthorizationSweepInterval = time.Minute
+	authorizationSweepBatchSize       = 100
+)
+
+//DefaultAuthorizationTTL returns how long authorizations stay open for merchants without their own setting
+//it is read from AUTHORIZATION_TTL and defaults to 7 days
+func DefaultAuthorizationTTL() time.Duration {
+	return envDuration("AUTHORIZATION_TTL", defaultAuthorizationTTL)
+}
+
+//authorizationExpiry returns when an authorization of the merchant created now stops being capturable
+func authorizationExpiry(merchantID uint32, now time.Time, db *gorm.DB) (time.Time, error) {
+	merchant, err := NewMerchantI().FindMerchantByID(db, merchantID)
+	if err != nil {
+		if err.Error() != constants.MerchantNotFound {
+			return time.Time{}, err
+		}
+		return now.Add(DefaultAuthorizationTTL()), nil
+	}
+	return now.Add(merchant.AuthorizationValidity()), nil
+}
+
+//isExpired tells whether the authorization can no longer be captured because its validity window has passed
+func (a *Authorization) isExpired(now time.Time) bool {
+	if a.Status == authStatus(constants.Expired) {
+		return true
+	}
+	return a.isCapturable() && a.ExpiresAt != nil && !now.Before(*a.ExpiresAt)
+}
+
+//ExpireAuthorizations closes open authorizations whose validity window has passed and releases their holds
+//authorizations without captures move to Expired, partially captured ones keep what was captured and move to Captured
+//each authorization is expired on its own, one that fails is logged and retried on the next run
+func (a *Authorization) ExpireAuthorizations(now time.Time, db *gorm.DB) (expired int, err error) {
+	lastID := ""
+	for {
+		stale := []Authorization{}
+		err = db.Model(Authorization{}).Select("id, merchant_id").
+			Where("status IN (?) AND expires_at <= ? AND id > ?", authStatuses(constants.Authorized, constants.PartiallyCaptured), now, lastID).
+			Order("id asc").Limit(authorizationSweepBatchSize).Find(&stale).Error
+		if err != nil {
+			return expired, err
+		}
+
+		for i := range stale {
+			lastID = stale[i].ID
+			err := a.expireAuthorization(stale[i].MerchantID, stale[i].ID, now, db)
+			//an authorization that is being captured or voided right now is looked at again on the next run
+			if apierrors.Is(err, constants.AuthorizationBusy) {
+				continue
+			}
+			if err != nil {
+				logger.FromDB(db).Error("cannot expire authorization", logger.Fields{"authorization_id": stale[i].ID, "error": err})
+				continue
+			}
+			expired++
+		}
+
+		if len(stale) < authorizationSweepBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+//expireAuthorization expires a single authorization, authorizations captured or voided in the meantime are left alone
+//the processor is asked outside of any transaction while the authorization is leased to the expiry
+func (a *Authorization) expireAuthorization(merchantID uint32, authId string, now time.Time, db *gorm.DB) error {
+	op, err := a.claimOperation(merchantID, authId, db, func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error) {
+		if !locked.isCapturable() || !locked.isExpired(now) {
+			return nil, nil
+		}
+
+		status := constants.Expired
+		if locked.BalanceCaptured > 0 {
+			status = constants.Captured
+		}
+
+		if err := NewTransactionI().VerifyBalances(locked, tx); err != nil {
+			return nil, err
+		}
+		//the expiry releases whatever was still held
+		return &authorizationOperation{Amount: locked.BalanceAuthorised - locked.BalanceCaptured, Status: status, Reason: ReasonExpired, Final: true}, nil
+	})
+	if err != nil || op == nil {
+		return err
+	}
+
+	//the processor releases what is left of the authorization
+	_, err = op.Processor.Void(db, op.processorRequest())
+	return a.completeOperation(db, op, err, func(tx *gorm.DB, locked *Authorization) error {
+		if _, err := NewTransactionI().RecordTransaction(tx, locked, constants.ExpireTransaction, op.Amount); err != nil {
+			return err
+		}
+		if err := a.transitionStatus(tx, locked, op.Status, op.Reason, map[string]interface{}{}); err != nil {
+			return err
+		}
+		return enqueueWebhookEvent(tx, merchantID, authId, constants.AuthorizationExpiredEvent)
+	})
+}
+
+// generic information about the background job expiring stale authorizations
+type AuthorizationSweeper struct {
+	DB       *gorm.DB
+	Interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+//NewAuthorizationSweeper creates a sweeper running every interval
+func NewAuthorizationSweeper(db *gorm.DB, interval time.Duration) *AuthorizationSweeper {
+	return &AuthorizationSweeper{
+		DB:       db,
+		Interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//NewAuthorizationSweeperFromEnv creates a sweeper running every AUTHORIZATION_SWEEP_INTERVAL, 1 minute by default
+func NewAuthorizationSweeperFromEnv(db *gorm.DB) *AuthorizationSweeper {
+	return NewAuthorizationSweeper(db, envDuration("AUTHORIZATION_SWEEP_INTERVAL", defaultAuthorizationSweepInterval))
+}
+
+//Start runs the sweeper in the background until Stop is called
+func (s *AuthorizationSweeper) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.Sweep()
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop stops a started sweeper and waits for the current sweep to finish
+func (s *AuthorizationSweeper) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+//Sweep expires the stale authorizations and purges the expired idempotency keys once, errors are logged and retried on the next run
+func (s *AuthorizationSweeper) Sweep() int {
+	purged, err := NewIdempotencyKeyI().PurgeExpiredIdempotencyKeys(s.DB, time.Now())
+	if err != nil {
+		logger.FromDB(s.DB).Error("cannot purge expired idempotency keys", logger.Fields{"error": err})
+	}
+	if purged > 0 {
+		logger.FromDB(s.DB).Info("purged expired idempotency keys", logger.Fields{"count": purged})
+	}
+
+
+	expired, err := NewAuthI().ExpireAuthorizations(time.Now(), s.DB)
+	if err != nil {
+		logger.FromDB(s.DB).Error("cannot expire authorizations", logger.Fields{"error": err})
+	}
+	if expired > 0 {
+		logger.FromDB(s.DB).Info("expired authorizations", logger.Fields{"count": expired})
+	}
+	return expired
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DefaultAuthorizationListLimit = 20
+	MaxAuthorizationListLimit     = 100
+)
+
+// generic information about the filters of the authorization list
+// nil pointers and empty strings are not filtered on, the amount range applies to the authorized balance
+type AuthorizationFilter struct {
+	Status        string
+	DeclineCode   string
+	RiskDecision  string
+	Currency      string
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	MinAmount     *Money
+	MaxAmount     *Money
+	StartingAfter string
+	Limit         int
+}
+
+// generic information about an authorization with its full balance breakdown
+type AuthorizationDetail struct {
+	ID                    string      `json:"id"`
+	MerchantID            uint32      `json:"merchantId"`
+	Card                  CardSummary `json:"card"`
+	Status                string      `json:"status"`
+	DeclineCode           string      `json:"declineCode,omitempty"`
+	RiskDecision          string      `json:"riskDecision,omitempty"`
+	RiskScore             int         `json:"riskScore"`
+	CurrencyRequested     string      `json:"currencyRequested"`
+	CurrencyCard          string      `json:"currencyCard"`
+	FXRate                float64     `json:"fxRate"`
+	FXRateSource          string      `json:"fxRateSource"`
+	Processor             string      `json:"processor"`
+	ProcessorReference    string      `json:"processorReference"`
+	ProcessorResponseCode string      `json:"processorResponseCode"`
+	AmountRequested       Money       `json:"amountRequested"`
+	BalanceAuthorised     Money       `json:"balanceAuthorised"`
+	BalanceCaptured       Money       `json:"balanceCaptured"`
+	BalanceRefunded       Money       `json:"balanceRefunded"`
+	AmountAvailable       Money       `json:"amountAvailable"`
+	ExpiresAt             *time.Time  `json:"expiresAt"`
+	CreatedAt             time.Time   `json:"created_at"`
+	UpdatedAt             time.Time   `json:"updated_at"`
+}
+
+// generic information about a page of authorizations
+// NextCursor is passed as starting_after to get the next page
+type AuthorizationListResponse struct {
+	Data       []AuthorizationDetail `json:"data"`
+	HasMore    bool                  `json:"hasMore"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+}
+
+//ListAuthorizations returns the merchant's authorizations newest first
+//ksuid IDs are time sortable so the last ID of a page is used as the cursor for the next one
+func (a *Authorization) ListAuthorizations(merchantID uint32, filter AuthorizationFilter, db *gorm.DB) (page *AuthorizationListResponse, err error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuthorizationListLimit
+	}
+	if limit > MaxAuthorizationListLimit {
+		limit = MaxAuthorizationListLimit
+	}
+
+	query := db.Model(Authorization{}).Where("merchant_id = ?", merchantID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.DeclineCode != "" {
+		query = query.Where("decline_code = ?", filter.DeclineCode)
+	}
+	if filter.RiskDecision != "" {
+		query = query.Where("risk_decision = ?", filter.RiskDecision)
+	}
+	if filter.Currency != "" {
+		query = query.Where("currency_requested = ? OR currency_card = ?", filter.Currency, filter.Currency)
+	}
+	if filter.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		query = query.Where("created_at < ?", *filter.CreatedTo)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where("balance_authorised >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where("balance_authorised <= ?", *filter.MaxAmount)
+	}
+	if filter.StartingAfter != "" {
+		query = query.Where("id < ?", filter.StartingAfter)
+	}
+
+	//one extra row tells whether there is another page
+	authorizations := []Authorization{}
+	err = query.Order("id desc").Limit(limit + 1).Find(&authorizations).Error
+	if err != nil {
+		return &AuthorizationListResponse{}, err
+	}
+
+	page = &AuthorizationListResponse{Data: []AuthorizationDetail{}}
+	if len(authorizations) > limit {
+		authorizations = authorizations[:limit]
+		page.HasMore = true
+	}
+	for i := range authorizations {
+		page.Data = append(page.Data, authorizations[i].Detail())
+	}
+	if err = attachCardSummaries(db, page.Data); err != nil {
+		return &AuthorizationListResponse{}, err
+	}
+	if page.HasMore {
+		page.NextCursor = authorizations[len(authorizations)-1].ID
+	}
+	return page, nil
+}
+
+//Detail returns the authorization with its balance breakdown, only the token and brand of the card are filled in
+func (a *Authorization) Detail() AuthorizationDetail {
+	//only open authorizations have anything left to capture
+	available := Money(0)
+	if a.isCapturable() {
+		available = a.BalanceAuthorised - a.BalanceCaptured
+	}
+
+	return AuthorizationDetail{
+		ID:                    a.ID,
+		MerchantID:            a.MerchantID,
+		Card:                  CardSummary{Token: a.CardToken, Brand: a.CardBrand},
+		Status:                a.Status,
+		DeclineCode:           a.DeclineCode,
+		RiskDecision:          a.RiskDecision,
+		RiskScore:             a.RiskScore,
+		CurrencyRequested:     a.CurrencyRequested,
+		CurrencyCard:          a.CurrencyCard,
+		FXRate:                a.FXRate,
+		FXRateSource:          a.FXRateSource,
+		Processor:             a.Processor,
+		ProcessorReference:    a.ProcessorReference,
+		ProcessorResponseCode: a.ProcessorResponseCode,
+		AmountRequested:       a.AmountRequested,
+		BalanceAuthorised:     a.BalanceAuthorised,
+		BalanceCaptured:       a.BalanceCaptured,
+		BalanceRefunded:       a.BalanceRefunded,
+		AmountAvailable:       available,
+		ExpiresAt:             a.ExpiresAt,
+		CreatedAt:             a.CreatedAt,
+		UpdatedAt:             a.UpdatedAt,
+	}
+}
+
+//DetailWithCard returns the authorization with its balance breakdown and what may be shown of its card
+func (a *Authorization) DetailWithCard(db *gorm.DB) (AuthorizationDetail, error) {
+	details := []AuthorizationDetail{a.Detail()}
+	if err := attachCardSummaries(db, details); err != nil {
+		return AuthorizationDetail{}, err
+	}
+	return details[0], nil
+}
+
+//attachCardSummaries fills in the cards of the authorizations with one query
+func attachCardSummaries(db *gorm.DB, details []AuthorizationDetail) error {
+	tokens := make([]string, 0, len(details))
+	for i := range details {
+		tokens = append(tokens, details[i].Card.Token)
+	}
+
+	summaries, err := cardSummaries(db, tokens)
+	if err != nil {
+		return err
+	}
+	for i := range details {
+		if summary, ok := summaries[details[i].Card.Token]; ok {
+			details[i].Card = summary
+		}
+	}
+	return nil
+}
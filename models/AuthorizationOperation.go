@@ -0,0 +1,149 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+const (
+	defaultOperationLease = time.Minute
+	operationPollInterval = 20 * time.Millisecond
+)
+
+// generic information about an operation on an authorization that waits for the processor
+// the authorization is leased to the operation while the processor is asked, so no row lock is held during the call
+// Status and Reason are what the authorization moves to once the processor agreed, Final asks it to release the rest of the hold
+// OperationID is sent to the processor, an operation that was not recorded gets the same one when it is tried again
+type authorizationOperation struct {
+	Authorization *Authorization
+	Processor     Processor
+	LeaseID       string
+	OperationID   string
+	Amount        Money
+	Status        int
+	Reason        string
+	Final         bool
+}
+
+//operationLease returns how long an operation may keep the authorization, read from PROCESSOR_OPERATION_LEASE
+//it must be longer than a processor can take to answer, an operation that stopped half way frees the authorization once it ran out
+func operationLease() time.Duration {
+	return envDuration("PROCESSOR_OPERATION_LEASE", defaultOperationLease)
+}
+
+//isLeased tells whether another operation is waiting for the processor on the authorization
+func (a *Authorization) isLeased(now time.Time) bool {
+	return a.LeaseID != "" && a.LeasedUntil != nil && now.Before(*a.LeasedUntil)
+}
+
+//claimOperation locks the authorization, lets prepare check the operation and leases the authorization to it
+//prepare returns a nil operation when there is nothing to do, another operation in progress fails with AuthorizationBusy
+func (a *Authorization) claimOperation(merchantID uint32, authId string, db *gorm.DB, prepare func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error)) (op *authorizationOperation, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		locked, err := a.findAuthorizationForUpdate(merchantID, authId, tx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if locked.isLeased(now) {
+			return apierrors.New(constants.AuthorizationBusy)
+		}
+		if locked.LeaseID != "" {
+			logger.FromDB(tx).Warn("authorization lease ran out before its operation finished", logger.Fields{"authorization_id": locked.ID, "lease_id": locked.LeaseID})
+		}
+
+		op, err = prepare(tx, locked)
+		if err != nil || op == nil {
+			return err
+		}
+		if op.Processor, err = locked.processor(); err != nil {
+			return err
+		}
+
+		if op.OperationID, err = nextOperationID(tx, locked.ID); err != nil {
+			return err
+		}
+		op.Authorization, op.LeaseID = locked, ksuid.New().String()
+		return tx.Model(&Authorization{}).Where("id = ?", locked.ID).UpdateColumns(
+			map[string]interface{}{
+				"lease_id":     op.LeaseID,
+				"leased_until": now.Add(operationLease()),
+			},
+		).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+//waitForOperation claims the operation like claimOperation, waiting for an operation in progress to finish first
+//requests on the same authorization are handled one after the other like they were while the row lock was held
+func (a *Authorization) waitForOperation(merchantID uint32, authId string, db *gorm.DB, prepare func(tx *gorm.DB, locked *Authorization) (*authorizationOperation, error)) (*authorizationOperation, error) {
+	deadline := time.Now().Add(operationLease())
+	for {
+		op, err := a.claimOperation(merchantID, authId, db, prepare)
+		if !apierrors.Is(err, constants.AuthorizationBusy) || time.Now().After(deadline) {
+			return op, err
+		}
+		time.Sleep(operationPollInterval)
+	}
+}
+
+//nextOperationID returns the ID of the next operation on the authorization, numbered after the transactions in its ledger
+//every operation the processor agreed to is recorded with a transaction, so one that timed out is sent again with the same ID
+func nextOperationID(tx *gorm.DB, authId string) (string, error) {
+	var recorded int
+	if err := tx.Model(&Transaction{}).Where("authorization_id = ?", authId).Count(&recorded).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d", authId, recorded), nil
+}
+
+//processorRequest returns the request sent to the processor for the operation
+func (op *authorizationOperation) processorRequest() ProcessorRequest {
+	request := op.Authorization.processorRequest(op.Amount, op.Final)
+	request.OperationID = op.OperationID
+	return request
+}
+
+//completeOperation records the processor's answer and ends the lease, record stores what the operation changed
+//the authorization is locked again and only changed while the lease is still the operation's own
+//an operation the processor refused only ends the lease and returns the processor's error
+func (a *Authorization) completeOperation(db *gorm.DB, op *authorizationOperation, processorErr error, record func(tx *gorm.DB, locked *Authorization) error) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		locked, err := a.findAuthorizationForUpdate(op.Authorization.MerchantID, op.Authorization.ID, tx)
+		if err != nil {
+			return err
+		}
+		if locked.LeaseID != op.LeaseID {
+			logger.FromDB(tx).Error("authorization was leased to another operation before the processor answered", logger.Fields{"authorization_id": locked.ID, "lease_id": op.LeaseID, "processor_error": processorErr})
+			return apierrors.New(constants.AuthorizationBusy)
+		}
+
+		err = tx.Model(&Authorization{}).Where("id = ?", locked.ID).UpdateColumns(
+			map[string]interface{}{
+				"lease_id":     "",
+				"leased_until": nil,
+			},
+		).Error
+		if err != nil || processorErr != nil {
+			return err
+		}
+		return record(tx, locked)
+	})
+	if err != nil {
+		if processorErr == nil {
+			logger.FromDB(db).Error("processor operation could not be recorded", logger.Fields{"authorization_id": op.Authorization.ID, "lease_id": op.LeaseID, "error": err})
+		}
+		return err
+	}
+	return processorErr
+}
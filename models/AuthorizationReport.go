@@ -0,0 +1,169 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about the filters of the approval rate report
+// a MerchantID of 0 reports every merchant, nil times are not filtered on
+type ApprovalRateFilter struct {
+	MerchantID  uint32
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+// generic information about how often a decline code was returned
+type DeclineCount struct {
+	DeclineCode string  `json:"declineCode"`
+	Count       int64   `json:"count"`
+	Share       float64 `json:"share"`
+}
+
+// generic information about the approval rate of a merchant, MerchantID is 0 for the total over all merchants
+// Share is the part of the declines with the code
+type ApprovalRate struct {
+	MerchantID   uint32         `json:"merchantId,omitempty"`
+	Attempts     int64          `json:"attempts"`
+	Approved     int64          `json:"approved"`
+	Declined     int64          `json:"declined"`
+	ApprovalRate float64        `json:"approvalRate"`
+	Declines     []DeclineCount `json:"declines"`
+}
+
+// generic information about the approval rate report
+type ApprovalReport struct {
+	Total     ApprovalRate   `json:"total"`
+	Merchants []ApprovalRate `json:"merchants"`
+}
+
+// generic information about the authorizations that can still be captured, read when the metrics are scraped
+// HeldBalances is what is still held on the bank accounts per currency
+type OpenAuthorizationStats struct {
+	OpenAuthorizations map[string]int64
+	HeldBalances       map[string]Money
+}
+
+//ApprovalRates reports how many authorization requests were approved and why the others were declined, per merchant
+//every authorization that is not declined was approved when it was created
+func (a *Authorization) ApprovalRates(filter ApprovalRateFilter, db *gorm.DB) (*ApprovalReport, error) {
+	declined := authStatus(constants.Declined)
+
+	rows := []struct {
+		MerchantID  uint32
+		DeclineCode string
+		Count       int64
+	}{}
+	err := filter.apply(db.Model(Authorization{})).
+		Select("merchant_id, CASE WHEN status = ? THEN COALESCE(decline_code, '') ELSE '' END AS decline_code, COUNT(*) AS count", declined).
+		Group("1, 2").Order("merchant_id asc, count desc, decline_code asc").Scan(&rows).Error
+	if err != nil {
+		return &ApprovalReport{}, err
+	}
+
+	report := &ApprovalReport{Total: ApprovalRate{Declines: []DeclineCount{}}, Merchants: []ApprovalRate{}}
+	totalDeclines := map[string]int64{}
+	for _, row := range rows {
+		if len(report.Merchants) == 0 || report.Merchants[len(report.Merchants)-1].MerchantID != row.MerchantID {
+			report.Merchants = append(report.Merchants, ApprovalRate{MerchantID: row.MerchantID, Declines: []DeclineCount{}})
+		}
+		rate := &report.Merchants[len(report.Merchants)-1]
+		rate.add(row.DeclineCode, row.Count)
+
+		report.Total.Attempts += row.Count
+		if row.DeclineCode == "" {
+			report.Total.Approved += row.Count
+			continue
+		}
+		report.Total.Declined += row.Count
+		if totalDeclines[row.DeclineCode] == 0 {
+			report.Total.Declines = append(report.Total.Declines, DeclineCount{DeclineCode: row.DeclineCode})
+		}
+		totalDeclines[row.DeclineCode] += row.Count
+	}
+
+	for i := range report.Total.Declines {
+		report.Total.Declines[i].Count = totalDeclines[report.Total.Declines[i].DeclineCode]
+	}
+	report.Total.computeRates()
+	for i := range report.Merchants {
+		report.Merchants[i].computeRates()
+	}
+	return report, nil
+}
+
+//apply adds the filter to the query on the authorizations
+func (f ApprovalRateFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.MerchantID != 0 {
+		query = query.Where("merchant_id = ?", f.MerchantID)
+	}
+	if f.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		query = query.Where("created_at < ?", *f.CreatedTo)
+	}
+	return query
+}
+
+//add counts authorizations with the decline code, an empty code counts approved authorizations
+func (r *ApprovalRate) add(declineCode string, count int64) {
+	r.Attempts += count
+	if declineCode == "" {
+		r.Approved += count
+		return
+	}
+	r.Declined += count
+	r.Declines = append(r.Declines, DeclineCount{DeclineCode: declineCode, Count: count})
+}
+
+//computeRates fills in the approval rate and the share of every decline code, declines are sorted by count
+func (r *ApprovalRate) computeRates() {
+	if r.Attempts > 0 {
+		r.ApprovalRate = float64(r.Approved) / float64(r.Attempts)
+	}
+	for i := range r.Declines {
+		r.Declines[i].Share = float64(r.Declines[i].Count) / float64(r.Declined)
+	}
+	sort.SliceStable(r.Declines, func(i, j int) bool {
+		return r.Declines[i].Count > r.Declines[j].Count
+	})
+}
+
+//OpenAuthorizationStats counts the open authorizations per status and sums the active holds per currency, of every merchant
+func (a *Authorization) OpenAuthorizationStats(db *gorm.DB) (*OpenAuthorizationStats, error) {
+	stats := &OpenAuthorizationStats{OpenAuthorizations: map[string]int64{}, HeldBalances: map[string]Money{}}
+
+	statusRows := []struct {
+		Status string
+		Count  int64
+	}{}
+	err := db.Model(Authorization{}).Select("status, COUNT(*) AS count").
+		Where("status IN (?)", authStatuses(constants.Authorized, constants.PartiallyCaptured)).
+		Group("status").Scan(&statusRows).Error
+	if err != nil {
+		return &OpenAuthorizationStats{}, err
+	}
+	for _, row := range statusRows {
+		stats.OpenAuthorizations[row.Status] = row.Count
+	}
+
+	holdRows := []struct {
+		Currency string
+		Amount   Money
+	}{}
+	err = db.Table("holds").Select("bank_accounts.currency AS currency, COALESCE(SUM(holds.amount), 0) AS amount").
+		Joins("JOIN bank_accounts ON bank_accounts.id = holds.bank_account_id").
+		Where("holds.status = ?", constants.HoldStatus(constants.HoldActive).String()).
+		Group("bank_accounts.currency").Scan(&holdRows).Error
+	if err != nil {
+		return &OpenAuthorizationStats{}, err
+	}
+	for _, row := range holdRows {
+		stats.HeldBalances[row.Currency] = row.Amount
+	}
+	return stats, nil
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+//reasons recorded in the status history
+const (
+	ReasonApproved       = "authorization approved"
+	ReasonDeclined       = "authorization declined"
+	ReasonPartialCapture = "partial capture"
+	ReasonFinalCapture   = "final capture"
+	ReasonFullCapture    = "authorized amount fully captured"
+	ReasonVoided         = "voided by merchant"
+	ReasonPartialRefund  = "partial refund"
+	ReasonFinalRefund    = "final refund"
+	ReasonFullRefund     = "captured amount fully refunded"
+	ReasonExpired        = "authorization expired"
+)
+
+//authorizationTransitions lists the statuses each status can move to, "" is a new authorization
+//statuses that are not a key are final
+var authorizationTransitions = map[string][]string{
+	"":                               authStatuses(constants.Authorized, constants.Declined),
+	authStatus(constants.Authorized): authStatuses(constants.PartiallyCaptured, constants.Captured, constants.Voided, constants.Expired),
+	//a refund or the expiry of a partially captured authorization closes it for further captures
+	authStatus(constants.PartiallyCaptured): authStatuses(constants.PartiallyCaptured, constants.Captured, constants.PartiallyRefunded, constants.Refunded),
+	authStatus(constants.Captured):          authStatuses(constants.PartiallyRefunded, constants.Refunded),
+	authStatus(constants.PartiallyRefunded): authStatuses(constants.PartiallyRefunded, constants.Refunded),
+}
+
+// generic information about a change of an authorization's status
+type AuthorizationStatusHistory struct {
+	ID              uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	AuthorizationID string    `gorm:"size:27;not null;index" json:"authorizationId"`
+	MerchantID      uint32    `gorm:"not null" json:"merchantId"`
+	FromStatus      string    `gorm:"size:20;not null" json:"fromStatus"`
+	ToStatus        string    `gorm:"size:20;not null" json:"toStatus"`
+	Reason          string    `gorm:"size:255;not null" json:"reason"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+//TableName keeps the history in a single table name instead of the gorm plural
+func (AuthorizationStatusHistory) TableName() string {
+	return "authorization_status_history"
+}
+
+//ValidateTransition checks that an authorization in status from may move to status to
+func ValidateTransition(from, to string) error {
+	for _, allowed := range authorizationTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	if from == "" {
+		from = "new"
+	}
+	return apierrors.WithDetail(constants.InvalidStatusTransition, from+" to "+to)
+}
+
+//transitionStatus validates the new status and stores it together with the other changed columns
+//a change of status is recorded in the status history within the same transaction
+func (a *Authorization) transitionStatus(tx *gorm.DB, locked *Authorization, to int, reason string, columns map[string]interface{}) error {
+	toStatus := authStatus(to)
+	if err := ValidateTransition(locked.Status, toStatus); err != nil {
+		return err
+	}
+
+	columns["status"] = toStatus
+	columns["updated_at"] = time.Now()
+	err := tx.Model(&Authorization{}).Where("id = ? AND merchant_id = ?", locked.ID, locked.MerchantID).UpdateColumns(columns).Error
+	if err != nil {
+		return err
+	}
+
+	if locked.Status == toStatus {
+		return nil
+	}
+	return recordStatusChange(tx, locked, locked.Status, toStatus, reason)
+}
+
+//recordStatusChange appends a status change of the authorization to its history
+func recordStatusChange(tx *gorm.DB, auth *Authorization, from, to, reason string) error {
+	history := AuthorizationStatusHistory{
+		AuthorizationID: auth.ID,
+		MerchantID:      auth.MerchantID,
+		FromStatus:      from,
+		ToStatus:        to,
+		Reason:          reason,
+	}
+	return tx.Create(&history).Error
+}
+
+//ListStatusHistory returns the status changes of the merchant's authorization in the order they happened
+func (a *Authorization) ListStatusHistory(merchantID uint32, authId string, db *gorm.DB) ([]AuthorizationStatusHistory, error) {
+	history := []AuthorizationStatusHistory{}
+	err := db.Model(AuthorizationStatusHistory{}).Where("authorization_id = ? AND merchant_id = ?", authId, merchantID).Order("id asc").Find(&history).Error
+	if err != nil {
+		return []AuthorizationStatusHistory{}, err
+	}
+	return history, nil
+}
+
+//isCapturable tells whether more can still be captured on the authorization
+func (a *Authorization) isCapturable() bool {
+	return a.Status == authStatus(constants.Authorized) || a.Status == authStatus(constants.PartiallyCaptured)
+}
+
+func authStatus(status int) string {
+	return constants.AuthStatus(status).String()
+}
+
+func authStatuses(statuses ...int) []string {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = authStatus(status)
+	}
+	return names
+}
@@ -1,10 +1,10 @@
 package models
 
 import (
-	"errors"
 	"time"
 	
 	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
 	"github.com/xectich/paymentGateway/constants"
 )
 
@@ -12,21 +12,24 @@ import (
 type BankAccount struct {
 	ID                uint32    `gorm:"primary_key;auto_increment" json:"id"`
 	CardID            string    `gorm:"size:100;not null;unique" json:"cardId"`
-	Balance           float64   `gorm:"not null;" json:"balance"`
-	BalanceAuthorised float64   `gorm:"not null;" json:"balanceAuthorised"`
+	Balance           Money     `gorm:"not null;" json:"balance"`
+	BalanceAuthorised Money     `gorm:"not null;" json:"balanceAuthorised"`
 	Currency          string    `gorm:"size:4;not null;" json:"currency"`
 	CreatedAt         time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt         time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
 //Interface to call Bank Account functions
+//funds are held per authorization so a card can have several authorizations open at once
 type BankAccountI interface {
 	CreateBankAccount(ba *BankAccount, db *gorm.DB) (*BankAccount, error)
 	FindBankAccountByCardID(db *gorm.DB, id string) (*BankAccount, error)
-	AuthorizeBalance(db *gorm.DB, id string, amount float64) error
-	RefundBalance(db *gorm.DB, id string, amount float64) error
-	CaptureBalance(db *gorm.DB, id string, amount float64) error
-	VoidAuthorization(db *gorm.DB, id string) error
+	AvailableBalance(db *gorm.DB, id string) (Money, error)
+	AuthorizeBalance(db *gorm.DB, id string, authId string, amount Money) error
+	RefundBalance(db *gorm.DB, id string, authId string, amount Money) error
+	CaptureBalance(db *gorm.DB, id string, authId string, amount Money) error
+	ReleaseHold(db *gorm.DB, id string, authId string) error
+	VoidAuthorization(db *gorm.DB, id string, authId string) error
 }
 
 func NewBankAccountI() BankAccountI {
@@ -36,7 +39,7 @@ func NewBankAccountI() BankAccountI {
 //CreateBankAccount stores a new BankAccount to the DB
 func (b *BankAccount) CreateBankAccount(bankAccount *BankAccount, db *gorm.DB) (ba *BankAccount, er error) {
 	var err error
-	err = db.Debug().Create(&bankAccount).Error
+	err = db.Create(&bankAccount).Error
 	if err != nil {
 		return &BankAccount{}, err
 	}
@@ -47,138 +50,200 @@ func (b *BankAccount) CreateBankAccount(bankAccount *BankAccount, db *gorm.DB) (
 func (b *BankAccount) FindBankAccountByCardID(db *gorm.DB, cardId string) (ba *BankAccount, er error) {
 	var err error
 	var bankAccount BankAccount
-	err = db.Debug().Model(BankAccount{}).Where("card_id = ?", cardId).Take(&bankAccount).Error
+	err = db.Model(BankAccount{}).Where("card_id = ?", cardId).Take(&bankAccount).Error
 	if err != nil {
 		return &BankAccount{}, err
 	}
 	if gorm.IsRecordNotFoundError(err) {
-		return &BankAccount{}, errors.New(constants.BankAccountNotFound)
+		return &BankAccount{}, apierrors.New(constants.BankAccountNotFound)
 	}
 	return &bankAccount, err
 }
 
-//AuthorizeBalance authrozises the requested balance if possible
-func (b *BankAccount) AuthorizeBalance(db *gorm.DB, cardID string, amount float64) (err error) {
-	ba, err := b.FindBankAccountByCardID(db, cardID)
-	if err != nil {
-		return err
-	}
-
-	if amount == 0 {
-		return errors.New(constants.InvalidAmount)
-	}
-		
-	if amount > ba.Balance {
-		return errors.New(constants.AmountExeedsBalance)
-	}
-
-	db = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&BankAccount{}).UpdateColumns(
-		map[string]interface{}{
-			"balanceAuthorised": amount,
-			"updated_at":         time.Now(),
-		},
-	)
-
-	if db.Error != nil {
-		return db.Error
+//findBankAccountForUpdate retrieves a BA by CardID and locks the row for the rest of the transaction
+func (b *BankAccount) findBankAccountForUpdate(tx *gorm.DB, cardID string) (ba *BankAccount, err error) {
+	var bankAccount BankAccount
+	err = forUpdate(tx).Model(BankAccount{}).Where("card_id = ?", cardID).Take(&bankAccount).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &BankAccount{}, apierrors.New(constants.BankAccountNotFound)
 	}
-
-	err = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&ba).Error
 	if err != nil {
-		return err
+		return &BankAccount{}, err
 	}
-
-	return err
+	return &bankAccount, nil
 }
 
-//RefundBalance refunds the amount and updates the authorized balance
-func (b *BankAccount) RefundBalance(db *gorm.DB, cardID string, amount float64) (err error) {
+//AvailableBalance returns the balance minus the sum of the active holds
+func (b *BankAccount) AvailableBalance(db *gorm.DB, cardID string) (available Money, err error) {
 	ba, err := b.FindBankAccountByCardID(db, cardID)
 	if err != nil {
-		return err
-	}
-
-	if ba.BalanceAuthorised < amount {
-		return errors.New(constants.AmountExeedsAuthorizedBalance)
-	}
-
-	db = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&BankAccount{}).UpdateColumns(
-		map[string]interface{}{
-			"balance":           ba.Balance + amount,
-			"balanceAuthorised": ba.BalanceAuthorised - amount,
-			"updated_at":         time.Now(),
-		},
-	)
-
-	if db.Error != nil {
-		return db.Error
+		return 0, err
 	}
-
-	err = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&ba).Error
+	held, err := activeHoldsTotal(db, ba.ID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	return err
+	return ba.Balance - held, nil
 }
 
-//RefundBalance refunds the amount and updates the authorized balance
-func (b *BankAccount) CaptureBalance(db *gorm.DB, cardID string, amount float64) (err error) {
-	ba, err := b.FindBankAccountByCardID(db, cardID)
-	if err != nil {
-		return err
-	}
-
-	if ba.BalanceAuthorised < amount {
-		return errors.New(constants.AmountExeedsAuthorizedBalance)
-	}
-
-	if ba.Balance < amount {
-		return errors.New(constants.AmountExeedsBalance)
-	}
-
-	db = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&BankAccount{}).UpdateColumns(
-		map[string]interface{}{
-			"balance":           ba.Balance - amount,
-			"balanceAuthorised": ba.BalanceAuthorised - amount,
-			"updated_at":         time.Now(),
-		},
-	)
-
-	if db.Error != nil {
-		return db.Error
-	}
+//AuthorizeBalance places a hold for the authorization if the available balance allows it
+func (b *BankAccount) AuthorizeBalance(db *gorm.DB, cardID string, authId string, amount Money) (err error) {
+	if amount <= 0 {
+		return apierrors.New(constants.InvalidAmount)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		ba, err := b.findBankAccountForUpdate(tx, cardID)
+		if err != nil {
+			return err
+		}
+
+		held, err := activeHoldsTotal(tx, ba.ID)
+		if err != nil {
+			return err
+		}
+		if amount > ba.Balance-held {
+			return apierrors.New(constants.AmountExeedsBalance)
+		}
+
+		hold := Hold{
+			BankAccountID:   ba.ID,
+			AuthorizationID: authId,
+			Amount:          amount,
+			Status:          constants.HoldStatus(constants.HoldActive).String(),
+		}
+		if err = tx.Create(&hold).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&BankAccount{}).Where("id = ?", ba.ID).UpdateColumns(
+			map[string]interface{}{
+				"balance_authorised": held + amount,
+				"updated_at":         time.Now(),
+			},
+		).Error
+	})
+}
 
-	err = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&ba).Error
-	if err != nil {
-		return err
-	}
+//RefundBalance credits the refunded amount back to the bank account
+func (b *BankAccount) RefundBalance(db *gorm.DB, cardID string, authId string, amount Money) (err error) {
+	if amount <= 0 {
+		return apierrors.New(constants.InvalidAmount)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		ba, err := b.findBankAccountForUpdate(tx, cardID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&BankAccount{}).Where("id = ?", ba.ID).UpdateColumns(
+			map[string]interface{}{
+				"balance":    ba.Balance + amount,
+				"updated_at": time.Now(),
+			},
+		).Error
+	})
+}
 
-	return err
+//CaptureBalance takes the amount out of the authorization's hold and debits the bank account
+func (b *BankAccount) CaptureBalance(db *gorm.DB, cardID string, authId string, amount Money) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		ba, err := b.findBankAccountForUpdate(tx, cardID)
+		if err != nil {
+			return err
+		}
+
+		hold, err := findActiveHoldForUpdate(tx, ba.ID, authId)
+		if err != nil {
+			return err
+		}
+
+		if hold.Amount < amount {
+			return apierrors.New(constants.AmountExeedsAuthorizedBalance)
+		}
+
+		if ba.Balance < amount {
+			return apierrors.New(constants.AmountExeedsBalance)
+		}
+
+		holdStatus := hold.Status
+		if hold.Amount == amount {
+			holdStatus = constants.HoldStatus(constants.HoldCaptured).String()
+		}
+		err = tx.Model(&Hold{}).Where("id = ?", hold.ID).UpdateColumns(
+			map[string]interface{}{
+				"amount":     hold.Amount - amount,
+				"status":     holdStatus,
+				"updated_at": time.Now(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&BankAccount{}).Where("id = ?", ba.ID).UpdateColumns(
+			map[string]interface{}{
+				"balance":            ba.Balance - amount,
+				"balance_authorised": ba.BalanceAuthorised - amount,
+				"updated_at":         time.Now(),
+			},
+		).Error
+	})
 }
 
-//VoidAuthorization voids the authorization by reseting the authorized amount
-func (b *BankAccount) VoidAuthorization(db *gorm.DB, cardID string) (err error) {
-	ba, err := b.FindBankAccountByCardID(db, cardID)
-	if err != nil {
-		return err
-	}
+//ReleaseHold releases what is left of the authorization's hold, e.g. after a final partial capture
+func (b *BankAccount) ReleaseHold(db *gorm.DB, cardID string, authId string) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		ba, err := b.findBankAccountForUpdate(tx, cardID)
+		if err != nil {
+			return err
+		}
+
+		hold, err := findActiveHoldForUpdate(tx, ba.ID, authId)
+		if err != nil {
+			//nothing left to release
+			if err.Error() == constants.HoldNotFound {
+				return nil
+			}
+			return err
+		}
+
+		err = tx.Model(&Hold{}).Where("id = ?", hold.ID).UpdateColumns(
+			map[string]interface{}{
+				"status":     constants.HoldStatus(constants.HoldReleased).String(),
+				"updated_at": time.Now(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&BankAccount{}).Where("id = ?", ba.ID).UpdateColumns(
+			map[string]interface{}{
+				"balance_authorised": ba.BalanceAuthorised - hold.Amount,
+				"updated_at":         time.Now(),
+			},
+		).Error
+	})
+}
 
-	db = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&BankAccount{}).UpdateColumns(
-		map[string]interface{}{
-			"balanceAuthorised": 0,
-			"updated_at":         time.Now(),
-		},
-	)
+//VoidAuthorization voids the authorization by releasing only its own hold
+func (b *BankAccount) VoidAuthorization(db *gorm.DB, cardID string, authId string) (err error) {
+	return b.ReleaseHold(db, cardID, authId)
+}
 
-	if db.Error != nil {
-		return db.Error
+//findActiveHoldForUpdate retrieves the active hold of an authorization and locks the row for the rest of the transaction
+func findActiveHoldForUpdate(tx *gorm.DB, bankAccountID uint32, authId string) (*Hold, error) {
+	var hold Hold
+	err := forUpdate(tx).Model(Hold{}).
+		Where("bank_account_id = ? AND authorization_id = ? AND status = ?", bankAccountID, authId, constants.HoldStatus(constants.HoldActive).String()).
+		Take(&hold).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Hold{}, apierrors.New(constants.HoldNotFound)
 	}
-
-	err = db.Debug().Model(&BankAccount{}).Where("card_id = ?", cardID).Take(&ba).Error
 	if err != nil {
-		return err
+		return &Hold{}, err
 	}
-
-	return err
+	return &hold, nil
 }
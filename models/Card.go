@@ -1,25 +1,30 @@
 package models
 
-//Haven't implemnted other methods to update cards, delete cards, wipe them, etc.
-// as it was out of scope for the challenge and again to spare time
-
 import (
-	"errors"
 	"strconv"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
 	"github.com/xectich/paymentGateway/constants"
 )
 
-// generic information about the credit card
+// generic information about the credit card as it is kept in the vault
+// the PAN is only stored encrypted, Fingerprint is used to look it up and BIN and Last4 to display it
+// Number and CVV are only set when a card is stored and are never persisted, the CVV is not kept in any form
 type Card struct {
 	ID              uint32    `gorm:"primary_key;auto_increment" json:"id"`
-	Number          string    `gorm:"size:100;not null;unique" json:"number"`
-	CVV             string    `gorm:"size:4;not null;unique" json:"cvv"`
+	Fingerprint     string    `gorm:"size:64;unique_index" json:"-"`
+	Brand           string    `gorm:"size:20" json:"brand"`
+	BIN             string    `gorm:"size:8" json:"bin"`
+	Last4           string    `gorm:"size:4" json:"last4"`
+	EncryptedPAN    string    `gorm:"type:text" json:"-"`
+	WrappedKey      string    `gorm:"type:text" json:"-"`
 	Currency        string    `gorm:"size:4;not null;" json:"currency"`
 	ExpirationMonth int       `gorm:"not null;" json:"expirationMonth"`
 	ExpirationYear  int       `gorm:"not null;" json:"expirationYear"`
+	Number          string    `gorm:"-" json:"-"`
+	CVV             string    `gorm:"-" json:"-"`
 	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
@@ -28,8 +33,17 @@ type Card struct {
 type CardI interface {
 	Validate(card *Card, cvv string, expirationMonth, expirationYear int) error
 	SaveCard(card *Card, db *gorm.DB) (*Card, error)
+	StoreCard(card *Card, db *gorm.DB) (*Card, error)
 	FindCardByID(db *gorm.DB, id uint32) (*Card, error)
 	FindCardByNumber(db *gorm.DB, number string) (*Card, error)
+	FindCardByToken(db *gorm.DB, merchantID uint32, token string) (*Card, error)
+	Tokenize(db *gorm.DB, merchantID uint32, card *Card) (*CardToken, error)
+	RevealNumber(card *Card) (string, error)
+	RegisterCard(db *gorm.DB, merchantID uint32, cardRequest CardRequest) (CardSummary, error)
+	ListCards(db *gorm.DB, merchantID uint32) ([]CardSummary, error)
+	UpdateCardExpiry(db *gorm.DB, merchantID uint32, token string, expirationMonth, expirationYear int) (CardSummary, error)
+	DeleteCard(db *gorm.DB, merchantID uint32, token string) error
+	WipeCard(db *gorm.DB, merchantID uint32, token string) error
 	ValidateLuhnNumber(cardNumber string) bool
 }
 
@@ -38,55 +52,51 @@ func NewCardI() CardI {
 }
 
 // Validate returns an error if validation fails
-// Checks for expiration date, CVV and the credit card's numbers when adding a existing.
+// Checks the CVV's format and that the expiration date matches the stored one and is not over when using an existing card.
+// The CVV itself is verified by the issuer when the card is authorized.
 func (c *Card) Validate(card *Card, cvv string, expirationMonth, expirationYear int) (err error) {
-	if card.CVV != cvv {
-		return errors.New(constants.NoMatchCVV)
+	if err = card.validateCVV(cvv); err != nil {
+		return err
 	}
+	return card.verifyExpiry(expirationMonth, expirationYear, time.Now())
+}
 
-	if card.ExpirationMonth != expirationMonth || card.ExpirationYear != expirationYear {
-		return errors.New(constants.NoMatchCardExpirationDate)
+// validateCVV checks that the CVV has the length of the card's brand, only the issuer can tell whether it matches
+func (c *Card) validateCVV(cvv string) error {
+	if !c.validCVVLength(cvv) {
+		return apierrors.New(constants.InvalidCVV)
 	}
+	return nil
+}
 
+// verifyExpiry checks that the expiration date matches the stored one and that the card has not expired
+func (c *Card) verifyExpiry(expirationMonth, expirationYear int, now time.Time) error {
+	if c.ExpirationMonth != expirationMonth || c.ExpirationYear != NormalizeExpirationYear(expirationYear) {
+		return apierrors.New(constants.NoMatchCardExpirationDate)
+	}
+	if c.isExpired(now) {
+		return apierrors.New(constants.CreditCardExpired)
+	}
 	return nil
 }
 
 // ValidateNewCard returns an error if validation fails
 // Checks for expiration date, CVV and the credit card's numbers when adding a new card.
 func validateNewCard(card *Card) (err error) {
-	var year, month int
-
-	// Validate the expiration month
-	month = card.ExpirationMonth
-	if month < 1 || 12 < month {
-		return errors.New(constants.InvalidMonth)
+	card.ExpirationYear = NormalizeExpirationYear(card.ExpirationYear)
+	if err = validateExpiry(card.ExpirationMonth, card.ExpirationYear); err != nil {
+		return err
 	}
 
-	// Validate the expiration year
-	year = card.ExpirationYear
-	if year < time.Now().UTC().Year() {
-		return errors.New(constants.CreditCardExpired)
-	}
-
-	// Check the expired  year and month
-	if year == time.Now().UTC().Year() && month < int(time.Now().UTC().Month()) {
-		return errors.New(constants.CreditCardExpired)
-	}
-
-	// Validate the CVV length
-	if len(card.CVV) < 3 || len(card.CVV) > 4 {
-		return errors.New(constants.InvalidCVV)
-	}
-
-	// Validate the Card number length
-	if len(card.Number) < 13 {
-		return errors.New(constants.InvalidCreditCardNumber)
+	// Validate the Card number and CVV lengths of the card's brand
+	if err = validateCardBrand(card); err != nil {
+		return err
 	}
 
 	// Valida the number using Luhn algorithm
 	valid := card.ValidateLuhnNumber(card.Number)
 	if !valid {
-		return errors.New(constants.InvalidCreditCardNumber)
+		return apierrors.New(constants.InvalidCreditCardNumber)
 	}
 
 	return nil
@@ -123,14 +133,41 @@ func (c *Card) ValidateLuhnNumber(cardNumber string) bool {
 	return sum%10 == 0
 }
 
-//SaveCard stores a new card to the DB
+//SaveCard validates a new card and stores it in the vault
 func (c *Card) SaveCard(card *Card, db *gorm.DB) (cc *Card, er error) {
-	var err error
 	if err := validateNewCard(card); err != nil {
 		return &Card{}, err
 	}
+	return c.StoreCard(card, db)
+}
+
+//StoreCard encrypts the card's number and stores it in the vault, the CVV is not stored
+func (c *Card) StoreCard(card *Card, db *gorm.DB) (cc *Card, err error) {
+	if !c.ValidateLuhnNumber(card.Number) {
+		return &Card{}, apierrors.New(constants.InvalidCreditCardNumber)
+	}
+
+	if err = validateCardBrand(card); err != nil {
+		return &Card{}, err
+	}
+	card.ExpirationYear = NormalizeExpirationYear(card.ExpirationYear)
+
+	vault, err := getVault()
+	if err != nil {
+		return &Card{}, err
+	}
 
-	err = db.Debug().Create(&card).Error
+	card.Fingerprint = vault.Fingerprint(card.Number)
+	card.BIN = card.Number[:6]
+	card.Last4 = card.Number[len(card.Number)-4:]
+	card.EncryptedPAN, card.WrappedKey, err = vault.Encrypt(card.Number, card.Fingerprint)
+	if err != nil {
+		return &Card{}, err
+	}
+
+	err = db.Create(card).Error
+	//the plain values are not needed anymore once the card is stored
+	card.Number, card.CVV = "", ""
 	if err != nil {
 		return &Card{}, err
 	}
@@ -139,28 +176,69 @@ func (c *Card) SaveCard(card *Card, db *gorm.DB) (cc *Card, er error) {
 
 //FindCardByID retrieves a card by ID from the DB
 func (c *Card) FindCardByID(db *gorm.DB, id uint32) (cc *Card, er error) {
-	var err error
 	var card Card
-	err = db.Debug().Model(Card{}).Where("id = ?", id).Take(&card).Error
+	err := db.Model(Card{}).Where("id = ?", id).Take(&card).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Card{}, apierrors.New(constants.CardNotFound)
+	}
 	if err != nil {
 		return &Card{}, err
 	}
-	if gorm.IsRecordNotFoundError(err) {
-		return &Card{}, errors.New(constants.CardNotFound)
-	}
-	return &card, err
+	return &card, nil
 }
 
-//FindCardByID retrieves a card by ID from the DB
+//FindCardByNumber retrieves a card by the fingerprint of its number from the DB
+//the expiry is the one the card was first stored with, merchants keep their own on their tokens
 func (c *Card) FindCardByNumber(db *gorm.DB, number string) (cc *Card, er error) {
-	var err error
+	vault, err := getVault()
+	if err != nil {
+		return &Card{}, err
+	}
+
 	var card Card
-	err = db.Debug().Model(Card{}).Where("number = ?", number).Take(&card).Error
+	err = db.Model(Card{}).Where("fingerprint = ?", vault.Fingerprint(number)).Take(&card).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Card{}, apierrors.New(constants.CardNotFound)
+	}
 	if err != nil {
 		return &Card{}, err
 	}
+	return &card, nil
+}
+
+//FindCardByToken retrieves the card behind one of the merchant's live tokens with the expiry kept on the token
+func (c *Card) FindCardByToken(db *gorm.DB, merchantID uint32, token string) (cc *Card, er error) {
+	var cardToken CardToken
+	err := db.Model(CardToken{}).Where("token = ? AND merchant_id = ?", token, merchantID).Take(&cardToken).Error
 	if gorm.IsRecordNotFoundError(err) {
-		return &Card{}, errors.New(constants.CardNotFound)
+		return &Card{}, apierrors.New(constants.CardTokenNotFound)
+	}
+	if err != nil {
+		return &Card{}, err
+	}
+	if cardToken.Wiped {
+		return &Card{}, apierrors.New(constants.CardWiped)
+	}
+	if cardToken.Deleted {
+		return &Card{}, apierrors.New(constants.CardTokenNotFound)
+	}
+
+	card, err := c.FindCardByID(db, cardToken.CardID)
+	if err != nil {
+		return &Card{}, err
+	}
+	card.ExpirationMonth, card.ExpirationYear = cardToken.ExpirationMonth, cardToken.ExpirationYear
+	return card, nil
+}
+
+//RevealNumber decrypts the card's number, it must only be used to hand the card to the bank
+func (c *Card) RevealNumber(card *Card) (string, error) {
+	if card.isWiped() {
+		return "", apierrors.New(constants.CardWiped)
+	}
+	vault, err := getVault()
+	if err != nil {
+		return "", err
 	}
-	return &card, err
+	return vault.Decrypt(card.EncryptedPAN, card.WrappedKey, card.Fingerprint)
 }
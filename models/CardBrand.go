@@ -0,0 +1,166 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// a range of card number prefixes issued to a brand, Low and High have the same number of digits
+type binRange struct {
+	Low   string
+	High  string
+	Brand constants.CardBrand
+}
+
+// the card number lengths and the CVV length of a brand
+type cardBrandRule struct {
+	PANLengths []int
+	CVVLength  int
+}
+
+//binRanges identifies the brand of a card number, the longest matching prefix wins
+var binRanges = []binRange{
+	{Low: "4", High: "4", Brand: constants.Visa},
+	{Low: "51", High: "55", Brand: constants.Mastercard},
+	{Low: "2221", High: "2720", Brand: constants.Mastercard},
+	{Low: "34", High: "34", Brand: constants.Amex},
+	{Low: "37", High: "37", Brand: constants.Amex},
+	{Low: "6011", High: "6011", Brand: constants.Discover},
+	{Low: "644", High: "649", Brand: constants.Discover},
+	{Low: "65", High: "65", Brand: constants.Discover},
+	{Low: "3528", High: "3589", Brand: constants.JCB},
+	{Low: "62", High: "62", Brand: constants.UnionPay},
+	{Low: "300", High: "305", Brand: constants.Diners},
+	{Low: "3095", High: "3095", Brand: constants.Diners},
+	{Low: "36", High: "36", Brand: constants.Diners},
+	{Low: "38", High: "39", Brand: constants.Diners},
+	{Low: "50", High: "50", Brand: constants.Maestro},
+	{Low: "56", High: "58", Brand: constants.Maestro},
+	{Low: "6304", High: "6304", Brand: constants.Maestro},
+	{Low: "67", High: "67", Brand: constants.Maestro},
+}
+
+var cardBrandRules = map[constants.CardBrand]cardBrandRule{
+	constants.Visa:       {PANLengths: []int{13, 16, 19}, CVVLength: 3},
+	constants.Mastercard: {PANLengths: []int{16}, CVVLength: 3},
+	constants.Amex:       {PANLengths: []int{15}, CVVLength: 4},
+	constants.Discover:   {PANLengths: panLengths(16, 19), CVVLength: 3},
+	constants.JCB:        {PANLengths: panLengths(16, 19), CVVLength: 3},
+	constants.UnionPay:   {PANLengths: panLengths(16, 19), CVVLength: 3},
+	constants.Diners:     {PANLengths: panLengths(14, 19), CVVLength: 3},
+	constants.Maestro:    {PANLengths: panLengths(13, 19), CVVLength: 3},
+}
+
+//DetectCardBrand returns the brand of a card number, a BIN is enough
+func DetectCardBrand(number string) (constants.CardBrand, error) {
+	var brand constants.CardBrand
+	matched := 0
+	for _, r := range binRanges {
+		if len(r.Low) <= matched || len(number) < len(r.Low) {
+			continue
+		}
+		prefix := number[:len(r.Low)]
+		if prefix >= r.Low && prefix <= r.High {
+			brand, matched = r.Brand, len(r.Low)
+		}
+	}
+	if matched == 0 {
+		return 0, apierrors.New(constants.UnknownCardBrand)
+	}
+	return brand, nil
+}
+
+//parseCardBrand returns the brand with the given name
+func parseCardBrand(name string) (constants.CardBrand, error) {
+	for brand := constants.CardBrand(constants.Visa); brand <= constants.Maestro; brand++ {
+		if strings.EqualFold(brand.String(), name) {
+			return brand, nil
+		}
+	}
+	return 0, apierrors.New(constants.UnknownCardBrand)
+}
+
+//validateCardBrand detects the brand of a new card and checks its number and CVV lengths against the brand's rules
+func validateCardBrand(card *Card) error {
+	brand, err := DetectCardBrand(card.Number)
+	if err != nil {
+		return err
+	}
+
+	rule := cardBrandRules[brand]
+	if !containsInt(rule.PANLengths, len(card.Number)) {
+		return apierrors.New(constants.InvalidCreditCardNumber)
+	}
+	if len(card.CVV) != rule.CVVLength {
+		return apierrors.New(constants.InvalidCVV)
+	}
+
+	card.Brand = brand.String()
+	return nil
+}
+
+//validCVVLength checks the CVV length against the rules of the card's brand
+//cards without a known brand only need 3 or 4 digits
+func (c *Card) validCVVLength(cvv string) bool {
+	brand, err := parseCardBrand(c.Brand)
+	if err != nil {
+		return len(cvv) == 3 || len(cvv) == 4
+	}
+	return len(cvv) == cardBrandRules[brand].CVVLength
+}
+
+//merchantAcceptsCard checks that the merchant accepts the brand of the card
+//merchants that cannot be found accept every brand, like they get the default authorization validity
+func merchantAcceptsCard(merchantID uint32, card *Card, db *gorm.DB) error {
+	merchantI := NewMerchantI()
+	merchant, err := merchantI.FindMerchantByID(db, merchantID)
+	if err != nil {
+		if err.Error() == constants.MerchantNotFound {
+			return nil
+		}
+		return err
+	}
+	if !merchant.AcceptsCardBrand(card.Brand) {
+		return apierrors.New(constants.CardBrandNotAccepted)
+	}
+	return nil
+}
+
+//MigrateCardBrands sets the brand of the cards stored before brands were detected, from their BIN
+func MigrateCardBrands(db *gorm.DB) error {
+	cards := []Card{}
+	if err := db.Model(Card{}).Where("brand IS NULL OR brand = ''").Find(&cards).Error; err != nil {
+		return err
+	}
+
+	for _, card := range cards {
+		brand, err := DetectCardBrand(card.BIN)
+		if err != nil {
+			continue
+		}
+		if err = db.Model(&Card{}).Where("id = ?", card.ID).UpdateColumn("brand", brand.String()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func panLengths(from, to int) []int {
+	lengths := []int{}
+	for l := from; l <= to; l++ {
+		lengths = append(lengths, l)
+	}
+	return lengths
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"os"
+	"time"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+//NormalizeExpirationYear turns a 2 digit expiration year into a 4 digit one, 4 digit years are returned as they are
+func NormalizeExpirationYear(year int) int {
+	if year >= 0 && year < 100 {
+		return 2000 + year
+	}
+	return year
+}
+
+//CardExpiryLocation returns the timezone card expiry is checked in, set with CARD_EXPIRY_TIMEZONE (default UTC)
+func CardExpiryLocation() *time.Location {
+	name := os.Getenv("CARD_EXPIRY_TIMEZONE")
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logger.Default().Warn("invalid CARD_EXPIRY_TIMEZONE, using UTC", logger.Fields{"timezone": name, "error": err})
+		return time.UTC
+	}
+	return loc
+}
+
+//CardExpiresAt returns the first instant after the expiration month, cards are valid until the end of the month
+func CardExpiresAt(month, year int) time.Time {
+	return time.Date(NormalizeExpirationYear(year), time.Month(month)+1, 1, 0, 0, 0, 0, CardExpiryLocation())
+}
+
+// validateExpiry returns an error if the expiration month is invalid or already over
+func validateExpiry(month, year int) error {
+	// Validate the expiration month
+	if month < 1 || 12 < month {
+		return apierrors.New(constants.InvalidMonth)
+	}
+
+	// Check the card is still valid at the end of its expiration month
+	if !time.Now().Before(CardExpiresAt(month, year)) {
+		return apierrors.New(constants.CreditCardExpired)
+	}
+
+	return nil
+}
+
+//isExpired tells whether the card's expiration month is over
+func (c *Card) isExpired(now time.Time) bool {
+	return !now.Before(CardExpiresAt(c.ExpirationMonth, c.ExpirationYear))
+}
@@ -0,0 +1,222 @@
+package models
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+const (
+	defaultCardLockoutWindow          = 15 * time.Minute
+	defaultCardLockoutDuration        = 30 * time.Minute
+	defaultCardLockoutMaxFailures     = 5
+	defaultCardLockoutMaxCardFailures = 10
+)
+
+// generic information about a CVV or expiry check of a card that did not match
+type CardVerificationFailure struct {
+	ID         uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	CardID     uint32    `gorm:"not null;index" json:"cardId"`
+	MerchantID uint32    `gorm:"not null;index" json:"merchantId"`
+	Reason     string    `gorm:"size:40;not null" json:"reason"`
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+}
+
+// generic information about a card blocked after too many failed verification attempts
+// MerchantID 0 blocks the card for every merchant, BIN and Last4 are kept so admins can recognize the card
+type CardLockout struct {
+	ID          uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	CardID      uint32     `gorm:"not null;index" json:"cardId"`
+	MerchantID  uint32     `gorm:"not null;default:0" json:"merchantId"`
+	BIN         string     `gorm:"size:8" json:"bin"`
+	Last4       string     `gorm:"size:4" json:"last4"`
+	Failures    int        `gorm:"not null" json:"failures"`
+	LockedUntil time.Time  `gorm:"not null;index" json:"lockedUntil"`
+	UnlockedAt  *time.Time `json:"unlockedAt"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// how many failed verification attempts lock a card and for how long
+// MaxFailures applies to the attempts of one merchant, MaxCardFailures to those of every merchant together
+type CardLockoutPolicy struct {
+	Window          time.Duration
+	Duration        time.Duration
+	MaxFailures     int
+	MaxCardFailures int
+}
+
+//Interface to call Card Lockout functions
+type CardLockoutI interface {
+	ListCardLockouts(db *gorm.DB, cardToken string, now time.Time) ([]CardLockout, error)
+	UnlockCard(db *gorm.DB, id uint32) (*CardLockout, error)
+}
+
+func NewCardLockoutI() CardLockoutI {
+	return &CardLockout{}
+}
+
+//CardLockoutPolicyFromEnv reads CARD_LOCKOUT_WINDOW, CARD_LOCKOUT_DURATION, CARD_LOCKOUT_MAX_FAILURES and CARD_LOCKOUT_MAX_CARD_FAILURES
+func CardLockoutPolicyFromEnv() CardLockoutPolicy {
+	policy := CardLockoutPolicy{
+		Window:          envDuration("CARD_LOCKOUT_WINDOW", defaultCardLockoutWindow),
+		Duration:        envDuration("CARD_LOCKOUT_DURATION", defaultCardLockoutDuration),
+		MaxFailures:     defaultCardLockoutMaxFailures,
+		MaxCardFailures: defaultCardLockoutMaxCardFailures,
+	}
+	if failures, err := strconv.Atoi(os.Getenv("CARD_LOCKOUT_MAX_FAILURES")); err == nil && failures > 0 {
+		policy.MaxFailures = failures
+	}
+	if failures, err := strconv.Atoi(os.Getenv("CARD_LOCKOUT_MAX_CARD_FAILURES")); err == nil && failures > 0 {
+		policy.MaxCardFailures = failures
+	}
+	return policy
+}
+
+//verifyCardAttempt runs the checks of the card's details unless it is locked for the merchant
+//a CVV or expiry that does not match is counted and locks the card once the policy's threshold is reached
+func verifyCardAttempt(db *gorm.DB, merchantID uint32, card *Card, verify func() error) error {
+	now := time.Now()
+	locked, err := cardLocked(db, merchantID, card.ID, now)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return apierrors.New(constants.CardLocked)
+	}
+
+	return countVerificationFailure(db, merchantID, card, verify(), now)
+}
+
+//countVerificationFailure records a CVV or expiry that did not match and returns the error, other errors are returned as they are
+//CVVs are verified by the issuer so a mismatch it reports is counted the same way as the gateway's own expiry check
+func countVerificationFailure(db *gorm.DB, merchantID uint32, card *Card, verifyErr error, now time.Time) error {
+	if verifyErr == nil || (verifyErr.Error() != constants.NoMatchCVV && verifyErr.Error() != constants.NoMatchCardExpirationDate) {
+		return verifyErr
+	}
+	if err := recordVerificationFailure(db, merchantID, card, verifyErr, CardLockoutPolicyFromEnv(), now); err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+//cardLocked tells whether the card has an active lockout for the merchant or for every merchant
+func cardLocked(db *gorm.DB, merchantID uint32, cardID uint32, now time.Time) (bool, error) {
+	var count int
+	err := db.Model(&CardLockout{}).
+		Where("card_id = ? AND (merchant_id = 0 OR merchant_id = ?)", cardID, merchantID).
+		Where("unlocked_at IS NULL AND locked_until > ?", now).
+		Count(&count).Error
+	return count > 0, err
+}
+
+//recordVerificationFailure stores the failed attempt and locks the card when the attempts within the window reach a threshold
+func recordVerificationFailure(db *gorm.DB, merchantID uint32, card *Card, verifyErr error, policy CardLockoutPolicy, now time.Time) error {
+	failure := CardVerificationFailure{
+		CardID:     card.ID,
+		MerchantID: merchantID,
+		Reason:     apierrors.From(verifyErr, 0).Code,
+		CreatedAt:  now,
+	}
+	if err := db.Create(&failure).Error; err != nil {
+		return err
+	}
+
+	since := now.Add(-policy.Window)
+	var merchantFailures, cardFailures int
+	err := db.Model(&CardVerificationFailure{}).
+		Where("card_id = ? AND merchant_id = ? AND created_at > ?", card.ID, merchantID, since).
+		Count(&merchantFailures).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&CardVerificationFailure{}).
+		Where("card_id = ? AND created_at > ?", card.ID, since).
+		Count(&cardFailures).Error
+	if err != nil {
+		return err
+	}
+
+	if cardFailures >= policy.MaxCardFailures {
+		return lockCard(db, 0, card, cardFailures, now.Add(policy.Duration))
+	}
+	if merchantFailures >= policy.MaxFailures {
+		return lockCard(db, merchantID, card, merchantFailures, now.Add(policy.Duration))
+	}
+	return nil
+}
+
+//lockCard blocks the card for the merchant, or for every merchant when merchantID is 0, until the given time
+func lockCard(db *gorm.DB, merchantID uint32, card *Card, failures int, lockedUntil time.Time) error {
+	lockout := CardLockout{
+		CardID:      card.ID,
+		MerchantID:  merchantID,
+		BIN:         card.BIN,
+		Last4:       card.Last4,
+		Failures:    failures,
+		LockedUntil: lockedUntil,
+	}
+	if err := db.Create(&lockout).Error; err != nil {
+		return err
+	}
+	logger.FromDB(db).Warn("card locked", logger.Fields{"lockout_id": lockout.ID, "merchant_id": merchantID, "last4": card.Last4, "failures": failures})
+	return nil
+}
+
+//ListCardLockouts returns the active lockouts newest first, only those of the card behind the token when it is set
+func (l *CardLockout) ListCardLockouts(db *gorm.DB, cardToken string, now time.Time) ([]CardLockout, error) {
+	query := db.Model(&CardLockout{}).Where("unlocked_at IS NULL AND locked_until > ?", now)
+	if cardToken != "" {
+		var token CardToken
+		err := db.Model(&CardToken{}).Where("token = ?", cardToken).Take(&token).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return []CardLockout{}, apierrors.New(constants.CardTokenNotFound)
+		}
+		if err != nil {
+			return []CardLockout{}, err
+		}
+		query = query.Where("card_id = ?", token.CardID)
+	}
+
+	lockouts := []CardLockout{}
+	if err := query.Order("id DESC").Find(&lockouts).Error; err != nil {
+		return []CardLockout{}, err
+	}
+	return lockouts, nil
+}
+
+//UnlockCard ends the lockout and forgets the failed attempts it counted, so the card gets the full number of attempts again
+func (l *CardLockout) UnlockCard(db *gorm.DB, id uint32) (*CardLockout, error) {
+	var lockout CardLockout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := forUpdate(tx).Model(&CardLockout{}).Where("id = ?", id).Take(&lockout).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return apierrors.New(constants.CardLockoutNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		if lockout.UnlockedAt == nil {
+			now := time.Now()
+			lockout.UnlockedAt = &now
+			if err = tx.Model(&CardLockout{}).Where("id = ?", id).UpdateColumn("unlocked_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		failures := tx.Where("card_id = ?", lockout.CardID)
+		if lockout.MerchantID != 0 {
+			failures = failures.Where("merchant_id = ?", lockout.MerchantID)
+		}
+		return failures.Delete(&CardVerificationFailure{}).Error
+	})
+	if err != nil {
+		return &CardLockout{}, err
+	}
+	return &lockout, nil
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about the card registration request
+type CardRequest struct {
+	Number          string `json:"cardNumber"`
+	CVV             string `json:"cvv"`
+	Currency        string `json:"currency"`
+	ExpirationMonth int    `json:"expirationMonth"`
+	ExpirationYear  int    `json:"expirationYear"`
+}
+
+// generic information about the card expiry update request
+type CardExpiryRequest struct {
+	ExpirationMonth int `json:"expirationMonth"`
+	ExpirationYear  int `json:"expirationYear"`
+}
+
+//RegisterCard validates the card, stores it in the vault and returns the merchant's token for it
+//a card that is already in the vault must match the expiry of the merchant's token, a merchant without one keeps the expiry it sent
+//the number of a card whose number was wiped is put back in the vault once the expiry matched
+//failed attempts are counted outside the transaction so they are kept when the registration fails
+func (c *Card) RegisterCard(db *gorm.DB, merchantID uint32, cardRequest CardRequest) (summary CardSummary, err error) {
+	card := Card{
+		Number:          cardRequest.Number,
+		CVV:             cardRequest.CVV,
+		Currency:        strings.ToUpper(cardRequest.Currency),
+		ExpirationMonth: cardRequest.ExpirationMonth,
+		ExpirationYear:  cardRequest.ExpirationYear,
+	}
+	if err = validateNewCard(&card); err != nil {
+		return CardSummary{}, err
+	}
+	if len(card.Currency) != 3 {
+		return CardSummary{}, apierrors.New(constants.InvalidCurrency)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		stored, err := c.FindCardByNumber(tx, card.Number)
+		if err != nil && err.Error() == constants.CardNotFound {
+			stored, err = c.StoreCard(&card, tx)
+		} else if err == nil {
+			err = c.verifyStoredCard(db, tx, merchantID, stored, &card)
+		}
+		if err != nil {
+			return err
+		}
+
+		cardToken, err := c.Tokenize(tx, merchantID, stored)
+		if err != nil {
+			return err
+		}
+		summary = stored.Summary(cardToken.Token)
+		return nil
+	})
+	if err != nil {
+		return CardSummary{}, err
+	}
+	return summary, nil
+}
+
+//verifyStoredCard checks the registered card against the one already in the vault and restores its number if it was wiped
+//the expiry is only compared with the merchant's own token, the vault's may be from before the card was reissued
+func (c *Card) verifyStoredCard(db *gorm.DB, tx *gorm.DB, merchantID uint32, stored *Card, card *Card) error {
+	hasToken, err := stored.withMerchantExpiry(tx, merchantID)
+	if err != nil {
+		return err
+	}
+	if !hasToken {
+		stored.ExpirationMonth, stored.ExpirationYear = card.ExpirationMonth, card.ExpirationYear
+	}
+	err = verifyCardAttempt(db, merchantID, stored, func() error {
+		return c.Validate(stored, card.CVV, card.ExpirationMonth, card.ExpirationYear)
+	})
+	if err != nil {
+		return err
+	}
+	if stored.isWiped() {
+		return c.restoreCard(tx, stored, card)
+	}
+	return nil
+}
+
+//restoreCard stores the number of a wiped card in the vault again
+//the number matched the card's fingerprint, the rest of the stored card is kept as it is
+func (c *Card) restoreCard(tx *gorm.DB, stored *Card, card *Card) error {
+	vault, err := getVault()
+	if err != nil {
+		return err
+	}
+
+	encryptedPAN, wrappedKey, err := vault.Encrypt(card.Number, stored.Fingerprint)
+	if err != nil {
+		return err
+	}
+	stored.EncryptedPAN, stored.WrappedKey = encryptedPAN, wrappedKey
+
+	return tx.Model(&Card{}).Where("id = ? AND encrypted_pan = ?", stored.ID, "").UpdateColumns(
+		map[string]interface{}{
+			"encrypted_pan": stored.EncryptedPAN,
+			"wrapped_key":   stored.WrappedKey,
+			"updated_at":    time.Now(),
+		},
+	).Error
+}
+
+//ListCards returns the cards the merchant has tokens for, newest first, with masked numbers
+func (c *Card) ListCards(db *gorm.DB, merchantID uint32) ([]CardSummary, error) {
+	tokens := []string{}
+	err := db.Model(CardToken{}).Where("merchant_id = ? AND deleted = ?", merchantID, false).Order("created_at desc").Pluck("token", &tokens).Error
+	if err != nil {
+		return []CardSummary{}, err
+	}
+
+	summaries, err := cardSummaries(db, tokens)
+	if err != nil {
+		return []CardSummary{}, err
+	}
+
+	cards := make([]CardSummary, 0, len(tokens))
+	for _, token := range tokens {
+		if summary, ok := summaries[token]; ok {
+			cards = append(cards, summary)
+		}
+	}
+	return cards, nil
+}
+
+//UpdateCardExpiry sets a new expiry on the merchant's token, the card and the tokens of other merchants keep theirs
+func (c *Card) UpdateCardExpiry(db *gorm.DB, merchantID uint32, token string, expirationMonth, expirationYear int) (CardSummary, error) {
+	expirationYear = NormalizeExpirationYear(expirationYear)
+	if err := validateExpiry(expirationMonth, expirationYear); err != nil {
+		return CardSummary{}, err
+	}
+
+	card, err := c.FindCardByToken(db, merchantID, token)
+	if err != nil {
+		return CardSummary{}, err
+	}
+
+	updated := db.Model(&CardToken{}).Where("token = ? AND merchant_id = ? AND deleted = ?", token, merchantID, false).UpdateColumns(
+		map[string]interface{}{
+			"expiration_month": expirationMonth,
+			"expiration_year":  expirationYear,
+		},
+	)
+	if updated.Error != nil {
+		return CardSummary{}, updated.Error
+	}
+	if updated.RowsAffected == 0 {
+		return CardSummary{}, apierrors.New(constants.CardTokenNotFound)
+	}
+
+	card.ExpirationMonth, card.ExpirationYear = expirationMonth, expirationYear
+	return card.Summary(token), nil
+}
+
+//DeleteCard deletes the merchant's token, authorizations made with it keep working
+//registering the card again issues a new token
+func (c *Card) DeleteCard(db *gorm.DB, merchantID uint32, token string) error {
+	return deleteCardToken(db, merchantID, token, false)
+}
+
+//deleteCardToken tombstones one of the merchant's live tokens, a wiped token tells that the merchant asked for the number to be removed
+func deleteCardToken(db *gorm.DB, merchantID uint32, token string, wiped bool) error {
+	deleted := db.Model(&CardToken{}).Where("token = ? AND merchant_id = ? AND deleted = ?", token, merchantID, false).UpdateColumns(
+		map[string]interface{}{
+			"deleted": true,
+			"wiped":   wiped,
+		},
+	)
+	if deleted.Error != nil {
+		return deleted.Error
+	}
+	if deleted.RowsAffected == 0 {
+		return apierrors.New(constants.CardTokenNotFound)
+	}
+	return nil
+}
+
+//WipeCard tombstones the merchant's token, other merchants keep using the card with their own tokens
+//the encrypted number is removed from the vault once no merchant has a live token for the card anymore
+//the fingerprint, BIN and last 4 digits are kept so existing authorizations can still be settled and shown
+func (c *Card) WipeCard(db *gorm.DB, merchantID uint32, token string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		card, err := c.FindCardByToken(tx, merchantID, token)
+		if err != nil {
+			return err
+		}
+
+		//wipes of the same card by several merchants run one after the other so the last one removes the number
+		if err = forUpdate(tx).Where("id = ?", card.ID).Take(&Card{}).Error; err != nil {
+			return err
+		}
+		if err = deleteCardToken(tx, merchantID, token, true); err != nil {
+			return err
+		}
+
+		var liveTokens int
+		err = tx.Model(&CardToken{}).Where("card_id = ? AND deleted = ?", card.ID, false).Count(&liveTokens).Error
+		if err != nil || liveTokens > 0 {
+			return err
+		}
+		return tx.Model(&Card{}).Where("id = ?", card.ID).UpdateColumns(
+			map[string]interface{}{
+				"encrypted_pan": "",
+				"wrapped_key":   "",
+				"updated_at":    time.Now(),
+			},
+		).Error
+	})
+}
+
+//isWiped tells whether the card's number was removed from the vault
+func (c *Card) isWiped() bool {
+	return c.EncryptedPAN == ""
+}
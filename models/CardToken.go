@@ -0,0 +1,160 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const cardTokenPrefix = "card_"
+
+// generic information about the opaque token a merchant uses instead of a card number
+// tokens are scoped to the merchant, a merchant has at most one live token per card
+// the card row is shared by every merchant, so what a merchant changes about the card is kept on its token
+// deleted and wiped tokens cannot be used for new authorizations but still resolve for the authorizations made with them
+type CardToken struct {
+	Token           string    `gorm:"primary_key;size:32" json:"token"`
+	MerchantID      uint32    `gorm:"not null;index" json:"merchantId"`
+	CardID          uint32    `gorm:"not null;index" json:"-"`
+	ExpirationMonth int       `gorm:"not null;default:0" json:"expirationMonth"`
+	ExpirationYear  int       `gorm:"not null;default:0" json:"expirationYear"`
+	Deleted         bool      `gorm:"not null;default:false" json:"-"`
+	Wiped           bool      `gorm:"not null;default:false" json:"-"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about a card that is safe to show to a merchant
+type CardSummary struct {
+	Token           string `json:"token"`
+	Number          string `json:"number"`
+	Brand           string `json:"brand"`
+	BIN             string `json:"bin"`
+	Last4           string `json:"last4"`
+	Currency        string `json:"currency"`
+	ExpirationMonth int    `json:"expirationMonth"`
+	ExpirationYear  int    `json:"expirationYear"`
+}
+
+//Tokenize returns the merchant's live token for the card, creating one when the merchant has none
+//a deleted token is never brought back, a new one is issued instead, and it takes the expiry the card was verified with
+func (c *Card) Tokenize(db *gorm.DB, merchantID uint32, card *Card) (*CardToken, error) {
+	var cardToken CardToken
+	err := db.Model(CardToken{}).Where("merchant_id = ? AND card_id = ? AND deleted = ?", merchantID, card.ID, false).Take(&cardToken).Error
+	if err == nil {
+		return &cardToken, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return &CardToken{}, err
+	}
+
+	cardToken = CardToken{
+		Token:           cardTokenPrefix + ksuid.New().String(),
+		MerchantID:      merchantID,
+		CardID:          card.ID,
+		ExpirationMonth: card.ExpirationMonth,
+		ExpirationYear:  card.ExpirationYear,
+	}
+	if err = db.Create(&cardToken).Error; err != nil {
+		return &CardToken{}, err
+	}
+	return &cardToken, nil
+}
+
+//withMerchantExpiry replaces the card's expiry with the one on the merchant's live token and tells whether the merchant has one
+func (c *Card) withMerchantExpiry(db *gorm.DB, merchantID uint32) (bool, error) {
+	var cardToken CardToken
+	err := db.Model(CardToken{}).Where("merchant_id = ? AND card_id = ? AND deleted = ?", merchantID, c.ID, false).Take(&cardToken).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c.ExpirationMonth, c.ExpirationYear = cardToken.ExpirationMonth, cardToken.ExpirationYear
+	return true, nil
+}
+
+//lastCardToken returns the merchant's most recent token for the card, deleted or not, or "" when it never had one
+func lastCardToken(db *gorm.DB, merchantID uint32, cardID uint32) (string, error) {
+	tokens := []string{}
+	err := db.Model(CardToken{}).Where("merchant_id = ? AND card_id = ?", merchantID, cardID).Order("created_at desc").Limit(1).Pluck("token", &tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return "", err
+	}
+	return tokens[0], nil
+}
+
+//adoptExpiry takes the expiry a merchant without a token for the card sent, the card may have been reissued since the vault stored it
+//the expiry is only checked for not being over, the merchant's new token keeps it
+func (c *Card) adoptExpiry(expirationMonth, expirationYear int) error {
+	expirationYear = NormalizeExpirationYear(expirationYear)
+	if err := validateExpiry(expirationMonth, expirationYear); err != nil {
+		return err
+	}
+	c.ExpirationMonth, c.ExpirationYear = expirationMonth, expirationYear
+	return nil
+}
+
+//Summary returns what a merchant may see of the card behind the token
+func (c *Card) Summary(token string) CardSummary {
+	return CardSummary{
+		Token:           token,
+		Number:          maskCardNumber(c.BIN, c.Last4),
+		Brand:           c.Brand,
+		BIN:             c.BIN,
+		Last4:           c.Last4,
+		Currency:        c.Currency,
+		ExpirationMonth: c.ExpirationMonth,
+		ExpirationYear:  c.ExpirationYear,
+	}
+}
+
+//cardSummaries returns the summaries of the cards behind the tokens keyed by token, with the expiry kept on each token
+func cardSummaries(db *gorm.DB, tokens []string) (map[string]CardSummary, error) {
+	summaries := map[string]CardSummary{}
+	if len(tokens) == 0 {
+		return summaries, nil
+	}
+
+	rows, err := db.Table("card_tokens").
+		Select("card_tokens.token, COALESCE(cards.brand, ''), cards.bin, cards.last4, cards.currency, card_tokens.expiration_month, card_tokens.expiration_year").
+		Joins("JOIN cards ON cards.id = card_tokens.card_id").
+		Where("card_tokens.token IN (?)", tokens).Rows()
+	if err != nil {
+		return summaries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary CardSummary
+		if err = rows.Scan(&summary.Token, &summary.Brand, &summary.BIN, &summary.Last4, &summary.Currency, &summary.ExpirationMonth, &summary.ExpirationYear); err != nil {
+			return summaries, err
+		}
+		summary.Number = maskCardNumber(summary.BIN, summary.Last4)
+		summaries[summary.Token] = summary
+	}
+	return summaries, rows.Err()
+}
+
+//maskCardNumber shows the BIN and the last 4 digits of a card number
+func maskCardNumber(bin, last4 string) string {
+	return bin + "******" + last4
+}
+
+//bankCardID returns the ID the bank knows the card behind the token by
+//bank accounts are linked to the card's fingerprint so the bank does not need the PAN either
+func bankCardID(db *gorm.DB, token string) (string, error) {
+	var card Card
+	err := db.Model(Card{}).Select("cards.fingerprint").Joins("JOIN card_tokens ON card_tokens.card_id = cards.id").
+		Where("card_tokens.token = ?", token).Take(&card).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return "", apierrors.New(constants.CardTokenNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return card.Fingerprint, nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/metrics"
+)
+
+const (
+	FXSourceNone   = "none"
+	FXSourceStatic = "static"
+	FXSourceDB     = "db"
+	FXSourceHTTP   = "http"
+	FXSourceStale  = "http-stale"
+)
+
+// generic information about an exchange rate, 1 unit of BaseCurrency = Rate units of QuoteCurrency
+// also used as the rate table of the DB provider
+type FXRate struct {
+	ID            uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	BaseCurrency  string    `gorm:"size:4;not null;index:idx_fx_rates_pair" json:"baseCurrency"`
+	QuoteCurrency string    `gorm:"size:4;not null;index:idx_fx_rates_pair" json:"quoteCurrency"`
+	Rate          float64   `gorm:"not null" json:"rate"`
+	Source        string    `gorm:"size:16;not null" json:"source"`
+	EffectiveFrom time.Time `gorm:"not null;index" json:"effectiveFrom"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+//Interface implemented by every exchange rate source
+type FXRateProvider interface {
+	Rate(baseCurrency, quoteCurrency string) (*FXRate, error)
+}
+
+var (
+	fxRateProviderMu sync.RWMutex
+	fxRateProvider   FXRateProvider = NewStaticFXRateProvider(DefaultFXRates)
+)
+
+//SetFXRateProvider replaces the provider used when authorizing in a currency different from the card's
+func SetFXRateProvider(provider FXRateProvider) {
+	fxRateProviderMu.Lock()
+	defer fxRateProviderMu.Unlock()
+	fxRateProvider = provider
+}
+
+//lookupFXRate returns the rate to lock on a new authorization
+func lookupFXRate(baseCurrency, quoteCurrency string) (*FXRate, error) {
+	if strings.EqualFold(baseCurrency, quoteCurrency) {
+		return &FXRate{BaseCurrency: baseCurrency, QuoteCurrency: quoteCurrency, Rate: 1, Source: FXSourceNone, EffectiveFrom: time.Now()}, nil
+	}
+
+	fxRateProviderMu.RLock()
+	provider := fxRateProvider
+	fxRateProviderMu.RUnlock()
+
+	name := fxProviderName(provider)
+	start := time.Now()
+	rate, err := provider.Rate(strings.ToUpper(baseCurrency), strings.ToUpper(quoteCurrency))
+	metrics.FXLookupDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err == nil && rate.Rate <= 0 {
+		err = apierrors.New(constants.ExchangeRateNotFound)
+	}
+	if err != nil {
+		metrics.FXLookupFailures.WithLabelValues(name, apierrors.From(err, http.StatusInternalServerError).Code).Inc()
+		return &FXRate{}, err
+	}
+	return rate, nil
+}
+
+//fxProviderName returns the name the provider is selected with in FX_PROVIDER, "custom" for providers set in code
+func fxProviderName(provider FXRateProvider) string {
+	switch provider.(type) {
+	case *StaticFXRateProvider:
+		return FXSourceStatic
+	case *DBFXRateProvider:
+		return FXSourceDB
+	case *HTTPFXRateProvider:
+		return FXSourceHTTP
+	default:
+		return "custom"
+	}
+}
+
+//NewFXRateProviderFromEnv builds the provider selected by FX_PROVIDER (static, db or http), defaulting to static
+func NewFXRateProviderFromEnv(db *gorm.DB) (FXRateProvider, error) {
+	switch strings.ToLower(os.Getenv("FX_PROVIDER")) {
+	case "", FXSourceStatic:
+		path := os.Getenv("FX_RATES_FILE")
+		if path == "" {
+			return NewStaticFXRateProvider(DefaultFXRates), nil
+		}
+		return LoadStaticFXRateProvider(path)
+	case FXSourceDB:
+		return NewDBFXRateProvider(db), nil
+	case FXSourceHTTP:
+		return NewHTTPFXRateProviderFromEnv(), nil
+	default:
+		return nil, apierrors.WithDetail(constants.UnknownFXProvider, os.Getenv("FX_PROVIDER"))
+	}
+}
+
+// provider reading rates from the fx_rates table, the latest rate already in effect wins
+type DBFXRateProvider struct {
+	db *gorm.DB
+}
+
+func NewDBFXRateProvider(db *gorm.DB) *DBFXRateProvider {
+	return &DBFXRateProvider{db: db}
+}
+
+//Rate returns the latest effective rate for the pair, falling back to the inverse of the opposite pair
+func (p *DBFXRateProvider) Rate(baseCurrency, quoteCurrency string) (*FXRate, error) {
+	now := time.Now()
+
+	rate, err := p.latestRate(baseCurrency, quoteCurrency, now)
+	if err == nil {
+		return rate, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return &FXRate{}, err
+	}
+
+	inverse, err := p.latestRate(quoteCurrency, baseCurrency, now)
+	if gorm.IsRecordNotFoundError(err) {
+		return &FXRate{}, apierrors.New(constants.ExchangeRateNotFound)
+	}
+	if err != nil {
+		return &FXRate{}, err
+	}
+
+	return &FXRate{
+		BaseCurrency:  baseCurrency,
+		QuoteCurrency: quoteCurrency,
+		Rate:          1 / inverse.Rate,
+		Source:        FXSourceDB,
+		EffectiveFrom: inverse.EffectiveFrom,
+	}, nil
+}
+
+func (p *DBFXRateProvider) latestRate(baseCurrency, quoteCurrency string, at time.Time) (*FXRate, error) {
+	var rate FXRate
+	err := p.db.Model(FXRate{}).
+		Where("base_currency = ? AND quote_currency = ? AND effective_from <= ? AND rate > 0", baseCurrency, quoteCurrency, at).
+		Order("effective_from desc").Take(&rate).Error
+	if err != nil {
+		return &FXRate{}, err
+	}
+	rate.Source = FXSourceDB
+	return &rate, nil
+}
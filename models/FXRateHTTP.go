@@ -0,0 +1,142 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	defaultFXHTTPURL      = "https://free.currconv.com/api/v7/convert?q={pair}&compact=ultra&apiKey={apiKey}"
+	defaultFXHTTPTimeout  = 2 * time.Second
+	defaultFXHTTPCacheTTL = 10 * time.Minute
+	defaultFXHTTPMaxStale = 24 * time.Hour
+)
+
+// provider fetching rates from a currconv compatible HTTP API
+// fresh rates are cached for CacheTTL and a cached rate up to MaxStale old is served when the API is unavailable
+type HTTPFXRateProvider struct {
+	URL      string
+	APIKey   string
+	CacheTTL time.Duration
+	MaxStale time.Duration
+	Client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]FXRate
+}
+
+func NewHTTPFXRateProvider(rawURL, apiKey string, timeout, cacheTTL, maxStale time.Duration) *HTTPFXRateProvider {
+	return &HTTPFXRateProvider{
+		URL:      rawURL,
+		APIKey:   apiKey,
+		CacheTTL: cacheTTL,
+		MaxStale: maxStale,
+		Client:   &http.Client{Timeout: timeout},
+		cache:    map[string]FXRate{},
+	}
+}
+
+//NewHTTPFXRateProviderFromEnv configures the provider from FX_HTTP_URL, FX_HTTP_API_KEY, FX_HTTP_TIMEOUT, FX_HTTP_CACHE_TTL and FX_HTTP_MAX_STALE
+func NewHTTPFXRateProviderFromEnv() *HTTPFXRateProvider {
+	rawURL := os.Getenv("FX_HTTP_URL")
+	if rawURL == "" {
+		rawURL = defaultFXHTTPURL
+	}
+	return NewHTTPFXRateProvider(
+		rawURL,
+		os.Getenv("FX_HTTP_API_KEY"),
+		envDuration("FX_HTTP_TIMEOUT", defaultFXHTTPTimeout),
+		envDuration("FX_HTTP_CACHE_TTL", defaultFXHTTPCacheTTL),
+		envDuration("FX_HTTP_MAX_STALE", defaultFXHTTPMaxStale),
+	)
+}
+
+//Rate returns a cached rate if it is still fresh, otherwise fetches a new one falling back to a stale cached rate
+func (p *HTTPFXRateProvider) Rate(baseCurrency, quoteCurrency string) (*FXRate, error) {
+	pair := baseCurrency + "_" + quoteCurrency
+
+	p.mu.Lock()
+	cached, ok := p.cache[pair]
+	p.mu.Unlock()
+
+	if ok && time.Since(cached.EffectiveFrom) < p.CacheTTL {
+		return &cached, nil
+	}
+
+	rate, err := p.fetch(pair)
+	if err != nil {
+		if ok && time.Since(cached.EffectiveFrom) < p.MaxStale {
+			cached.Source = FXSourceStale
+			return &cached, nil
+		}
+		return &FXRate{}, err
+	}
+
+	fresh := FXRate{
+		BaseCurrency:  baseCurrency,
+		QuoteCurrency: quoteCurrency,
+		Rate:          rate,
+		Source:        FXSourceHTTP,
+		EffectiveFrom: time.Now(),
+	}
+
+	p.mu.Lock()
+	p.cache[pair] = fresh
+	p.mu.Unlock()
+
+	return &fresh, nil
+}
+
+//fetch calls the API for a single currency pair such as "USD_EUR"
+func (p *HTTPFXRateProvider) fetch(pair string) (float64, error) {
+	rawURL := strings.NewReplacer("{pair}", url.QueryEscape(pair), "{apiKey}", url.QueryEscape(p.APIKey)).Replace(p.URL)
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, apierrors.New(constants.ExchangeRateUnavailable)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	convert := make(map[string]float64)
+	if err = json.Unmarshal(body, &convert); err != nil {
+		return 0, err
+	}
+
+	value, ok := convert[pair]
+	if !ok || value <= 0 {
+		return 0, apierrors.New(constants.ExchangeRateNotFound)
+	}
+	return value, nil
+}
+
+//envDuration reads a Go duration such as "10m" from the environment
+func envDuration(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
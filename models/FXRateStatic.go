@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+//DefaultFXRates is used when no rates file is configured so the gateway works without network access
+var DefaultFXRates = StaticFXRates{
+	Base: "USD",
+	Rates: map[string]float64{
+		"USD": 1,
+		"EUR": 0.92,
+		"GBP": 0.79,
+		"BGN": 1.80,
+		"CAD": 1.36,
+		"CHF": 0.88,
+		"JPY": 150,
+		"KWD": 0.307,
+	},
+}
+
+// format of the static rates file, every rate is expressed against Base
+// e.g. {"base": "USD", "rates": {"EUR": 0.92, "GBP": 0.79}}
+type StaticFXRates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// provider serving rates from a fixed table, cross rates are derived through the base currency
+type StaticFXRateProvider struct {
+	rates    StaticFXRates
+	loadedAt time.Time
+}
+
+func NewStaticFXRateProvider(rates StaticFXRates) *StaticFXRateProvider {
+	normalized := StaticFXRates{Base: strings.ToUpper(rates.Base), Rates: map[string]float64{}}
+	for currency, rate := range rates.Rates {
+		normalized.Rates[strings.ToUpper(currency)] = rate
+	}
+	normalized.Rates[normalized.Base] = 1
+	return &StaticFXRateProvider{rates: normalized, loadedAt: time.Now()}
+}
+
+//LoadStaticFXRateProvider reads the rates table from a JSON file
+func LoadStaticFXRateProvider(path string) (*StaticFXRateProvider, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rates StaticFXRates
+	if err = json.Unmarshal(body, &rates); err != nil {
+		return nil, err
+	}
+	if rates.Base == "" {
+		return nil, apierrors.New(constants.InvalidFXRatesFile)
+	}
+	return NewStaticFXRateProvider(rates), nil
+}
+
+//Rate returns the cross rate between the two currencies
+func (p *StaticFXRateProvider) Rate(baseCurrency, quoteCurrency string) (*FXRate, error) {
+	base, ok := p.rates.Rates[baseCurrency]
+	if !ok || base <= 0 {
+		return &FXRate{}, apierrors.New(constants.ExchangeRateNotFound)
+	}
+	quote, ok := p.rates.Rates[quoteCurrency]
+	if !ok || quote <= 0 {
+		return &FXRate{}, apierrors.New(constants.ExchangeRateNotFound)
+	}
+
+	return &FXRate{
+		BaseCurrency:  baseCurrency,
+		QuoteCurrency: quoteCurrency,
+		Rate:          quote / base,
+		Source:        FXSourceStatic,
+		EffectiveFrom: p.loadedAt,
+	}, nil
+}
+
+//Pairs lists every rate against the base currency, used to seed the DB rate table
+func (p *StaticFXRateProvider) Pairs() []FXRate {
+	pairs := []FXRate{}
+	for currency, rate := range p.rates.Rates {
+		if currency == p.rates.Base {
+			continue
+		}
+		pairs = append(pairs, FXRate{
+			BaseCurrency:  p.rates.Base,
+			QuoteCurrency: currency,
+			Rate:          rate,
+			Source:        FXSourceStatic,
+			EffectiveFrom: p.loadedAt,
+		})
+	}
+	return pairs
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about the funds held on a bank account for one authorization
+// Amount is what is still held, it goes down with every capture and is released on void
+type Hold struct {
+	ID              uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	BankAccountID   uint32    `gorm:"not null;index" json:"bankAccountId"`
+	AuthorizationID string    `gorm:"size:27;not null;unique" json:"authorizationId"`
+	Amount          Money     `gorm:"not null" json:"amount"`
+	Status          string    `gorm:"size:16;not null;index" json:"status"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+//activeHoldsTotal sums what is still held on the bank account
+func activeHoldsTotal(db *gorm.DB, bankAccountID uint32) (Money, error) {
+	var total Money
+	row := db.Model(Hold{}).Select("COALESCE(SUM(amount), 0)").
+		Where("bank_account_id = ? AND status = ?", bankAccountID, constants.HoldStatus(constants.HoldActive).String()).Row()
+	if err := row.Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
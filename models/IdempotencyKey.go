@@ -0,0 +1,133 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about a request made with an Idempotency-Key header
+// StatusCode stays 0 while the original request is still being processed
+// ReservedUntil ends the reservation of a request that never finished, e.g. because the process died, so a retry can take it over
+type IdempotencyKey struct {
+	ID            uint32     `gorm:"primary_key;auto_increment" json:"id"`
+	MerchantID    uint32     `gorm:"not null;unique_index:idx_idempotency_merchant_key" json:"merchantId"`
+	Key           string     `gorm:"size:255;not null;unique_index:idx_idempotency_merchant_key" json:"key"`
+	RequestHash   string     `gorm:"size:64;not null" json:"requestHash"`
+	StatusCode    int        `gorm:"not null" json:"statusCode"`
+	ResponseBody  string     `gorm:"type:text" json:"responseBody"`
+	ReservedUntil *time.Time `json:"reservedUntil"`
+	ExpiresAt     time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+//Interface to call Idempotency Key functions
+type IdempotencyKeyI interface {
+	ReserveIdempotencyKey(db *gorm.DB, merchantID uint32, key, requestHash string, ttl, lease time.Duration) (*IdempotencyKey, bool, error)
+	SaveIdempotentResponse(db *gorm.DB, id uint32, statusCode int, body string) error
+	ReleaseIdempotencyKey(db *gorm.DB, id uint32) error
+	PurgeExpiredIdempotencyKeys(db *gorm.DB, now time.Time) (int64, error)
+}
+
+func NewIdempotencyKeyI() IdempotencyKeyI {
+	return &IdempotencyKey{}
+}
+
+//ReserveIdempotencyKey returns the stored key for the merchant or reserves a new one for lease
+//the returned bool is true only when a new reservation was made and the request should be processed
+//a reservation whose lease ran out without a response is taken over by a retry with the same request
+func (k *IdempotencyKey) ReserveIdempotencyKey(db *gorm.DB, merchantID uint32, key, requestHash string, ttl, lease time.Duration) (ik *IdempotencyKey, created bool, err error) {
+	var existing IdempotencyKey
+	err = db.Model(IdempotencyKey{}).Where("merchant_id = ? AND key = ?", merchantID, key).Take(&existing).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return &IdempotencyKey{}, false, err
+	}
+
+	now := time.Now()
+	if err == nil {
+		if existing.ExpiresAt.After(now) {
+			if existing.StatusCode != 0 || existing.RequestHash != requestHash || existing.isReserved(now) {
+				return &existing, false, nil
+			}
+			return k.takeOverIdempotencyKey(db, &existing, now, lease)
+		}
+		//the key has expired so it can be reused for a new request
+		if err = db.Delete(&existing).Error; err != nil {
+			return &IdempotencyKey{}, false, err
+		}
+	}
+
+	reservedUntil := now.Add(lease)
+	idempotencyKey := IdempotencyKey{
+		MerchantID:    merchantID,
+		Key:           key,
+		RequestHash:   requestHash,
+		ReservedUntil: &reservedUntil,
+		ExpiresAt:     now.Add(ttl),
+	}
+
+	if err = db.Create(&idempotencyKey).Error; err != nil {
+		//another request reserved the same key in the meantime
+		err = db.Model(IdempotencyKey{}).Where("merchant_id = ? AND key = ?", merchantID, key).Take(&existing).Error
+		if err != nil {
+			return &IdempotencyKey{}, false, err
+		}
+		return &existing, false, nil
+	}
+
+	return &idempotencyKey, true, nil
+}
+
+//isReserved tells whether the request that reserved the key may still be processing it
+func (k *IdempotencyKey) isReserved(now time.Time) bool {
+	return k.StatusCode == 0 && k.ReservedUntil != nil && now.Before(*k.ReservedUntil)
+}
+
+//takeOverIdempotencyKey reserves a key whose reservation ran out, only one of several retries gets it
+func (k *IdempotencyKey) takeOverIdempotencyKey(db *gorm.DB, existing *IdempotencyKey, now time.Time, lease time.Duration) (*IdempotencyKey, bool, error) {
+	reservedUntil := now.Add(lease)
+	taken := db.Model(&IdempotencyKey{}).
+		Where("id = ? AND status_code = ? AND (reserved_until IS NULL OR reserved_until <= ?)", existing.ID, 0, now).
+		UpdateColumns(map[string]interface{}{"reserved_until": reservedUntil, "updated_at": now})
+	if taken.Error != nil {
+		return &IdempotencyKey{}, false, taken.Error
+	}
+	if taken.RowsAffected == 0 {
+		return existing, false, nil
+	}
+	existing.ReservedUntil = &reservedUntil
+	return existing, true, nil
+}
+
+//SaveIdempotentResponse stores the response of the original request so it can be replayed
+func (k *IdempotencyKey) SaveIdempotentResponse(db *gorm.DB, id uint32, statusCode int, body string) (err error) {
+	db = db.Model(&IdempotencyKey{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"status_code":    statusCode,
+			"response_body":  body,
+			"reserved_until": nil,
+			"updated_at":     time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return apierrors.New(constants.IdempotencyKeyNotFound)
+	}
+	return nil
+}
+
+//ReleaseIdempotencyKey removes a reservation so the request can be retried with the same key
+func (k *IdempotencyKey) ReleaseIdempotencyKey(db *gorm.DB, id uint32) (err error) {
+	return db.Where("id = ?", id).Delete(&IdempotencyKey{}).Error
+}
+
+//PurgeExpiredIdempotencyKeys deletes the keys that expired before now and returns how many were deleted
+func (k *IdempotencyKey) PurgeExpiredIdempotencyKeys(db *gorm.DB, now time.Time) (int64, error) {
+	purged := db.Where("expires_at <= ?", now).Delete(&IdempotencyKey{})
+	return purged.RowsAffected, purged.Error
+}
@@ -0,0 +1,9 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+//forUpdate makes the next query lock the selected rows (SELECT ... FOR UPDATE)
+//until the surrounding transaction commits or rolls back
+func forUpdate(db *gorm.DB) *gorm.DB {
+	return db.Set("gorm:query_option", "FOR UPDATE")
+}
@@ -0,0 +1,355 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const apiKeyPrefix = "sk_"
+
+// generic information about a merchant using the gateway
+// only the SHA-256 hash of the API key is stored, the key itself is shown once when it is created or rotated
+// AuthorizationTTL is how long the merchant's authorizations stay open in seconds, 0 uses the gateway default
+// WebhookSecret signs the events sent to all of the merchant's webhook endpoints
+// AcceptedCardBrands is a comma separated list of the brands the merchant accepts, empty accepts every brand
+// Country is where the merchant is located, fraud screening compares it with the country of the card
+type Merchant struct {
+	ID                 uint32    `gorm:"primary_key;auto_increment" json:"mid"`
+	Name               string    `gorm:"size:100;not null" json:"name"`
+	APIKeyHash         string    `gorm:"size:64;not null" json:"-"`
+	APIKeyHint         string    `gorm:"size:16;not null" json:"apiKeyHint"`
+	Disabled           bool      `gorm:"not null;default:false" json:"disabled"`
+	KeyRotatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"keyRotatedAt"`
+	AuthorizationTTL   int64     `gorm:"not null;default:0" json:"authorizationTtl"`
+	WebhookSecret      string    `gorm:"size:80" json:"-"`
+	AcceptedCardBrands string    `gorm:"size:255" json:"acceptedCardBrands"`
+	Country            string    `gorm:"size:2" json:"country"`
+	CreatedAt          time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// generic information about the login request
+type LoginRequest struct {
+	ID     uint32 `json:"mid"`
+	APIKey string `json:"apiKey"`
+}
+
+// generic information about the merchant creation request
+// APIKey can only be set when seeding, merchants created through the API always get a generated key
+type MerchantRequest struct {
+	ID                 uint32   `json:"mid"`
+	Name               string   `json:"name"`
+	APIKey             string   `json:"-"`
+	AuthorizationTTL   int64    `json:"authorizationTtl"`
+	AcceptedCardBrands []string `json:"acceptedCardBrands"`
+	Country            string   `json:"country"`
+}
+
+// generic information about the request for changing the card brands a merchant accepts
+type CardBrandsRequest struct {
+	AcceptedCardBrands []string `json:"acceptedCardBrands"`
+}
+
+// generic information about the request for changing how long a merchant's authorizations stay open
+type AuthorizationTTLRequest struct {
+	AuthorizationTTL int64 `json:"authorizationTtl"`
+}
+
+// generic information about the request for changing the country of a merchant
+type CountryRequest struct {
+	Country string `json:"country"`
+}
+
+// generic information about a merchant together with a newly issued API key
+type MerchantCredentialsResponse struct {
+	Merchant *Merchant `json:"merchant"`
+	APIKey   string    `json:"apiKey"`
+}
+
+//Interface to call Merchant functions
+type MerchantI interface {
+	CreateMerchant(db *gorm.DB, merchantRequest MerchantRequest) (*Merchant, string, error)
+	FindMerchantByID(db *gorm.DB, id uint32) (*Merchant, error)
+	VerifyCredentials(db *gorm.DB, id uint32, apiKey string) (*Merchant, error)
+	VerifyToken(db *gorm.DB, id uint32, issuedAt time.Time) (*Merchant, error)
+	RotateAPIKey(db *gorm.DB, id uint32) (*Merchant, string, error)
+	SetDisabled(db *gorm.DB, id uint32, disabled bool) (*Merchant, error)
+	SetAuthorizationTTL(db *gorm.DB, id uint32, ttlSeconds int64) (*Merchant, error)
+	SetAcceptedCardBrands(db *gorm.DB, id uint32, brands []string) (*Merchant, error)
+	SetCountry(db *gorm.DB, id uint32, country string) (*Merchant, error)
+}
+
+func NewMerchantI() MerchantI {
+	return &Merchant{}
+}
+
+//CreateMerchant stores a new merchant and returns its API key
+//the ID is optional and is generated by the DB when it is 0
+func (m *Merchant) CreateMerchant(db *gorm.DB, merchantRequest MerchantRequest) (merchant *Merchant, apiKey string, err error) {
+	if merchantRequest.Name == "" {
+		return &Merchant{}, "", apierrors.New(constants.InvalidMerchantName)
+	}
+	if merchantRequest.AuthorizationTTL < 0 {
+		return &Merchant{}, "", apierrors.New(constants.InvalidAuthorizationTTL)
+	}
+	acceptedCardBrands, err := joinCardBrands(merchantRequest.AcceptedCardBrands)
+	if err != nil {
+		return &Merchant{}, "", err
+	}
+	country, err := normalizeCountry(merchantRequest.Country)
+	if err != nil {
+		return &Merchant{}, "", err
+	}
+
+	apiKey = merchantRequest.APIKey
+	if apiKey == "" {
+		apiKey, err = generateAPIKey()
+		if err != nil {
+			return &Merchant{}, "", err
+		}
+	}
+
+	newMerchant := Merchant{
+		ID:                 merchantRequest.ID,
+		Name:               merchantRequest.Name,
+		APIKeyHash:         hashAPIKey(apiKey),
+		APIKeyHint:         apiKeyHint(apiKey),
+		KeyRotatedAt:       time.Now(),
+		AuthorizationTTL:   merchantRequest.AuthorizationTTL,
+		AcceptedCardBrands: acceptedCardBrands,
+		Country:            country,
+	}
+
+	if err = db.Create(&newMerchant).Error; err != nil {
+		return &Merchant{}, "", err
+	}
+	return &newMerchant, apiKey, nil
+}
+
+//FindMerchantByID retrieves a merchant by ID from the DB
+func (m *Merchant) FindMerchantByID(db *gorm.DB, id uint32) (merchant *Merchant, err error) {
+	var found Merchant
+	err = db.Model(Merchant{}).Where("id = ?", id).Take(&found).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Merchant{}, apierrors.New(constants.MerchantNotFound)
+	}
+	if err != nil {
+		return &Merchant{}, err
+	}
+	return &found, nil
+}
+
+//VerifyCredentials checks the API key of an enabled merchant
+func (m *Merchant) VerifyCredentials(db *gorm.DB, id uint32, apiKey string) (merchant *Merchant, err error) {
+	merchant, err = m.FindMerchantByID(db, id)
+	if err != nil {
+		//do not reveal which merchant IDs exist
+		return &Merchant{}, apierrors.New(constants.InvalidMerchantCredentials)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(merchant.APIKeyHash), []byte(hashAPIKey(apiKey))) != 1 {
+		return &Merchant{}, apierrors.New(constants.InvalidMerchantCredentials)
+	}
+
+	if merchant.Disabled {
+		return &Merchant{}, apierrors.New(constants.MerchantDisabled)
+	}
+
+	return merchant, nil
+}
+
+//VerifyToken checks that the merchant a token was issued to is enabled and has not rotated its key since
+func (m *Merchant) VerifyToken(db *gorm.DB, id uint32, issuedAt time.Time) (merchant *Merchant, err error) {
+	merchant, err = m.FindMerchantByID(db, id)
+	if err != nil {
+		return &Merchant{}, apierrors.New(constants.Unauthorized)
+	}
+
+	if merchant.Disabled {
+		return &Merchant{}, apierrors.New(constants.MerchantDisabled)
+	}
+
+	//tokens issued before the last key rotation are revoked
+	if issuedAt.Before(merchant.KeyRotatedAt.Truncate(time.Second)) {
+		return &Merchant{}, apierrors.New(constants.Unauthorized)
+	}
+
+	return merchant, nil
+}
+
+//RotateAPIKey replaces the merchant's API key, the previous key stops working immediately
+func (m *Merchant) RotateAPIKey(db *gorm.DB, id uint32) (merchant *Merchant, apiKey string, err error) {
+	apiKey, err = generateAPIKey()
+	if err != nil {
+		return &Merchant{}, "", err
+	}
+
+	db = db.Model(&Merchant{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"api_key_hash":   hashAPIKey(apiKey),
+			"api_key_hint":   apiKeyHint(apiKey),
+			"key_rotated_at": time.Now(),
+			"updated_at":     time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &Merchant{}, "", db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &Merchant{}, "", apierrors.New(constants.MerchantNotFound)
+	}
+
+	merchant, err = m.FindMerchantByID(db, id)
+	if err != nil {
+		return &Merchant{}, "", err
+	}
+	return merchant, apiKey, nil
+}
+
+//SetDisabled disables or re-enables a merchant, disabled merchants cannot log in or use existing tokens
+func (m *Merchant) SetDisabled(db *gorm.DB, id uint32, disabled bool) (merchant *Merchant, err error) {
+	db = db.Model(&Merchant{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"disabled":   disabled,
+			"updated_at": time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &Merchant{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &Merchant{}, apierrors.New(constants.MerchantNotFound)
+	}
+	return m.FindMerchantByID(db, id)
+}
+
+//SetAuthorizationTTL changes how long new authorizations of the merchant stay open, 0 goes back to the gateway default
+//authorizations that are already open keep their expiry
+func (m *Merchant) SetAuthorizationTTL(db *gorm.DB, id uint32, ttlSeconds int64) (merchant *Merchant, err error) {
+	if ttlSeconds < 0 {
+		return &Merchant{}, apierrors.New(constants.InvalidAuthorizationTTL)
+	}
+
+	db = db.Model(&Merchant{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"authorization_ttl": ttlSeconds,
+			"updated_at":        time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &Merchant{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &Merchant{}, apierrors.New(constants.MerchantNotFound)
+	}
+	return m.FindMerchantByID(db, id)
+}
+
+//SetAcceptedCardBrands restricts the card brands the merchant accepts, an empty list accepts every brand
+func (m *Merchant) SetAcceptedCardBrands(db *gorm.DB, id uint32, brands []string) (merchant *Merchant, err error) {
+	acceptedCardBrands, err := joinCardBrands(brands)
+	if err != nil {
+		return &Merchant{}, err
+	}
+
+	db = db.Model(&Merchant{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"accepted_card_brands": acceptedCardBrands,
+			"updated_at":           time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &Merchant{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &Merchant{}, apierrors.New(constants.MerchantNotFound)
+	}
+	return m.FindMerchantByID(db, id)
+}
+
+//SetCountry changes the country of the merchant, an empty country turns off the country checks of fraud screening
+func (m *Merchant) SetCountry(db *gorm.DB, id uint32, country string) (merchant *Merchant, err error) {
+	country, err = normalizeCountry(country)
+	if err != nil {
+		return &Merchant{}, err
+	}
+
+	db = db.Model(&Merchant{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"country":    country,
+			"updated_at": time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &Merchant{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &Merchant{}, apierrors.New(constants.MerchantNotFound)
+	}
+	return m.FindMerchantByID(db, id)
+}
+
+//AcceptsCardBrand tells whether the merchant accepts cards of the brand
+func (m *Merchant) AcceptsCardBrand(brand string) bool {
+	if m.AcceptedCardBrands == "" {
+		return true
+	}
+	for _, accepted := range strings.Split(m.AcceptedCardBrands, ",") {
+		if accepted == brand {
+			return true
+		}
+	}
+	return false
+}
+
+//joinCardBrands checks the brand names and joins them in the format they are stored in
+func joinCardBrands(brands []string) (string, error) {
+	names := []string{}
+	for _, name := range brands {
+		brand, err := parseCardBrand(strings.TrimSpace(name))
+		if err != nil {
+			return "", err
+		}
+		names = append(names, brand.String())
+	}
+	return strings.Join(names, ","), nil
+}
+
+//AuthorizationValidity returns how long the merchant's authorizations stay open
+func (m *Merchant) AuthorizationValidity() time.Duration {
+	if m.AuthorizationTTL > 0 {
+		return time.Duration(m.AuthorizationTTL) * time.Second
+	}
+	return DefaultAuthorizationTTL()
+}
+
+//generateAPIKey returns a new random API key
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(b), nil
+}
+
+//hashAPIKey returns the hex encoded SHA-256 of the key, keys are random so a slow hash is not needed
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+//apiKeyHint keeps the last characters of the key so merchants can tell keys apart
+func apiKeyHint(apiKey string) string {
+	if len(apiKey) < 8 {
+		return "..."
+	}
+	return "..." + apiKey[len(apiKey)-4:]
+}
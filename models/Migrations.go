@@ -0,0 +1,314 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+// a money column together with the column holding the currency of its amounts
+type moneyColumn struct {
+	Table          string
+	Column         string
+	CurrencyColumn string
+}
+
+// a foreign key that AutoMigrate does not create on its own
+type foreignKey struct {
+	Model    interface{}
+	Field    string
+	Dest     string
+	OnDelete string
+	OnUpdate string
+}
+
+var foreignKeys = []foreignKey{
+	{Model: &Authorization{}, Field: "merchant_id", Dest: "merchants(id)", OnDelete: "RESTRICT", OnUpdate: "RESTRICT"},
+}
+
+var moneyColumns = []moneyColumn{
+	{Table: "authorizations", Column: "balance_captured", CurrencyColumn: "currency_card"},
+	{Table: "authorizations", Column: "balance_authorised", CurrencyColumn: "currency_card"},
+	{Table: "authorizations", Column: "balance_refunded", CurrencyColumn: "currency_card"},
+	{Table: "bank_accounts", Column: "balance", CurrencyColumn: "currency"},
+	{Table: "bank_accounts", Column: "balance_authorised", CurrencyColumn: "currency"},
+}
+
+//MigrateMoneyColumns converts money columns still stored as floating point major units into integer minor units
+//it is safe to run on every start as columns that are already migrated are skipped
+func MigrateMoneyColumns(db *gorm.DB) error {
+	if db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+
+	for _, mc := range moneyColumns {
+		var dataType string
+		err := db.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = ? AND column_name = ?", mc.Table, mc.Column).Row().Scan(&dataType)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if dataType != "double precision" && dataType != "real" && dataType != "numeric" {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING round(%s * power(10, %s))::bigint",
+			mc.Table, mc.Column, mc.Column, currencyExponentCase(mc.CurrencyColumn))
+		if err = db.Exec(query).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//currencyExponentCase builds a SQL CASE expression returning the exponent of the currency stored in the column
+func currencyExponentCase(column string) string {
+	currencies := make([]string, 0, len(currencyExponents))
+	for currency := range currencyExponents {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var b strings.Builder
+	b.WriteString("CASE upper(" + column + ")")
+	for _, currency := range currencies {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", currency, currencyExponents[currency])
+	}
+	b.WriteString(" ELSE 2 END")
+	return b.String()
+}
+
+//MigrateForeignKeys adds the foreign keys that do not exist yet, gorm skips the ones already in place
+func MigrateForeignKeys(db *gorm.DB) error {
+	for _, fk := range foreignKeys {
+		if err := db.Model(fk.Model).AddForeignKey(fk.Field, fk.Dest, fk.OnDelete, fk.OnUpdate).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// an idempotent statement for a change AutoMigrate cannot make to an existing table
+// Args returns the values of the statement's placeholders when it is run, e.g. settings read from the environment
+type schemaChange struct {
+	Table     string
+	Statement string
+	Args      func() []interface{}
+}
+
+var schemaChanges = []schemaChange{
+	//a card can have several authorizations and several authorizations can share a status
+	{Table: "authorizations", Statement: "ALTER TABLE authorizations DROP CONSTRAINT IF EXISTS authorizations_status_key"},
+	//PartiallyCaptured and PartiallyRefunded do not fit the original column
+	{Table: "authorizations", Statement: "ALTER TABLE authorizations ALTER COLUMN status TYPE varchar(20)"},
+	//open authorizations created before holds existed keep what is left of their amount on hold
+	{Table: "holds", Statement: `INSERT INTO holds (bank_account_id, authorization_id, amount, status, created_at, updated_at)
+		SELECT ba.id, a.id, a.balance_authorised - a.balance_captured, 'active', now(), now()
+		FROM authorizations a
+		JOIN card_tokens ct ON ct.token = a.card_token
+		JOIN cards c ON c.id = ct.card_id
+		JOIN bank_accounts ba ON ba.card_id = c.fingerprint
+		WHERE a.status = 'Authorized' AND NOT EXISTS (SELECT 1 FROM holds h WHERE h.authorization_id = a.id)`},
+	//authorizations created before expiry existed get the validity window of their merchant, AUTHORIZATION_TTL when it has none
+	{Table: "authorizations", Statement: `UPDATE authorizations SET expires_at = created_at + COALESCE(
+			(SELECT NULLIF(m.authorization_ttl, 0) FROM merchants m WHERE m.id = authorizations.merchant_id), ?) * interval '1 second'
+		WHERE expires_at IS NULL`, Args: defaultAuthorizationTTLSeconds},
+	//cards stored with 2 digit expiration years
+	{Table: "cards", Statement: "UPDATE cards SET expiration_year = expiration_year + 2000 WHERE expiration_year < 100"},
+	//authorizations created before brands were detected take the brand of their card
+	{Table: "authorizations", Statement: `UPDATE authorizations a SET card_brand = c.brand
+		FROM card_tokens ct JOIN cards c ON c.id = ct.card_id
+		WHERE ct.token = a.card_token AND (a.card_brand IS NULL OR a.card_brand = '')`},
+	//authorizations created before the requested amount was stored, conversions cannot be reversed exactly so only those without one are filled in
+	{Table: "authorizations", Statement: "UPDATE authorizations SET amount_requested = balance_authorised WHERE amount_requested = 0 AND currency_requested = currency_card"},
+	//authorizations created before processors existed were handled by the bank accounts of the simulator
+	{Table: "authorizations", Statement: "UPDATE authorizations SET processor = 'simulator' WHERE processor IS NULL OR processor = ''"},
+	//merchants keep their own expiry on their tokens, tokens created before start with the card's
+	{Table: "card_tokens", Statement: `UPDATE card_tokens ct SET expiration_month = c.expiration_month, expiration_year = c.expiration_year
+		FROM cards c WHERE c.id = ct.card_id AND ct.expiration_month = 0`},
+	//deleted tokens are kept and never brought back, so only one live token per merchant and card is unique
+	{Table: "card_tokens", Statement: "DROP INDEX IF EXISTS idx_card_tokens_merchant_card"},
+	{Table: "card_tokens", Statement: "CREATE UNIQUE INDEX IF NOT EXISTS idx_card_tokens_live ON card_tokens (merchant_id, card_id) WHERE NOT deleted"},
+	//CVVs are not kept in any form, not even as the keyed hash older versions stored
+	{Table: "cards", Statement: "ALTER TABLE cards DROP COLUMN IF EXISTS cvv_check"},
+	//amount thresholds without a currency compared amounts in any currency, they stay disabled until a currency is set
+	{Table: "risk_rules", Statement: "UPDATE risk_rules SET disabled = true WHERE type = 'amount_threshold' AND (currency IS NULL OR currency = '') AND NOT disabled"},
+}
+
+//defaultAuthorizationTTLSeconds returns the gateway's default validity window in whole seconds like Merchant.AuthorizationTTL
+func defaultAuthorizationTTLSeconds() []interface{} {
+	return []interface{}{int64(DefaultAuthorizationTTL() / time.Second)}
+}
+
+//MigrateSchemaChanges applies the schema changes to tables created by older versions
+func MigrateSchemaChanges(db *gorm.DB) error {
+	if db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	for _, change := range schemaChanges {
+		if !db.HasTable(change.Table) {
+			continue
+		}
+		args := []interface{}{}
+		if change.Args != nil {
+			args = change.Args()
+		}
+		if err := db.Exec(change.Statement, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//MigrateCardVault moves card numbers stored in plain text by older versions into the vault
+//cards are encrypted, bank accounts are linked to card fingerprints and authorizations to card tokens
+//the plain text columns are dropped once everything is migrated, so it is a no-op on every later start
+func MigrateCardVault(db *gorm.DB) error {
+	if db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	if !db.Dialect().HasColumn("cards", "number") && !db.Dialect().HasColumn("authorizations", "card_number") {
+		return nil
+	}
+
+	vault, err := getVault()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialect().HasColumn("cards", "number") {
+			if err := migratePlainCards(tx, vault); err != nil {
+				return err
+			}
+		}
+		if err := migrateBankAccountCards(tx, vault); err != nil {
+			return err
+		}
+		if tx.Dialect().HasColumn("authorizations", "card_number") {
+			if err := migrateAuthorizationCards(tx, vault); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//migratePlainCards encrypts the cards stored in plain text and drops the number and CVV columns
+func migratePlainCards(tx *gorm.DB, vault *Vault) error {
+	type plainCard struct {
+		ID     uint32
+		Number string
+	}
+
+	rows, err := tx.Raw("SELECT id, number FROM cards WHERE fingerprint IS NULL OR fingerprint = ''").Rows()
+	if err != nil {
+		return err
+	}
+	cards := []plainCard{}
+	for rows.Next() {
+		var card plainCard
+		if err = rows.Scan(&card.ID, &card.Number); err != nil {
+			rows.Close()
+			return err
+		}
+		cards = append(cards, card)
+	}
+	rows.Close()
+
+	for _, card := range cards {
+		fingerprint := vault.Fingerprint(card.Number)
+		encryptedPAN, wrappedKey, err := vault.Encrypt(card.Number, fingerprint)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Card{}).Where("id = ?", card.ID).UpdateColumns(
+			map[string]interface{}{
+				"fingerprint":   fingerprint,
+				"bin":           card.Number[:6],
+				"last4":         card.Number[len(card.Number)-4:],
+				"encrypted_pan": encryptedPAN,
+				"wrapped_key":   wrappedKey,
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec("ALTER TABLE cards DROP COLUMN IF EXISTS number, DROP COLUMN IF EXISTS cvv").Error
+}
+
+//migrateBankAccountCards replaces the card numbers bank accounts were linked to with the card fingerprints
+func migrateBankAccountCards(tx *gorm.DB, vault *Vault) error {
+	bankAccounts := []BankAccount{}
+	if err := tx.Model(BankAccount{}).Find(&bankAccounts).Error; err != nil {
+		return err
+	}
+
+	cardI := NewCardI()
+	for _, ba := range bankAccounts {
+		if !cardI.ValidateLuhnNumber(ba.CardID) {
+			continue
+		}
+		err := tx.Model(&BankAccount{}).Where("id = ?", ba.ID).UpdateColumn("card_id", vault.Fingerprint(ba.CardID)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//migrateAuthorizationCards links authorizations to the merchant's card token and drops the card number column
+//authorizations made before merchants existed have no merchant, no merchant can see them so they get a token of merchant 0
+func migrateAuthorizationCards(tx *gorm.DB, vault *Vault) error {
+	type plainAuthorization struct {
+		ID         string
+		MerchantID sql.NullInt64
+		CardNumber string
+	}
+
+	rows, err := tx.Raw("SELECT id, merchant_id, card_number FROM authorizations WHERE card_token IS NULL OR card_token = ''").Rows()
+	if err != nil {
+		return err
+	}
+	authorizations := []plainAuthorization{}
+	for rows.Next() {
+		var auth plainAuthorization
+		if err = rows.Scan(&auth.ID, &auth.MerchantID, &auth.CardNumber); err != nil {
+			rows.Close()
+			return err
+		}
+		authorizations = append(authorizations, auth)
+	}
+	rows.Close()
+
+	cardI := NewCardI()
+	for _, auth := range authorizations {
+		if !auth.MerchantID.Valid {
+			logger.FromDB(tx).Warn("authorization without a merchant is linked to a token of merchant 0", logger.Fields{"authorization_id": auth.ID})
+		}
+
+		var card Card
+		err = tx.Model(Card{}).Where("fingerprint = ?", vault.Fingerprint(auth.CardNumber)).Take(&card).Error
+		if err != nil {
+			return fmt.Errorf("cannot find the card of authorization %s: %v", auth.ID, err)
+		}
+		cardToken, err := cardI.Tokenize(tx, uint32(auth.MerchantID.Int64), &card)
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(&Authorization{}).Where("id = ?", auth.ID).UpdateColumn("card_token", cardToken.Token).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec("ALTER TABLE authorizations DROP COLUMN IF EXISTS card_number").Error
+}
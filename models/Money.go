@@ -0,0 +1,87 @@
+package models
+
+import (
+	"math/big"
+	"strings"
+)
+
+//Money is an amount expressed in the minor unit of its currency (e.g. cents for USD, yen for JPY)
+//it is serialized as a plain integer both in JSON and in the DB
+type Money int64
+
+//currencyExponents lists the ISO 4217 currencies whose minor unit is not 1/100 of the major unit
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+//centCurrencies lists the other ISO 4217 currencies, their minor unit is 1/100 of the major unit
+var centCurrencies = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true, "AWG": true, "AZN": true,
+	"BAM": true, "BBD": true, "BDT": true, "BGN": true, "BMD": true, "BND": true, "BOB": true, "BOV": true, "BRL": true, "BSD": true,
+	"BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHE": true, "CHF": true, "CHW": true, "CNY": true,
+	"COP": true, "COU": true, "CRC": true, "CUC": true, "CUP": true, "CVE": true, "CZK": true, "DKK": true, "DOP": true, "DZD": true,
+	"EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true,
+	"GMD": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true,
+	"IRR": true, "JMD": true, "KES": true, "KGS": true, "KHR": true, "KPW": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true,
+	"LKR": true, "LRD": true, "LSL": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true,
+	"MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true, "MXV": true, "MYR": true, "MZN": true, "NAD": true, "NGN": true,
+	"NIO": true, "NOK": true, "NPR": true, "NZD": true, "PAB": true, "PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true,
+	"QAR": true, "RON": true, "RSD": true, "RUB": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SLL": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true, "SZL": true,
+	"THB": true, "TJS": true, "TMT": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true, "TZS": true, "UAH": true, "USD": true,
+	"USN": true, "UYU": true, "UZS": true, "VED": true, "VES": true, "WST": true, "XCD": true, "YER": true, "ZAR": true, "ZMW": true,
+	"ZWG": true, "ZWL": true,
+}
+
+//IsCurrency tells whether the currency is an ISO 4217 code, either in currencyExponents or in centCurrencies
+func IsCurrency(currency string) bool {
+	currency = strings.ToUpper(currency)
+	if _, ok := currencyExponents[currency]; ok {
+		return true
+	}
+	return centCurrencies[currency]
+}
+
+//CurrencyExponent returns the number of decimals used by the currency, 2 unless listed otherwise
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+//Convert converts the amount from one currency to another using the given rate (1 unit of from = rate units of to)
+//the result is rounded half away from zero to the minor unit of the target currency
+func (m Money) Convert(rate float64, from, to string) Money {
+	rat := new(big.Rat).SetFloat64(rate)
+	if rat == nil {
+		return 0
+	}
+	r := new(big.Rat).SetInt64(int64(m))
+	r.Mul(r, rat)
+	r.Mul(r, pow10(CurrencyExponent(to)))
+	r.Quo(r, pow10(CurrencyExponent(from)))
+	return roundRat(r)
+}
+
+//pow10 returns 10^exp as a rational number
+func pow10(exp int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+}
+
+//roundRat rounds a rational number half away from zero
+func roundRat(r *big.Rat) Money {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		quo.Neg(quo)
+	}
+	return Money(quo.Int64())
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	ProcessorSimulator = "simulator"
+	ProcessorHTTP      = "acquirer"
+)
+
+//response codes returned by processors, they follow the ISO 8583 action codes
+const (
+	ResponseApproved          = "00"
+	ResponseDoNotHonor        = "05"
+	ResponsePartialApproval   = "10"
+	ResponseInvalidAmount     = "13"
+	ResponseInvalidCard       = "14"
+	ResponseNoOriginal        = "25"
+	ResponseInsufficientFunds = "51"
+	ResponseSuspectedFraud    = "59"
+	ResponseIssuerUnavailable = "91"
+	ResponseDuplicate         = "94"
+	ResponseSystemError       = "96"
+	ResponseCVVMismatch       = "N7"
+)
+
+// generic information about an operation sent to a processor
+// Card is only set when authorizing, later operations refer to the authorization by Reference
+// CVV is the one the merchant sent with the authorization, it is passed on for the issuer to verify and never stored
+// Final asks the processor to release whatever is still held once the operation is done
+// OperationID stays the same when an operation is sent again, processors use it to apply the operation only once
+type ProcessorRequest struct {
+	AuthorizationID string
+	OperationID     string
+	MerchantID      uint32
+	Reference       string
+	Card            *Card
+	CVV             string
+	CardToken       string
+	Amount          Money
+	Currency        string
+	Final           bool
+}
+
+// generic information about the answer of a processor
+// ApprovedAmount is only set when less than the requested amount was authorized
+type ProcessorResponse struct {
+	Reference      string
+	ResponseCode   string
+	ApprovedAmount Money
+}
+
+//Interface implemented by every acquirer or issuer connection
+//a declined operation returns the response with its code together with the error
+//operations are never called inside the gateway's transactions, db is only for processors keeping their own state in it
+type Processor interface {
+	Name() string
+	Authorize(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error)
+	Capture(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error)
+	Void(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error)
+	Refund(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error)
+}
+
+// generic information about a routing rule, empty fields match everything
+// e.g. {"merchantId": 123456, "currency": "EUR", "cardBrand": "visa", "processor": "acquirer"}
+type ProcessorRoute struct {
+	MerchantID uint32 `json:"merchantId"`
+	Currency   string `json:"currency"`
+	CardBrand  string `json:"cardBrand"`
+	Processor  string `json:"processor"`
+}
+
+// format of the processor routes file, the first matching route wins and Default handles the rest
+type ProcessorRoutes struct {
+	Default string           `json:"default"`
+	Routes  []ProcessorRoute `json:"routes"`
+}
+
+// generic information about the processors and how authorizations are routed to them
+type ProcessorRouter struct {
+	processors map[string]Processor
+	routes     ProcessorRoutes
+}
+
+var (
+	processorRouterMu sync.RWMutex
+	processorRouter   = NewProcessorRouter(ProcessorRoutes{}, NewSimulatorProcessor())
+)
+
+//NewProcessorRouter routes authorizations between the processors, the simulator is the default unless the routes name another one
+func NewProcessorRouter(routes ProcessorRoutes, processors ...Processor) *ProcessorRouter {
+	router := &ProcessorRouter{processors: map[string]Processor{}, routes: routes}
+	for _, processor := range processors {
+		router.processors[processor.Name()] = processor
+	}
+	if router.routes.Default == "" {
+		router.routes.Default = ProcessorSimulator
+	}
+	for i := range router.routes.Routes {
+		router.routes.Routes[i].Currency = strings.ToUpper(router.routes.Routes[i].Currency)
+		router.routes.Routes[i].CardBrand = strings.ToLower(router.routes.Routes[i].CardBrand)
+	}
+	return router
+}
+
+//SetProcessorRouter replaces the processors and the routing used for new authorizations
+func SetProcessorRouter(router *ProcessorRouter) {
+	processorRouterMu.Lock()
+	defer processorRouterMu.Unlock()
+	processorRouter = router
+}
+
+func getProcessorRouter() *ProcessorRouter {
+	processorRouterMu.RLock()
+	defer processorRouterMu.RUnlock()
+	return processorRouter
+}
+
+//NewProcessorRouterFromEnv builds the simulator and the HTTP acquirer and reads the routes from PROCESSOR_ROUTES_FILE
+//without a routes file every authorization goes to PROCESSOR_DEFAULT, the simulator unless set
+func NewProcessorRouterFromEnv() (*ProcessorRouter, error) {
+	routes := ProcessorRoutes{Default: os.Getenv("PROCESSOR_DEFAULT")}
+	if path := os.Getenv("PROCESSOR_ROUTES_FILE"); path != "" {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(body, &routes); err != nil {
+			return nil, err
+		}
+	}
+
+	router := NewProcessorRouter(routes, NewSimulatorProcessor(), NewHTTPProcessorFromEnv())
+	if err := router.validate(); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+//validate checks that every route names a known processor
+func (r *ProcessorRouter) validate() error {
+	if _, ok := r.processors[r.routes.Default]; !ok {
+		return apierrors.New(constants.InvalidProcessorRoutesFile)
+	}
+	for _, route := range r.routes.Routes {
+		if _, ok := r.processors[route.Processor]; !ok {
+			return apierrors.New(constants.InvalidProcessorRoutesFile)
+		}
+	}
+	return nil
+}
+
+//Route returns the processor of a new authorization of the merchant in the currency with a card of the brand
+func (r *ProcessorRouter) Route(merchantID uint32, currency, cardBrand string) (Processor, error) {
+	currency, cardBrand = strings.ToUpper(currency), strings.ToLower(cardBrand)
+	for _, route := range r.routes.Routes {
+		if route.MerchantID != 0 && route.MerchantID != merchantID {
+			continue
+		}
+		if route.Currency != "" && route.Currency != currency {
+			continue
+		}
+		if route.CardBrand != "" && route.CardBrand != cardBrand {
+			continue
+		}
+		return r.Processor(route.Processor)
+	}
+	return r.Processor(r.routes.Default)
+}
+
+//Processor returns the processor with the name, authorizations stored before processors existed were handled by the simulator
+func (r *ProcessorRouter) Processor(name string) (Processor, error) {
+	if name == "" {
+		name = ProcessorSimulator
+	}
+	processor, ok := r.processors[name]
+	if !ok {
+		return nil, apierrors.WithDetail(constants.UnknownProcessor, name)
+	}
+	return processor, nil
+}
+
+//processorRequest describes an operation on the authorization to the processor that handled it
+func (a *Authorization) processorRequest(amount Money, final bool) ProcessorRequest {
+	return ProcessorRequest{
+		AuthorizationID: a.ID,
+		MerchantID:      a.MerchantID,
+		Reference:       a.ProcessorReference,
+		CardToken:       a.CardToken,
+		Amount:          amount,
+		Currency:        a.CurrencyCard,
+		Final:           final,
+	}
+}
+
+//processor returns the processor that handled the authorization
+func (a *Authorization) processor() (Processor, error) {
+	return getProcessorRouter().Processor(a.Processor)
+}
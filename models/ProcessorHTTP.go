@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	defaultAcquirerURL     = "http://localhost:9090"
+	defaultAcquirerTimeout = 5 * time.Second
+)
+
+// processor forwarding operations to an acquirer over HTTP, see the mockacquirer package for the API
+// the card is described by its fingerprint, BIN and last 4 digits so the PAN stays in the vault
+type HTTPProcessor struct {
+	URL    string
+	APIKey string
+	Client *http.Client
+}
+
+// generic information about the card sent to the acquirer, CVV is only sent when the merchant sent one
+type AcquirerCard struct {
+	Fingerprint     string `json:"fingerprint"`
+	Brand           string `json:"brand"`
+	BIN             string `json:"bin"`
+	Last4           string `json:"last4"`
+	ExpirationMonth int    `json:"expirationMonth"`
+	ExpirationYear  int    `json:"expirationYear"`
+	CVV             string `json:"cvv,omitempty"`
+}
+
+// generic information about a request to the acquirer, Card is only sent when authorizing
+// the acquirer applies a request once per OperationID and answers a repeated one like it did the first time
+type AcquirerRequest struct {
+	MerchantID      uint32        `json:"merchantId,omitempty"`
+	AuthorizationID string        `json:"authorizationId,omitempty"`
+	OperationID     string        `json:"operationId,omitempty"`
+	Card            *AcquirerCard `json:"card,omitempty"`
+	Amount          Money         `json:"amount"`
+	Currency        string        `json:"currency,omitempty"`
+	Final           bool          `json:"final"`
+}
+
+// generic information about the answer of the acquirer
+type AcquirerResponse struct {
+	ID             string `json:"id"`
+	ResponseCode   string `json:"responseCode"`
+	ApprovedAmount Money  `json:"approvedAmount,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+func NewHTTPProcessor(rawURL, apiKey string, timeout time.Duration) *HTTPProcessor {
+	return &HTTPProcessor{
+		URL:    strings.TrimRight(rawURL, "/"),
+		APIKey: apiKey,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+//NewHTTPProcessorFromEnv configures the processor from ACQUIRER_URL, ACQUIRER_API_KEY and ACQUIRER_TIMEOUT
+func NewHTTPProcessorFromEnv() *HTTPProcessor {
+	rawURL := os.Getenv("ACQUIRER_URL")
+	if rawURL == "" {
+		rawURL = defaultAcquirerURL
+	}
+	return NewHTTPProcessor(rawURL, os.Getenv("ACQUIRER_API_KEY"), envDuration("ACQUIRER_TIMEOUT", defaultAcquirerTimeout))
+}
+
+func (p *HTTPProcessor) Name() string {
+	return ProcessorHTTP
+}
+
+//Authorize asks the acquirer to authorize the amount on the card
+func (p *HTTPProcessor) Authorize(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	return p.send("/authorizations", AcquirerRequest{
+		MerchantID:      request.MerchantID,
+		AuthorizationID: request.AuthorizationID,
+		OperationID:     request.OperationID,
+		Card: &AcquirerCard{
+			Fingerprint:     request.Card.Fingerprint,
+			Brand:           request.Card.Brand,
+			BIN:             request.Card.BIN,
+			Last4:           request.Card.Last4,
+			ExpirationMonth: request.Card.ExpirationMonth,
+			ExpirationYear:  request.Card.ExpirationYear,
+			CVV:             request.CVV,
+		},
+		Amount:   request.Amount,
+		Currency: request.Currency,
+	})
+}
+
+//Capture asks the acquirer to capture the amount of the authorization
+func (p *HTTPProcessor) Capture(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	return p.send("/authorizations/"+url.PathEscape(request.Reference)+"/captures", AcquirerRequest{OperationID: request.OperationID, Amount: request.Amount, Final: request.Final})
+}
+
+//Void asks the acquirer to release what is left of the authorization
+//an authorization the acquirer did not answer for has no reference, it is reversed by the gateway's authorization ID
+func (p *HTTPProcessor) Void(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	if request.Reference == "" {
+		return p.send("/reversals", AcquirerRequest{AuthorizationID: request.AuthorizationID, OperationID: request.OperationID, Final: true})
+	}
+	return p.send("/authorizations/"+url.PathEscape(request.Reference)+"/voids", AcquirerRequest{OperationID: request.OperationID, Final: true})
+}
+
+//Refund asks the acquirer to refund the amount of the authorization
+func (p *HTTPProcessor) Refund(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	return p.send("/authorizations/"+url.PathEscape(request.Reference)+"/refunds", AcquirerRequest{OperationID: request.OperationID, Amount: request.Amount, Final: request.Final})
+}
+
+//send posts the request and turns the acquirer's response code into the gateway's error
+//the acquirer is considered unavailable when it cannot be reached or refuses the request without a response code
+//a request that was sent but got no readable answer may have been applied, it fails with ProcessorTimeout
+func (p *HTTPProcessor) send(path string, acquirerRequest AcquirerRequest) (ProcessorResponse, error) {
+	body, err := json.Marshal(acquirerRequest)
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return ProcessorResponse{ResponseCode: ResponseSystemError}, apierrors.New(constants.ProcessorUnavailable)
+		}
+		return ProcessorResponse{ResponseCode: ResponseSystemError}, apierrors.New(constants.ProcessorTimeout)
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ProcessorResponse{ResponseCode: ResponseSystemError}, apierrors.New(constants.ProcessorTimeout)
+	}
+	acquirerResponse := AcquirerResponse{}
+	if err = json.Unmarshal(resBody, &acquirerResponse); err != nil || acquirerResponse.ResponseCode == "" {
+		//client errors are refused before anything is applied, e.g. a wrong API key
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			return ProcessorResponse{ResponseCode: ResponseSystemError}, apierrors.New(constants.ProcessorUnavailable)
+		}
+		return ProcessorResponse{ResponseCode: ResponseSystemError}, apierrors.New(constants.ProcessorTimeout)
+	}
+
+	response := ProcessorResponse{Reference: acquirerResponse.ID, ResponseCode: acquirerResponse.ResponseCode, ApprovedAmount: acquirerResponse.ApprovedAmount}
+	return response, acquirerError(acquirerResponse.ResponseCode)
+}
+
+//acquirerError returns the gateway's error for a response code of the acquirer
+func acquirerError(responseCode string) error {
+	switch responseCode {
+	case ResponseApproved, ResponsePartialApproval:
+		return nil
+	case ResponseInsufficientFunds:
+		return apierrors.New(constants.AmountExeedsBalance)
+	case ResponseInvalidCard:
+		return apierrors.New(constants.BankAccountNotFound)
+	case ResponseInvalidAmount:
+		return apierrors.New(constants.InvalidAmount)
+	case ResponseNoOriginal:
+		return apierrors.New(constants.HoldNotFound)
+	case ResponseDoNotHonor:
+		return apierrors.New(constants.ProcessorDeclined)
+	case ResponseSuspectedFraud:
+		return apierrors.New(constants.SuspectedFraud)
+	case ResponseCVVMismatch:
+		return apierrors.New(constants.NoMatchCVV)
+	case ResponseDuplicate:
+		return apierrors.New(constants.ProcessorOperationConflict)
+	default:
+		return apierrors.New(constants.ProcessorUnavailable)
+	}
+}
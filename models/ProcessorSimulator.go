@@ -0,0 +1,156 @@
+package models
+
+import (
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// processor simulating the issuer with the bank accounts in the gateway's own DB
+// funds are held per authorization, see BankAccountI, unless a scenario scripts the outcome of the authorization
+type SimulatorProcessor struct {
+	bank      BankAccountI
+	scenarios SimulatorScenarioI
+}
+
+var (
+	simulatorCVVsMu sync.RWMutex
+	simulatorCVVs   = map[string]string{}
+)
+
+//SetSimulatorCVV sets the CVV the simulated issuer expects for the card with the fingerprint, e.g. from the test cards file
+//it is only kept in memory like an issuer's own records and is set again when the server seeds the test cards on start
+//a card registered through the API is only known to the simulated issuer once its CVV was set
+func SetSimulatorCVV(fingerprint, cvv string) {
+	simulatorCVVsMu.Lock()
+	defer simulatorCVVsMu.Unlock()
+	simulatorCVVs[fingerprint] = cvv
+}
+
+//simulatorCVVMatches tells whether the simulated issuer accepts the CVV for the card, no CVV matches for a card it does not know
+func simulatorCVVMatches(fingerprint, cvv string) bool {
+	simulatorCVVsMu.RLock()
+	defer simulatorCVVsMu.RUnlock()
+	expected, ok := simulatorCVVs[fingerprint]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(cvv)) == 1
+}
+
+func NewSimulatorProcessor() *SimulatorProcessor {
+	return &SimulatorProcessor{bank: NewBankAccountI(), scenarios: NewSimulatorScenarioI()}
+}
+
+func (p *SimulatorProcessor) Name() string {
+	return ProcessorSimulator
+}
+
+//Authorize places a hold on the bank account of the card, or answers as the scenario of the card and amount scripts it
+//a CVV that was sent must match the one the issuer knows for the card
+func (p *SimulatorProcessor) Authorize(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	if request.CVV != "" && !simulatorCVVMatches(request.Card.Fingerprint, request.CVV) {
+		return simulatorResponse(apierrors.New(constants.NoMatchCVV))
+	}
+
+	scenario, err := p.scenarios.FindScenario(db, request.Card.Fingerprint, request.Amount)
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+	if scenario == nil {
+		return simulatorResponse(p.bank.AuthorizeBalance(db, request.Card.Fingerprint, request.AuthorizationID, request.Amount))
+	}
+
+	//the gateway asks processors outside of its transactions, the delay holds no lock and the bank account is only locked afterwards
+	time.Sleep(scenario.delay())
+	switch scenario.outcome() {
+	case constants.OutcomeInsufficientFunds:
+		return simulatorResponse(apierrors.New(constants.AmountExeedsBalance))
+	case constants.OutcomeSuspectedFraud:
+		return simulatorResponse(apierrors.New(constants.SuspectedFraud))
+	case constants.OutcomeTimeout:
+		return simulatorResponse(apierrors.New(constants.ProcessorUnavailable))
+	case constants.OutcomePartialApproval:
+		approved := scenario.approvedAmount(request.Amount)
+		response, err := simulatorResponse(p.bank.AuthorizeBalance(db, request.Card.Fingerprint, request.AuthorizationID, approved))
+		if err == nil && approved < request.Amount {
+			response.ResponseCode, response.ApprovedAmount = ResponsePartialApproval, approved
+		}
+		return response, err
+	default:
+		return simulatorResponse(p.bank.AuthorizeBalance(db, request.Card.Fingerprint, request.AuthorizationID, request.Amount))
+	}
+}
+
+//Capture debits the bank account out of the authorization's hold, a final capture releases the rest in the same transaction
+func (p *SimulatorProcessor) Capture(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	bankCard, err := bankCardID(db, request.CardToken)
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+	return simulatorResponse(db.Transaction(func(tx *gorm.DB) error {
+		if err := p.bank.CaptureBalance(tx, bankCard, request.AuthorizationID, request.Amount); err != nil {
+			return err
+		}
+		if request.Final {
+			return p.bank.ReleaseHold(tx, bankCard, request.AuthorizationID)
+		}
+		return nil
+	}))
+}
+
+//Void releases what is left of the authorization's hold
+func (p *SimulatorProcessor) Void(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	bankCard, err := bankCardID(db, request.CardToken)
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+	return simulatorResponse(p.bank.VoidAuthorization(db, bankCard, request.AuthorizationID))
+}
+
+//Refund credits the bank account, a final refund releases the rest of the hold in the same transaction
+func (p *SimulatorProcessor) Refund(db *gorm.DB, request ProcessorRequest) (ProcessorResponse, error) {
+	bankCard, err := bankCardID(db, request.CardToken)
+	if err != nil {
+		return ProcessorResponse{}, err
+	}
+	return simulatorResponse(db.Transaction(func(tx *gorm.DB) error {
+		if err := p.bank.RefundBalance(tx, bankCard, request.AuthorizationID, request.Amount); err != nil {
+			return err
+		}
+		if request.Final {
+			return p.bank.ReleaseHold(tx, bankCard, request.AuthorizationID)
+		}
+		return nil
+	}))
+}
+
+//simulatorResponse gives the outcome of a bank account operation a reference and a response code
+func simulatorResponse(err error) (ProcessorResponse, error) {
+	response := ProcessorResponse{Reference: "sim_" + ksuid.New().String(), ResponseCode: ResponseApproved}
+	if err == nil {
+		return response, nil
+	}
+
+	switch err.Error() {
+	case constants.AmountExeedsBalance:
+		response.ResponseCode = ResponseInsufficientFunds
+	case constants.BankAccountNotFound:
+		response.ResponseCode = ResponseInvalidCard
+	case constants.InvalidAmount, constants.AmountExeedsAuthorizedBalance:
+		response.ResponseCode = ResponseInvalidAmount
+	case constants.HoldNotFound:
+		response.ResponseCode = ResponseNoOriginal
+	case constants.SuspectedFraud:
+		response.ResponseCode = ResponseSuspectedFraud
+	case constants.ProcessorUnavailable:
+		response.ResponseCode = ResponseIssuerUnavailable
+	case constants.NoMatchCVV:
+		response.ResponseCode = ResponseCVVMismatch
+	default:
+		response.ResponseCode = ResponseSystemError
+	}
+	return response, err
+}
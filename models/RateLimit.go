@@ -0,0 +1,304 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStorePostgres = "postgres"
+
+	//rateLimitPruneInterval is how often the stores forget buckets that have refilled
+	rateLimitPruneInterval = time.Minute
+)
+
+//DefaultRateLimits are used when RATE_LIMITS_FILE is not set
+var DefaultRateLimits = RateLimits{
+	Default: &RateLimit{Requests: 100, PeriodSeconds: 60},
+	Routes: map[string]RateLimit{
+		"POST /login": {Requests: 10, PeriodSeconds: 60},
+	},
+}
+
+// generic information about a token bucket, Requests are allowed every PeriodSeconds
+// Burst is how many requests can be made at once, Requests when 0
+type RateLimit struct {
+	Requests      int   `json:"requests"`
+	PeriodSeconds int64 `json:"periodSeconds"`
+	Burst         int   `json:"burst"`
+}
+
+// generic information about the limits of one merchant, they win over the limits of every merchant
+type MerchantRateLimits struct {
+	Default *RateLimit           `json:"default"`
+	Routes  map[string]RateLimit `json:"routes"`
+}
+
+// format of the rate limits file, routes are keyed by method and path template, e.g. "PUT /{mid}/authorize"
+// a route limit wins over the default one and routes without either are not limited
+type RateLimits struct {
+	Default   *RateLimit                    `json:"default"`
+	Routes    map[string]RateLimit          `json:"routes"`
+	Merchants map[uint32]MerchantRateLimits `json:"merchants"`
+}
+
+// generic information about the outcome of taking a token from a bucket
+// Reset is when the bucket is full again, RetryAfter how long a refused request has to wait
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Time
+	RetryAfter time.Duration
+}
+
+//Interface implemented by the places buckets are kept
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// generic information about the limits and where their buckets are kept
+type RateLimiter struct {
+	store  RateLimitStore
+	limits RateLimits
+}
+
+func NewRateLimiter(store RateLimitStore, limits RateLimits) *RateLimiter {
+	return &RateLimiter{store: store, limits: limits}
+}
+
+//NewRateLimiterFromEnv keeps the buckets in the store selected by RATE_LIMIT_STORE (memory or postgres), defaulting to memory
+//the limits are read from RATE_LIMITS_FILE, DefaultRateLimits are used when unset
+func NewRateLimiterFromEnv(db *gorm.DB) (*RateLimiter, error) {
+	limits := DefaultRateLimits
+	if path := os.Getenv("RATE_LIMITS_FILE"); path != "" {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		limits = RateLimits{}
+		if err = json.Unmarshal(body, &limits); err != nil {
+			return nil, err
+		}
+	}
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(os.Getenv("RATE_LIMIT_STORE")) {
+	case "", RateLimitStoreMemory:
+		return NewRateLimiter(NewMemoryRateLimitStore(), limits), nil
+	case RateLimitStorePostgres:
+		return NewRateLimiter(NewDBRateLimitStore(db), limits), nil
+	default:
+		return nil, apierrors.WithDetail(constants.UnknownRateLimitStore, os.Getenv("RATE_LIMIT_STORE"))
+	}
+}
+
+//Allow takes a token from the bucket of the client for the route, clients are "merchant:<id>" or "ip:<address>"
+//the returned bool is false when no limit applies to the route
+func (l *RateLimiter) Allow(client string, merchantID uint32, route string, now time.Time) (RateLimitResult, bool, error) {
+	limit, scope, ok := l.limits.lookup(merchantID, route)
+	if !ok {
+		return RateLimitResult{}, false, nil
+	}
+	result, err := l.store.Take(client+" "+scope, limit, now)
+	return result, true, err
+}
+
+//lookup returns the limit of the merchant's requests to the route and the name of the bucket it fills
+//routes sharing a default limit share its bucket
+func (rl RateLimits) lookup(merchantID uint32, route string) (RateLimit, string, bool) {
+	if merchant, ok := rl.Merchants[merchantID]; ok && merchantID != 0 {
+		if limit, ok := merchant.Routes[route]; ok {
+			return limit, route, true
+		}
+		if merchant.Default != nil {
+			return *merchant.Default, "*", true
+		}
+	}
+	if limit, ok := rl.Routes[route]; ok {
+		return limit, route, true
+	}
+	if rl.Default != nil {
+		return *rl.Default, "*", true
+	}
+	return RateLimit{}, "", false
+}
+
+//validate checks that every limit lets requests through
+func (rl RateLimits) validate() error {
+	limits := []RateLimit{}
+	if rl.Default != nil {
+		limits = append(limits, *rl.Default)
+	}
+	for _, limit := range rl.Routes {
+		limits = append(limits, limit)
+	}
+	for _, merchant := range rl.Merchants {
+		if merchant.Default != nil {
+			limits = append(limits, *merchant.Default)
+		}
+		for _, limit := range merchant.Routes {
+			limits = append(limits, limit)
+		}
+	}
+
+	for _, limit := range limits {
+		if limit.Requests <= 0 || limit.PeriodSeconds <= 0 || limit.Burst < 0 {
+			return apierrors.New(constants.InvalidRateLimits)
+		}
+	}
+	return nil
+}
+
+//burst returns how many tokens the bucket holds when full
+func (r RateLimit) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Requests
+}
+
+//rate returns how many tokens are added to the bucket every second
+func (r RateLimit) rate() float64 {
+	return float64(r.Requests) / float64(r.PeriodSeconds)
+}
+
+//take refills the bucket for the time since it was last updated and takes a token when there is one
+//it returns the tokens left in the bucket
+func (r RateLimit) take(tokens float64, updatedAt time.Time, now time.Time) (float64, RateLimitResult) {
+	burst := float64(r.burst())
+	if elapsed := now.Sub(updatedAt).Seconds(); elapsed > 0 {
+		tokens = math.Min(burst, tokens+elapsed*r.rate())
+	}
+
+	result := RateLimitResult{Limit: r.burst()}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / r.rate() * float64(time.Second))
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = now.Add(time.Duration((burst - tokens) / r.rate() * float64(time.Second)))
+	return tokens, result
+}
+
+// generic information about a bucket of the in memory store
+type memoryRateLimitBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// store keeping the buckets in the memory of the process, limits are not shared between replicas
+type MemoryRateLimitStore struct {
+	mu         sync.Mutex
+	buckets    map[string]*memoryRateLimitBucket
+	lastPruned time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryRateLimitBucket{}}
+}
+
+//Take takes a token from the bucket of the key, a new bucket starts full
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPruned) >= rateLimitPruneInterval {
+		for k, bucket := range s.buckets {
+			if !bucket.fullAt.After(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastPruned = now
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryRateLimitBucket{tokens: float64(limit.burst()), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+	tokens, result := limit.take(bucket.tokens, bucket.updatedAt, now)
+	bucket.tokens, bucket.updatedAt, bucket.fullAt = tokens, now, result.Reset
+	return result, nil
+}
+
+// generic information about a bucket shared by every replica through the rate_limit_buckets table
+// FullAt is when the bucket has refilled, the row can be removed from then on
+type RateLimitBucket struct {
+	Key       string    `gorm:"primary_key;size:255" json:"key"`
+	Tokens    float64   `gorm:"not null" json:"tokens"`
+	UpdatedAt time.Time `gorm:"not null" json:"updated_at"`
+	FullAt    time.Time `gorm:"not null;index" json:"full_at"`
+}
+
+// store keeping the buckets in Postgres so every replica enforces the same limits
+type DBRateLimitStore struct {
+	db         *gorm.DB
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func NewDBRateLimitStore(db *gorm.DB) *DBRateLimitStore {
+	return &DBRateLimitStore{db: db}
+}
+
+//Take takes a token from the bucket of the key, the row is locked so concurrent requests take one token each
+func (s *DBRateLimitStore) Take(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.prune(now)
+
+	var result RateLimitResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at) VALUES (?, ?, ?, ?) ON CONFLICT (key) DO NOTHING",
+			key, float64(limit.burst()), now, now).Error
+		if err != nil {
+			return err
+		}
+
+		var bucket RateLimitBucket
+		if err = forUpdate(tx).Model(&RateLimitBucket{}).Where("key = ?", key).Take(&bucket).Error; err != nil {
+			return err
+		}
+
+		var tokens float64
+		tokens, result = limit.take(bucket.Tokens, bucket.UpdatedAt, now)
+		return tx.Model(&RateLimitBucket{}).Where("key = ?", key).UpdateColumns(
+			map[string]interface{}{
+				"tokens":     tokens,
+				"updated_at": now,
+				"full_at":    result.Reset,
+			},
+		).Error
+	})
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return result, nil
+}
+
+//prune removes the buckets that have refilled, a missing bucket starts full anyway
+func (s *DBRateLimitStore) prune(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPruned) < rateLimitPruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPruned = now
+	s.mu.Unlock()
+
+	s.db.Where("full_at <= ?", now).Delete(&RateLimitBucket{})
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	defaultRiskReviewScore = 50
+	defaultRiskBlockScore  = 100
+)
+
+// generic information about a rule that matched an authorization request, kept as it was when it matched
+type RiskRuleMatch struct {
+	ID              uint32    `gorm:"primary_key;auto_increment" json:"-"`
+	AuthorizationID string    `gorm:"size:32;not null;index" json:"-"`
+	RuleID          uint32    `gorm:"not null" json:"ruleId"`
+	RuleName        string    `gorm:"size:100;not null" json:"ruleName"`
+	RuleType        string    `gorm:"size:30;not null" json:"ruleType"`
+	Score           int       `gorm:"not null" json:"score"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about the fraud screening of an authorization request
+// the score is the sum of the scores of the matched rules, RISK_REVIEW_SCORE and RISK_BLOCK_SCORE turn it into the decision
+type RiskAssessment struct {
+	Decision string          `json:"decision"`
+	Score    int             `json:"score"`
+	Rules    []RiskRuleMatch `json:"rules"`
+}
+
+// what the rules are evaluated against
+type riskRequest struct {
+	MerchantID uint32
+	Card       *Card
+	Amount     Money
+	Currency   string
+	Now        time.Time
+}
+
+//assessRisk evaluates the enabled rules of the merchant against the authorization request
+func assessRisk(db *gorm.DB, request riskRequest) (*RiskAssessment, error) {
+	rules := []RiskRule{}
+	err := db.Model(&RiskRule{}).
+		Where("disabled = ? AND (merchant_id = 0 OR merchant_id = ?)", false, request.MerchantID).
+		Order("id").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+
+	assessment := &RiskAssessment{Rules: []RiskRuleMatch{}}
+	for i := range rules {
+		matched, err := rules[i].matches(db, request)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		assessment.Score += rules[i].Score
+		assessment.Rules = append(assessment.Rules, RiskRuleMatch{
+			RuleID:   rules[i].ID,
+			RuleName: rules[i].Name,
+			RuleType: rules[i].Type,
+			Score:    rules[i].Score,
+		})
+	}
+
+	review, block := riskThresholds()
+	switch {
+	case assessment.Score >= block:
+		assessment.Decision = constants.RiskDecision(constants.RiskBlock).String()
+	case assessment.Score >= review:
+		assessment.Decision = constants.RiskDecision(constants.RiskReview).String()
+	default:
+		assessment.Decision = constants.RiskDecision(constants.RiskAllow).String()
+	}
+	return assessment, nil
+}
+
+//matches tells whether the rule matches the authorization request
+func (r *RiskRule) matches(db *gorm.DB, request riskRequest) (bool, error) {
+	ruleType, err := parseRiskRuleType(r.Type)
+	if err != nil {
+		return false, err
+	}
+
+	switch ruleType {
+	case constants.CardVelocityRule:
+		count, err := countCardAuthorizations(db, request.Card.ID, "", request.Now.Add(-r.window()))
+		return count >= r.MaxCount, err
+	case constants.MerchantVelocityRule:
+		var count int
+		err := db.Model(&Authorization{}).
+			Where("merchant_id = ? AND created_at >= ?", request.MerchantID, request.Now.Add(-r.window())).
+			Count(&count).Error
+		return count >= r.MaxCount, err
+	case constants.AmountThresholdRule:
+		if r.Currency != strings.ToUpper(request.Currency) {
+			return false, nil
+		}
+		return request.Amount >= r.Amount, nil
+	case constants.CurrencyMismatchRule:
+		return strings.ToUpper(request.Currency) != request.Card.Currency, nil
+	case constants.CVVFailuresRule:
+		cvvDeclineCode := apierrors.From(apierrors.New(constants.NoMatchCVV), http.StatusPaymentRequired).Code
+		count, err := countCardAuthorizations(db, request.Card.ID, cvvDeclineCode, request.Now.Add(-r.window()))
+		return count >= r.MaxCount, err
+	case constants.CountryMismatchRule:
+		return countriesDiffer(db, request)
+	}
+	return false, nil
+}
+
+//countCardAuthorizations counts the authorization requests with the card since the time, of every merchant
+//only declines with the code are counted when it is set
+func countCardAuthorizations(db *gorm.DB, cardID uint32, declineCode string, since time.Time) (int, error) {
+	query := db.Table("authorizations").
+		Joins("JOIN card_tokens ON card_tokens.token = authorizations.card_token").
+		Where("card_tokens.card_id = ? AND authorizations.created_at >= ?", cardID, since)
+	if declineCode != "" {
+		query = query.Where("authorizations.decline_code = ?", declineCode)
+	}
+	var count int
+	err := query.Count(&count).Error
+	return count, err
+}
+
+//countriesDiffer tells whether the card was issued in another country than the merchant's
+//nothing is compared when either country is unknown
+func countriesDiffer(db *gorm.DB, request riskRequest) (bool, error) {
+	merchant, err := NewMerchantI().FindMerchantByID(db, request.MerchantID)
+	if err != nil {
+		if err.Error() == constants.MerchantNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if merchant.Country == "" || request.Card.BIN == "" {
+		return false, nil
+	}
+
+	var binCountry BINCountry
+	err = db.Model(&BINCountry{}).
+		Where("? LIKE prefix || '%'", request.Card.BIN).
+		Order("length(prefix) DESC").
+		Take(&binCountry).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return binCountry.Country != merchant.Country, nil
+}
+
+//riskThresholds returns the scores from which authorization requests are reviewed and blocked
+func riskThresholds() (review int, block int) {
+	review, block = defaultRiskReviewScore, defaultRiskBlockScore
+	if score, err := strconv.Atoi(os.Getenv("RISK_REVIEW_SCORE")); err == nil && score > 0 {
+		review = score
+	}
+	if score, err := strconv.Atoi(os.Getenv("RISK_BLOCK_SCORE")); err == nil && score > 0 {
+		block = score
+	}
+	return review, block
+}
+
+//recordRiskMatches stores the rules that matched the authorization
+func recordRiskMatches(tx *gorm.DB, authorizationID string, matches []RiskRuleMatch) error {
+	for _, match := range matches {
+		match.ID = 0
+		match.AuthorizationID = authorizationID
+		if err := tx.Create(&match).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RiskAssessment returns the fraud screening of the merchant's authorization with the rules that matched it
+func (a *Authorization) RiskAssessment(merchantID uint32, authId string, db *gorm.DB) (*RiskAssessment, error) {
+	authorization, err := a.FindAuthorizationByID(merchantID, authId, db)
+	if err != nil {
+		return &RiskAssessment{}, err
+	}
+
+	assessment := &RiskAssessment{Decision: authorization.RiskDecision, Score: authorization.RiskScore, Rules: []RiskRuleMatch{}}
+	err = db.Model(&RiskRuleMatch{}).Where("authorization_id = ?", authorization.ID).Order("id").Find(&assessment.Rules).Error
+	if err != nil {
+		return &RiskAssessment{}, err
+	}
+	return assessment, nil
+}
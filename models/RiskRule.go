@@ -0,0 +1,270 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about a fraud screening rule
+// MerchantID limits the rule to one merchant, 0 applies it to every merchant
+// MaxCount and WindowSeconds are used by the velocity and CVV failure rules, Amount and Currency by the amount threshold
+// Score is added to the risk score of every authorization request the rule matches
+type RiskRule struct {
+	ID            uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	Name          string    `gorm:"size:100;not null" json:"name"`
+	Type          string    `gorm:"size:30;not null" json:"type"`
+	MerchantID    uint32    `gorm:"not null;default:0;index" json:"merchantId"`
+	MaxCount      int       `gorm:"not null;default:0" json:"maxCount"`
+	WindowSeconds int64     `gorm:"not null;default:0" json:"windowSeconds"`
+	Amount        Money     `gorm:"not null;default:0" json:"amount"`
+	Currency      string    `gorm:"size:4" json:"currency"`
+	Score         int       `gorm:"not null;default:0" json:"score"`
+	Disabled      bool      `gorm:"not null;default:false" json:"disabled"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// generic information about the request for creating or replacing a risk rule
+type RiskRuleRequest struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	MerchantID    uint32 `json:"merchantId"`
+	MaxCount      int    `json:"maxCount"`
+	WindowSeconds int64  `json:"windowSeconds"`
+	Amount        Money  `json:"amount"`
+	Currency      string `json:"currency"`
+	Score         int    `json:"score"`
+	Disabled      bool   `json:"disabled"`
+}
+
+// generic information about the issuing country of a range of cards, the longest matching BIN prefix wins
+type BINCountry struct {
+	Prefix    string    `gorm:"primary_key;size:8" json:"prefix"`
+	Country   string    `gorm:"size:2;not null" json:"country"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+//Interface to call Risk Rule functions
+type RiskRuleI interface {
+	CreateRiskRule(db *gorm.DB, ruleRequest RiskRuleRequest) (*RiskRule, error)
+	ListRiskRules(db *gorm.DB) ([]RiskRule, error)
+	FindRiskRuleByID(db *gorm.DB, id uint32) (*RiskRule, error)
+	UpdateRiskRule(db *gorm.DB, id uint32, ruleRequest RiskRuleRequest) (*RiskRule, error)
+	DeleteRiskRule(db *gorm.DB, id uint32) error
+	SetBINCountry(db *gorm.DB, binCountry BINCountry) (*BINCountry, error)
+	ListBINCountries(db *gorm.DB) ([]BINCountry, error)
+	DeleteBINCountry(db *gorm.DB, prefix string) error
+}
+
+func NewRiskRuleI() RiskRuleI {
+	return &RiskRule{}
+}
+
+//CreateRiskRule checks and stores a new rule, it applies to the next authorization requests
+func (r *RiskRule) CreateRiskRule(db *gorm.DB, ruleRequest RiskRuleRequest) (*RiskRule, error) {
+	rule, err := ruleRequest.rule()
+	if err != nil {
+		return &RiskRule{}, err
+	}
+	if err = db.Create(&rule).Error; err != nil {
+		return &RiskRule{}, err
+	}
+	return &rule, nil
+}
+
+//ListRiskRules returns every rule in the order they were created
+func (r *RiskRule) ListRiskRules(db *gorm.DB) ([]RiskRule, error) {
+	rules := []RiskRule{}
+	err := db.Model(&RiskRule{}).Order("id").Find(&rules).Error
+	if err != nil {
+		return []RiskRule{}, err
+	}
+	return rules, nil
+}
+
+//FindRiskRuleByID retrieves a rule by ID from the DB
+func (r *RiskRule) FindRiskRuleByID(db *gorm.DB, id uint32) (*RiskRule, error) {
+	var rule RiskRule
+	err := db.Model(&RiskRule{}).Where("id = ?", id).Take(&rule).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &RiskRule{}, apierrors.New(constants.RiskRuleNotFound)
+	}
+	if err != nil {
+		return &RiskRule{}, err
+	}
+	return &rule, nil
+}
+
+//UpdateRiskRule replaces every setting of the rule
+func (r *RiskRule) UpdateRiskRule(db *gorm.DB, id uint32, ruleRequest RiskRuleRequest) (*RiskRule, error) {
+	rule, err := ruleRequest.rule()
+	if err != nil {
+		return &RiskRule{}, err
+	}
+
+	db = db.Model(&RiskRule{}).Where("id = ?", id).UpdateColumns(
+		map[string]interface{}{
+			"name":           rule.Name,
+			"type":           rule.Type,
+			"merchant_id":    rule.MerchantID,
+			"max_count":      rule.MaxCount,
+			"window_seconds": rule.WindowSeconds,
+			"amount":         rule.Amount,
+			"currency":       rule.Currency,
+			"score":          rule.Score,
+			"disabled":       rule.Disabled,
+			"updated_at":     time.Now(),
+		},
+	)
+	if db.Error != nil {
+		return &RiskRule{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &RiskRule{}, apierrors.New(constants.RiskRuleNotFound)
+	}
+	return r.FindRiskRuleByID(db, id)
+}
+
+//DeleteRiskRule removes the rule, authorizations it matched keep their recorded matches
+func (r *RiskRule) DeleteRiskRule(db *gorm.DB, id uint32) error {
+	db = db.Where("id = ?", id).Delete(&RiskRule{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return apierrors.New(constants.RiskRuleNotFound)
+	}
+	return nil
+}
+
+//SetBINCountry stores the issuing country of the cards whose BIN starts with the prefix, replacing an earlier one
+func (r *RiskRule) SetBINCountry(db *gorm.DB, binCountry BINCountry) (*BINCountry, error) {
+	if !validBINPrefix(binCountry.Prefix) {
+		return &BINCountry{}, apierrors.New(constants.InvalidBINPrefix)
+	}
+	country, err := normalizeCountry(binCountry.Country)
+	if err != nil || country == "" {
+		return &BINCountry{}, apierrors.New(constants.InvalidCountry)
+	}
+
+	stored := BINCountry{Prefix: binCountry.Prefix, Country: country}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("prefix = ?", stored.Prefix).Delete(&BINCountry{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&stored).Error
+	})
+	if err != nil {
+		return &BINCountry{}, err
+	}
+	return &stored, nil
+}
+
+//ListBINCountries returns every BIN prefix with its country ordered by prefix
+func (r *RiskRule) ListBINCountries(db *gorm.DB) ([]BINCountry, error) {
+	binCountries := []BINCountry{}
+	err := db.Model(&BINCountry{}).Order("prefix").Find(&binCountries).Error
+	if err != nil {
+		return []BINCountry{}, err
+	}
+	return binCountries, nil
+}
+
+//DeleteBINCountry removes the country of the BIN prefix
+func (r *RiskRule) DeleteBINCountry(db *gorm.DB, prefix string) error {
+	db = db.Where("prefix = ?", prefix).Delete(&BINCountry{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return apierrors.New(constants.BINCountryNotFound)
+	}
+	return nil
+}
+
+//rule checks the request and returns the rule it describes
+func (rr RiskRuleRequest) rule() (RiskRule, error) {
+	ruleType, err := parseRiskRuleType(rr.Type)
+	if err != nil {
+		return RiskRule{}, err
+	}
+	if strings.TrimSpace(rr.Name) == "" || rr.Score < 0 {
+		return RiskRule{}, apierrors.New(constants.InvalidRiskRule)
+	}
+
+	switch ruleType {
+	case constants.CardVelocityRule, constants.MerchantVelocityRule, constants.CVVFailuresRule:
+		if rr.MaxCount <= 0 || rr.WindowSeconds <= 0 {
+			return RiskRule{}, apierrors.New(constants.InvalidRiskRule)
+		}
+	case constants.AmountThresholdRule:
+		//amounts are in minor units, they can only be compared with requests in the same currency
+		if rr.Amount <= 0 || rr.Currency == "" {
+			return RiskRule{}, apierrors.New(constants.InvalidRiskRule)
+		}
+		if !IsCurrency(rr.Currency) {
+			return RiskRule{}, apierrors.New(constants.InvalidCurrency)
+		}
+	}
+	currency := strings.ToUpper(rr.Currency)
+	if len(currency) > 4 {
+		return RiskRule{}, apierrors.New(constants.InvalidCurrency)
+	}
+
+	return RiskRule{
+		Name:          strings.TrimSpace(rr.Name),
+		Type:          ruleType.String(),
+		MerchantID:    rr.MerchantID,
+		MaxCount:      rr.MaxCount,
+		WindowSeconds: rr.WindowSeconds,
+		Amount:        rr.Amount,
+		Currency:      currency,
+		Score:         rr.Score,
+		Disabled:      rr.Disabled,
+	}, nil
+}
+
+//window returns how far back the rule counts authorization requests
+func (r *RiskRule) window() time.Duration {
+	return time.Duration(r.WindowSeconds) * time.Second
+}
+
+//parseRiskRuleType returns the rule type with the given name
+func parseRiskRuleType(name string) (constants.RiskRuleType, error) {
+	for ruleType := constants.RiskRuleType(constants.CardVelocityRule); ruleType <= constants.CountryMismatchRule; ruleType++ {
+		if ruleType.String() == name {
+			return ruleType, nil
+		}
+	}
+	return 0, apierrors.WithDetail(constants.UnknownRiskRuleType, name)
+}
+
+//validBINPrefix tells whether the prefix is 1 to 8 digits
+func validBINPrefix(prefix string) bool {
+	if len(prefix) == 0 || len(prefix) > 8 {
+		return false
+	}
+	for _, c := range prefix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//normalizeCountry uppercases a 2 letter country code, an empty code is kept
+func normalizeCountry(country string) (string, error) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if country == "" {
+		return "", nil
+	}
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return "", apierrors.New(constants.InvalidCountry)
+	}
+	return country, nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about a scripted outcome of the simulator processor
+// CardID is the fingerprint of the card and Amount is in the card currency, empty values match every card or amount
+// ApprovedAmount is what a partial approval authorizes, half of the amount when 0, DelayMs delays the answer
+type SimulatorScenario struct {
+	ID             uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	CardID         string    `gorm:"size:100;index" json:"cardId"`
+	Amount         Money     `gorm:"not null;default:0" json:"amount"`
+	Outcome        string    `gorm:"size:20;not null" json:"outcome"`
+	ApprovedAmount Money     `gorm:"not null;default:0" json:"approvedAmount"`
+	DelayMs        int       `gorm:"not null;default:0" json:"delayMs"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+//Interface to call Simulator Scenario functions
+type SimulatorScenarioI interface {
+	CreateScenario(db *gorm.DB, scenario *SimulatorScenario) (*SimulatorScenario, error)
+	FindScenario(db *gorm.DB, cardID string, amount Money) (*SimulatorScenario, error)
+}
+
+func NewSimulatorScenarioI() SimulatorScenarioI {
+	return &SimulatorScenario{}
+}
+
+//CreateScenario stores a new scenario to the DB after checking its outcome
+func (s *SimulatorScenario) CreateScenario(db *gorm.DB, scenario *SimulatorScenario) (*SimulatorScenario, error) {
+	if _, err := parseSimulatorOutcome(scenario.Outcome); err != nil {
+		return &SimulatorScenario{}, err
+	}
+	if scenario.Amount < 0 || scenario.ApprovedAmount < 0 || scenario.DelayMs < 0 {
+		return &SimulatorScenario{}, apierrors.New(constants.InvalidSimulatorScenario)
+	}
+	if scenario.Amount > 0 && scenario.ApprovedAmount >= scenario.Amount {
+		return &SimulatorScenario{}, apierrors.New(constants.InvalidSimulatorScenario)
+	}
+
+	if err := db.Create(scenario).Error; err != nil {
+		return &SimulatorScenario{}, err
+	}
+	return scenario, nil
+}
+
+//FindScenario returns the scenario of an authorization of the card for the amount, nil when the bank account decides
+//a scenario of the card wins over one of the amount, one of both the card and the amount wins over either
+func (s *SimulatorScenario) FindScenario(db *gorm.DB, cardID string, amount Money) (*SimulatorScenario, error) {
+	var scenario SimulatorScenario
+	err := db.Model(&SimulatorScenario{}).
+		Where("card_id = ? OR card_id = ''", cardID).
+		Where("amount = ? OR amount = 0", amount).
+		Order("card_id DESC, amount DESC").
+		Take(&scenario).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+//outcome returns the scripted outcome, scenarios are checked when they are created
+func (s *SimulatorScenario) outcome() constants.SimulatorOutcome {
+	outcome, _ := parseSimulatorOutcome(s.Outcome)
+	return outcome
+}
+
+//delay returns how long the simulator waits before answering
+func (s *SimulatorScenario) delay() time.Duration {
+	return time.Duration(s.DelayMs) * time.Millisecond
+}
+
+//approvedAmount returns what a partial approval of the amount authorizes
+func (s *SimulatorScenario) approvedAmount(amount Money) Money {
+	if s.ApprovedAmount > 0 && s.ApprovedAmount < amount {
+		return s.ApprovedAmount
+	}
+	if half := amount / 2; half > 0 {
+		return half
+	}
+	return amount
+}
+
+//parseSimulatorOutcome returns the outcome with the given name
+func parseSimulatorOutcome(name string) (constants.SimulatorOutcome, error) {
+	for outcome := constants.SimulatorOutcome(constants.OutcomeApprove); outcome <= constants.OutcomeDelayed; outcome++ {
+		if outcome.String() == name {
+			return outcome, nil
+		}
+	}
+	return 0, apierrors.WithDetail(constants.UnknownSimulatorOutcome, name)
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+// generic information about a single operation on an authorization
+// the table is append only, amounts are in the card currency and FXRate is the rate locked on the authorization
+type Transaction struct {
+	ID              string    `gorm:"primary_key" json:"id"`
+	AuthorizationID string    `gorm:"size:27;not null;index" json:"authorizationId"`
+	MerchantID      uint32    `gorm:"not null;index" json:"merchantId"`
+	Type            string    `gorm:"size:16;not null" json:"type"`
+	Amount          Money     `gorm:"not null" json:"amount"`
+	Currency        string    `gorm:"size:4;not null" json:"currency"`
+	FXRate          float64   `gorm:"not null;default:1" json:"fxRate"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about the balances of an authorization derived from its transactions
+type LedgerBalances struct {
+	Authorised Money
+	Captured   Money
+	Refunded   Money
+}
+
+//Interface to call Transaction functions
+type TransactionI interface {
+	RecordTransaction(tx *gorm.DB, auth *Authorization, transactionType int, amount Money) (*Transaction, error)
+	ListTransactions(merchantID uint32, authId string, db *gorm.DB) ([]Transaction, error)
+	Balances(authId string, db *gorm.DB) (LedgerBalances, error)
+	VerifyBalances(auth *Authorization, db *gorm.DB) error
+}
+
+func NewTransactionI() TransactionI {
+	return &Transaction{}
+}
+
+//RecordTransaction appends an operation on the authorization to the ledger
+func (t *Transaction) RecordTransaction(tx *gorm.DB, auth *Authorization, transactionType int, amount Money) (*Transaction, error) {
+	transaction := Transaction{
+		ID:              ksuid.New().String(),
+		AuthorizationID: auth.ID,
+		MerchantID:      auth.MerchantID,
+		Type:            constants.TransactionType(transactionType).String(),
+		Amount:          amount,
+		Currency:        auth.CurrencyCard,
+		FXRate:          auth.FXRate,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return &Transaction{}, err
+	}
+	return &transaction, nil
+}
+
+//ListTransactions returns the operations of the merchant's authorization in the order they happened
+func (t *Transaction) ListTransactions(merchantID uint32, authId string, db *gorm.DB) ([]Transaction, error) {
+	transactions := []Transaction{}
+	err := db.Model(Transaction{}).Where("authorization_id = ? AND merchant_id = ?", authId, merchantID).Order("created_at asc, id asc").Find(&transactions).Error
+	if err != nil {
+		return []Transaction{}, err
+	}
+	return transactions, nil
+}
+
+//Balances derives the authorization balances from its transactions
+//refunds move money from the captured to the refunded balance, matching the aggregate columns
+func (t *Transaction) Balances(authId string, db *gorm.DB) (LedgerBalances, error) {
+	rows, err := db.Model(Transaction{}).Select("type, COALESCE(SUM(amount), 0)").Where("authorization_id = ?", authId).Group("type").Rows()
+	if err != nil {
+		return LedgerBalances{}, err
+	}
+	defer rows.Close()
+
+	balances := LedgerBalances{}
+	for rows.Next() {
+		var transactionType string
+		var sum Money
+		if err = rows.Scan(&transactionType, &sum); err != nil {
+			return LedgerBalances{}, err
+		}
+		switch transactionType {
+		case constants.TransactionType(constants.AuthorizeTransaction).String():
+			balances.Authorised += sum
+		case constants.TransactionType(constants.CaptureTransaction).String():
+			balances.Captured += sum
+		case constants.TransactionType(constants.RefundTransaction).String():
+			balances.Captured -= sum
+			balances.Refunded += sum
+		}
+	}
+	return balances, rows.Err()
+}
+
+//VerifyBalances checks the aggregate balances of the authorization against the ledger before it is changed
+//authorizations created before the ledger existed get opening transactions matching their current balances
+func (t *Transaction) VerifyBalances(auth *Authorization, db *gorm.DB) error {
+	var count int
+	if err := db.Model(Transaction{}).Where("authorization_id = ?", auth.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return t.openLedger(auth, db)
+	}
+
+	balances, err := t.Balances(auth.ID, db)
+	if err != nil {
+		return err
+	}
+	if balances.Authorised != auth.BalanceAuthorised || balances.Captured != auth.BalanceCaptured || balances.Refunded != auth.BalanceRefunded {
+		return apierrors.New(constants.LedgerMismatch)
+	}
+	return nil
+}
+
+//openLedger backfills the transactions of an authorization that predates the ledger
+func (t *Transaction) openLedger(auth *Authorization, db *gorm.DB) error {
+	opening := []struct {
+		transactionType int
+		amount          Money
+	}{
+		{constants.AuthorizeTransaction, auth.BalanceAuthorised},
+		{constants.CaptureTransaction, auth.BalanceCaptured + auth.BalanceRefunded},
+		{constants.RefundTransaction, auth.BalanceRefunded},
+	}
+	for _, entry := range opening {
+		if entry.amount == 0 {
+			continue
+		}
+		if _, err := t.RecordTransaction(db, auth, entry.transactionType, entry.amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const vaultKeySize = 32
+
+// generic information about the card vault
+// every PAN is encrypted with its own data key (AES-256-GCM), the data key is stored wrapped with the key-encryption-key
+// fingerprints use an HMAC-SHA256 key derived from the key-encryption-key so PANs can be looked up without decrypting them
+// CVVs are never stored, not even hashed, they are only passed on to the processor
+type Vault struct {
+	kek            []byte
+	fingerprintKey []byte
+}
+
+var (
+	vaultMu      sync.RWMutex
+	currentVault *Vault
+)
+
+//SetVault sets the vault used to store and look up cards
+func SetVault(v *Vault) {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+	currentVault = v
+}
+
+//getVault returns the configured vault or an error when none was set
+func getVault() (*Vault, error) {
+	vaultMu.RLock()
+	defer vaultMu.RUnlock()
+	if currentVault == nil {
+		return nil, apierrors.New(constants.VaultNotConfigured)
+	}
+	return currentVault, nil
+}
+
+//NewVault creates a vault with a 32 byte key-encryption-key
+func NewVault(kek []byte) (*Vault, error) {
+	if len(kek) != vaultKeySize {
+		return nil, apierrors.New(constants.InvalidVaultKey)
+	}
+	return &Vault{
+		kek:            kek,
+		fingerprintKey: deriveKey(kek, "card fingerprint"),
+	}, nil
+}
+
+//NewVaultFromEnv creates a vault with the base64 encoded key-encryption-key in VAULT_KEK
+func NewVaultFromEnv() (*Vault, error) {
+	kek, err := base64.StdEncoding.DecodeString(os.Getenv("VAULT_KEK"))
+	if err != nil {
+		return nil, apierrors.New(constants.InvalidVaultKey)
+	}
+	return NewVault(kek)
+}
+
+//Encrypt encrypts the PAN with a new data key and returns the ciphertext and the wrapped data key, both base64 encoded
+//the additional data binds the ciphertext to its row so it cannot be copied to another card
+func (v *Vault) Encrypt(pan string, additionalData string) (ciphertext string, wrappedKey string, err error) {
+	dataKey := make([]byte, vaultKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", "", err
+	}
+
+	sealedPAN, err := seal(dataKey, []byte(pan), []byte(additionalData))
+	if err != nil {
+		return "", "", err
+	}
+	sealedKey, err := seal(v.kek, dataKey, []byte(additionalData))
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealedPAN), base64.StdEncoding.EncodeToString(sealedKey), nil
+}
+
+//Decrypt unwraps the data key and returns the PAN
+func (v *Vault) Decrypt(ciphertext string, wrappedKey string, additionalData string) (string, error) {
+	sealedKey, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return "", apierrors.New(constants.CardDecryptionFailed)
+	}
+	dataKey, err := open(v.kek, sealedKey, []byte(additionalData))
+	if err != nil {
+		return "", apierrors.New(constants.CardDecryptionFailed)
+	}
+
+	sealedPAN, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", apierrors.New(constants.CardDecryptionFailed)
+	}
+	pan, err := open(dataKey, sealedPAN, []byte(additionalData))
+	if err != nil {
+		return "", apierrors.New(constants.CardDecryptionFailed)
+	}
+	return string(pan), nil
+}
+
+//Fingerprint returns a keyed hash of the PAN used to find a card without decrypting every PAN
+func (v *Vault) Fingerprint(pan string) string {
+	return hexMAC(v.fingerprintKey, pan)
+}
+
+//seal encrypts with AES-GCM and prepends the random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+//open decrypts what seal returned
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, apierrors.New(constants.CardDecryptionFailed)
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//deriveKey derives a purpose specific key from the key-encryption-key
+func deriveKey(kek []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, kek)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func hexMAC(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,325 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const (
+	webhookSecretPrefix      = "whsec_"
+	webhookDeliveryListLimit = 100
+	webhookURLMaxLength      = 2048
+)
+
+// generic information about a URL a merchant receives events on
+type WebhookEndpoint struct {
+	ID         uint32    `gorm:"primary_key;auto_increment" json:"id"`
+	MerchantID uint32    `gorm:"not null;index" json:"merchantId"`
+	URL        string    `gorm:"size:2048;not null" json:"url"`
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about an authorization lifecycle event
+// events are written in the same transaction as the operation that caused them so none are lost
+type WebhookEvent struct {
+	ID              string    `gorm:"primary_key" json:"id"`
+	MerchantID      uint32    `gorm:"not null;index" json:"merchantId"`
+	AuthorizationID string    `gorm:"size:27;not null;index" json:"authorizationId"`
+	Type            string    `gorm:"size:32;not null" json:"type"`
+	Payload         string    `gorm:"type:text;not null" json:"-"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about the delivery of an event to one endpoint, pending deliveries are the outbox
+// a delivery being sent is in flight until NextAttemptAt, LeaseID tells the dispatcher that claimed it whether it still owns it
+type WebhookDelivery struct {
+	ID             uint64     `gorm:"primary_key;auto_increment" json:"id"`
+	EventID        string     `gorm:"size:27;not null;index" json:"eventId"`
+	EndpointID     uint32     `gorm:"not null;index" json:"endpointId"`
+	MerchantID     uint32     `gorm:"not null;index" json:"merchantId"`
+	EventType      string     `gorm:"size:32;not null" json:"eventType"`
+	Status         string     `gorm:"size:16;not null;index" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null;index" json:"nextAttemptAt"`
+	LastStatusCode int        `gorm:"not null;default:0" json:"lastStatusCode"`
+	LastError      string     `gorm:"size:255" json:"lastError"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	LeaseID        string     `gorm:"size:27;not null;default:''" json:"-"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// generic information about a single attempt of a delivery
+type WebhookAttempt struct {
+	ID         uint64    `gorm:"primary_key;auto_increment" json:"id"`
+	DeliveryID uint64    `gorm:"not null;index" json:"deliveryId"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	StatusCode int       `gorm:"not null;default:0" json:"statusCode"`
+	Error      string    `gorm:"size:255" json:"error"`
+	DurationMs int64     `gorm:"not null;default:0" json:"durationMs"`
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// generic information about the webhook registration request
+type WebhookEndpointRequest struct {
+	URL string `json:"url"`
+}
+
+// generic information about a registered endpoint together with the merchant's signing secret
+type WebhookEndpointResponse struct {
+	Endpoint *WebhookEndpoint `json:"endpoint"`
+	Secret   string           `json:"secret"`
+}
+
+// generic information about a delivery with all of its attempts
+type WebhookDeliveryDetail struct {
+	Delivery *WebhookDelivery `json:"delivery"`
+	Attempts []WebhookAttempt `json:"attempts"`
+}
+
+// generic information about the body POSTed to the merchant
+type WebhookPayload struct {
+	ID      string              `json:"id"`
+	Type    string              `json:"type"`
+	Created int64               `json:"created"`
+	Data    AuthorizationDetail `json:"data"`
+}
+
+//Interface to call Webhook functions
+//every method is scoped to the merchant, another merchant's endpoints and deliveries are reported as not found
+type WebhookI interface {
+	RegisterEndpoint(db *gorm.DB, merchantID uint32, endpointURL string) (*WebhookEndpoint, string, error)
+	ListEndpoints(db *gorm.DB, merchantID uint32) ([]WebhookEndpoint, error)
+	DeleteEndpoint(db *gorm.DB, merchantID uint32, id uint32) error
+	ListDeliveries(db *gorm.DB, merchantID uint32) ([]WebhookDelivery, error)
+	FindDeliveryByID(db *gorm.DB, merchantID uint32, id uint64) (*WebhookDeliveryDetail, error)
+	Redeliver(db *gorm.DB, merchantID uint32, id uint64) (*WebhookDelivery, error)
+}
+
+func NewWebhookI() WebhookI {
+	return &WebhookEndpoint{}
+}
+
+//RegisterEndpoint stores a new endpoint for the merchant and returns the secret its events are signed with
+//the secret is shared by all endpoints of the merchant and is created with the first one
+func (w *WebhookEndpoint) RegisterEndpoint(db *gorm.DB, merchantID uint32, endpointURL string) (endpoint *WebhookEndpoint, secret string, err error) {
+	if err = validateWebhookURL(endpointURL); err != nil {
+		return &WebhookEndpoint{}, "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var merchant Merchant
+		err := forUpdate(tx).Model(Merchant{}).Where("id = ?", merchantID).Take(&merchant).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return apierrors.New(constants.MerchantNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		secret = merchant.WebhookSecret
+		if secret == "" {
+			if secret, err = generateWebhookSecret(); err != nil {
+				return err
+			}
+			err = tx.Model(&Merchant{}).Where("id = ?", merchantID).UpdateColumns(
+				map[string]interface{}{
+					"webhook_secret": secret,
+					"updated_at":     time.Now(),
+				},
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		endpoint = &WebhookEndpoint{MerchantID: merchantID, URL: endpointURL}
+		return tx.Create(endpoint).Error
+	})
+	if err != nil {
+		return &WebhookEndpoint{}, "", err
+	}
+	return endpoint, secret, nil
+}
+
+//ListEndpoints returns the merchant's endpoints
+func (w *WebhookEndpoint) ListEndpoints(db *gorm.DB, merchantID uint32) ([]WebhookEndpoint, error) {
+	endpoints := []WebhookEndpoint{}
+	err := db.Model(WebhookEndpoint{}).Where("merchant_id = ?", merchantID).Order("id asc").Find(&endpoints).Error
+	if err != nil {
+		return []WebhookEndpoint{}, err
+	}
+	return endpoints, nil
+}
+
+//DeleteEndpoint removes the merchant's endpoint, its pending and in flight deliveries are marked as failed
+func (w *WebhookEndpoint) DeleteEndpoint(db *gorm.DB, merchantID uint32, id uint32) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		deleted := tx.Where("id = ? AND merchant_id = ?", id, merchantID).Delete(&WebhookEndpoint{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return apierrors.New(constants.WebhookEndpointNotFound)
+		}
+
+		return tx.Model(&WebhookDelivery{}).Where("endpoint_id = ? AND status IN (?)", id, deliveryStatuses(constants.DeliveryPending, constants.DeliveryInFlight)).UpdateColumns(
+			map[string]interface{}{
+				"status":     deliveryStatus(constants.DeliveryFailed),
+				"last_error": constants.WebhookEndpointNotFound,
+				"lease_id":   "",
+				"updated_at": time.Now(),
+			},
+		).Error
+	})
+}
+
+//ListDeliveries returns the merchant's most recent deliveries
+func (w *WebhookEndpoint) ListDeliveries(db *gorm.DB, merchantID uint32) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := db.Model(WebhookDelivery{}).Where("merchant_id = ?", merchantID).Order("id desc").Limit(webhookDeliveryListLimit).Find(&deliveries).Error
+	if err != nil {
+		return []WebhookDelivery{}, err
+	}
+	return deliveries, nil
+}
+
+//FindDeliveryByID retrieves the merchant's delivery with the log of its attempts
+func (w *WebhookEndpoint) FindDeliveryByID(db *gorm.DB, merchantID uint32, id uint64) (*WebhookDeliveryDetail, error) {
+	var delivery WebhookDelivery
+	err := db.Model(WebhookDelivery{}).Where("id = ? AND merchant_id = ?", id, merchantID).Take(&delivery).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &WebhookDeliveryDetail{}, apierrors.New(constants.WebhookDeliveryNotFound)
+	}
+	if err != nil {
+		return &WebhookDeliveryDetail{}, err
+	}
+
+	attempts := []WebhookAttempt{}
+	err = db.Model(WebhookAttempt{}).Where("delivery_id = ?", id).Order("id asc").Find(&attempts).Error
+	if err != nil {
+		return &WebhookDeliveryDetail{}, err
+	}
+	return &WebhookDeliveryDetail{Delivery: &delivery, Attempts: attempts}, nil
+}
+
+//Redeliver puts the merchant's delivery back in the outbox to be sent as soon as possible with a fresh set of retries
+func (w *WebhookEndpoint) Redeliver(db *gorm.DB, merchantID uint32, id uint64) (*WebhookDelivery, error) {
+	updated := db.Model(&WebhookDelivery{}).Where("id = ? AND merchant_id = ?", id, merchantID).UpdateColumns(
+		map[string]interface{}{
+			"status":          deliveryStatus(constants.DeliveryPending),
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"lease_id":        "",
+			"updated_at":      time.Now(),
+		},
+	)
+	if updated.Error != nil {
+		return &WebhookDelivery{}, updated.Error
+	}
+	if updated.RowsAffected == 0 {
+		return &WebhookDelivery{}, apierrors.New(constants.WebhookDeliveryNotFound)
+	}
+
+	detail, err := w.FindDeliveryByID(db, merchantID, id)
+	if err != nil {
+		return &WebhookDelivery{}, err
+	}
+	return detail.Delivery, nil
+}
+
+//enqueueWebhookEvent stores the event for the authorization and a pending delivery for each of the merchant's endpoints
+//it is called with the transaction of the operation so the event is only kept when the operation is
+func enqueueWebhookEvent(tx *gorm.DB, merchantID uint32, authId string, eventType int) error {
+	var authorization Authorization
+	err := tx.Model(Authorization{}).Where("id = ? AND merchant_id = ?", authId, merchantID).Take(&authorization).Error
+	if err != nil {
+		return err
+	}
+
+	detail, err := authorization.DetailWithCard(tx)
+	if err != nil {
+		return err
+	}
+
+	event := WebhookEvent{
+		ID:              ksuid.New().String(),
+		MerchantID:      merchantID,
+		AuthorizationID: authId,
+		Type:            constants.WebhookEventType(eventType).String(),
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		ID:      event.ID,
+		Type:    event.Type,
+		Created: time.Now().Unix(),
+		Data:    detail,
+	})
+	if err != nil {
+		return err
+	}
+	event.Payload = string(payload)
+
+	if err = tx.Create(&event).Error; err != nil {
+		return err
+	}
+
+	endpoints := []WebhookEndpoint{}
+	if err = tx.Model(WebhookEndpoint{}).Where("merchant_id = ?", merchantID).Find(&endpoints).Error; err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		delivery := WebhookDelivery{
+			EventID:       event.ID,
+			EndpointID:    endpoint.ID,
+			MerchantID:    merchantID,
+			EventType:     event.Type,
+			Status:        deliveryStatus(constants.DeliveryPending),
+			NextAttemptAt: time.Now(),
+		}
+		if err = tx.Create(&delivery).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//validateWebhookURL accepts absolute http and https URLs whose host resolves to public addresses only
+func validateWebhookURL(endpointURL string) error {
+	if len(endpointURL) > webhookURLMaxLength {
+		return apierrors.New(constants.InvalidWebhookURL)
+	}
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return apierrors.New(constants.InvalidWebhookURL)
+	}
+	return verifyWebhookHost(parsed.Hostname())
+}
+
+//generateWebhookSecret returns a new random signing secret
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+func deliveryStatus(status int) string {
+	return constants.WebhookDeliveryStatus(status).String()
+}
+
+func deliveryStatuses(statuses ...int) []string {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = deliveryStatus(status)
+	}
+	return names
+}
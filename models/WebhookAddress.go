@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/constants"
+)
+
+const webhookLookupTimeout = 5 * time.Second
+
+//webhookBlockedNetworks are the private and shared ranges webhooks are never sent to, loopback and link-local addresses are refused too
+var webhookBlockedNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+var (
+	webhookPrivateAddressesMu      sync.RWMutex
+	webhookPrivateAddressesAllowed = false
+)
+
+//AllowWebhookPrivateAddresses lets webhooks be registered for and sent to private addresses, e.g. a local receiver in tests
+//it must stay off in production, merchants could otherwise make the gateway call its internal services
+func AllowWebhookPrivateAddresses(allowed bool) {
+	webhookPrivateAddressesMu.Lock()
+	defer webhookPrivateAddressesMu.Unlock()
+	webhookPrivateAddressesAllowed = allowed
+}
+
+func webhookPrivateAddressesAreAllowed() bool {
+	webhookPrivateAddressesMu.RLock()
+	defer webhookPrivateAddressesMu.RUnlock()
+	return webhookPrivateAddressesAllowed
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+//publicAddress tells whether webhooks may be sent to the address
+func publicAddress(ip net.IP) bool {
+	if webhookPrivateAddressesAreAllowed() {
+		return true
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range webhookBlockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//verifyWebhookHost resolves the host of a webhook URL and refuses it unless every address it resolves to is public
+func verifyWebhookHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicAddress(ip) {
+			return apierrors.New(constants.WebhookURLNotPublic)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookLookupTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return apierrors.New(constants.WebhookURLNotPublic)
+	}
+	for _, address := range addresses {
+		if !publicAddress(address.IP) {
+			return apierrors.New(constants.WebhookURLNotPublic)
+		}
+	}
+	return nil
+}
+
+//webhookDialControl refuses connections to addresses that are not public, it runs after the host was resolved
+//so a host that resolved to a public address when it was registered cannot be pointed at an internal service later
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicAddress(ip) {
+		return apierrors.New(constants.WebhookURLNotPublic)
+	}
+	return nil
+}
+
+//NewWebhookHTTPClient returns the client webhooks are sent with, it only connects to public addresses and ignores proxies
+func NewWebhookHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
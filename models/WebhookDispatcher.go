@@ -0,0 +1,360 @@
+package models
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+)
+
+const (
+	WebhookSignatureHeader  = "Webhook-Signature"
+	WebhookEventIDHeader    = "Webhook-Event-Id"
+	WebhookEventTypeHeader  = "Webhook-Event-Type"
+	WebhookDeliveryIDHeader = "Webhook-Delivery-Id"
+
+	defaultWebhookDispatchInterval = 5 * time.Second
+	defaultWebhookTimeout          = 10 * time.Second
+	defaultWebhookMaxAttempts      = 8
+	defaultWebhookBaseBackoff      = 30 * time.Second
+	defaultWebhookMaxBackoff       = 6 * time.Hour
+	webhookDispatchBatchSize       = 50
+	webhookErrorMaxLength          = 255
+	webhookLeaseMargin             = 30 * time.Second
+)
+
+// generic information about the background job delivering the webhook outbox
+// a failed delivery is retried after BaseBackoff, doubling every attempt up to MaxBackoff, until MaxAttempts is reached
+type WebhookDispatcher struct {
+	DB          *gorm.DB
+	Client      *http.Client
+	Interval    time.Duration
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+//NewWebhookDispatcher creates a dispatcher with the default retry schedule
+func NewWebhookDispatcher(db *gorm.DB, client *http.Client) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		DB:          db,
+		Client:      client,
+		Interval:    defaultWebhookDispatchInterval,
+		MaxAttempts: defaultWebhookMaxAttempts,
+		BaseBackoff: defaultWebhookBaseBackoff,
+		MaxBackoff:  defaultWebhookMaxBackoff,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+//NewWebhookDispatcherFromEnv creates a dispatcher configured with the WEBHOOK_* environment variables
+func NewWebhookDispatcherFromEnv(db *gorm.DB) *WebhookDispatcher {
+	dispatcher := NewWebhookDispatcher(db, NewWebhookHTTPClient(envDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout)))
+	dispatcher.Interval = envDuration("WEBHOOK_DISPATCH_INTERVAL", defaultWebhookDispatchInterval)
+	dispatcher.BaseBackoff = envDuration("WEBHOOK_BASE_BACKOFF", defaultWebhookBaseBackoff)
+	dispatcher.MaxBackoff = envDuration("WEBHOOK_MAX_BACKOFF", defaultWebhookMaxBackoff)
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		dispatcher.MaxAttempts = attempts
+	}
+	return dispatcher
+}
+
+//Start runs the dispatcher in the background until Stop is called
+func (d *WebhookDispatcher) Start() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := d.DeliverDue(time.Now()); err != nil {
+				logger.FromDB(d.DB).Error("cannot deliver webhooks", logger.Fields{"error": err})
+			}
+			select {
+			case <-ticker.C:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop stops a started dispatcher and waits for the current run to finish
+func (d *WebhookDispatcher) Stop() {
+	d.once.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+//DeliverDue attempts every pending delivery that is due and returns how many were attempted
+//deliveries left in flight by a dispatcher that stopped while sending are due again once their lease ran out
+func (d *WebhookDispatcher) DeliverDue(now time.Time) (attempted int, err error) {
+	var lastID uint64
+	for {
+		due := []WebhookDelivery{}
+		err = d.DB.Model(WebhookDelivery{}).Select("id").
+			Where("status IN (?) AND next_attempt_at <= ? AND id > ?", deliveryStatuses(constants.DeliveryPending, constants.DeliveryInFlight), now, lastID).
+			Order("id asc").Limit(webhookDispatchBatchSize).Find(&due).Error
+		if err != nil {
+			return attempted, err
+		}
+
+		for i := range due {
+			lastID = due[i].ID
+			delivered, err := d.attempt(due[i].ID, now)
+			if err != nil {
+				logger.FromDB(d.DB).Error("cannot deliver webhook", logger.Fields{"delivery_id": due[i].ID, "error": err})
+				continue
+			}
+			if delivered {
+				attempted++
+			}
+		}
+
+		if len(due) < webhookDispatchBatchSize {
+			return attempted, nil
+		}
+	}
+}
+
+//attempt sends a single delivery, returns false when the delivery was already handled elsewhere
+//the delivery is claimed in a short transaction and the POST is made outside of it so a slow endpoint does not hold a connection or a lock
+//a claim lasts until the request has timed out, a delivery whose dispatcher stopped while sending is sent again after that
+func (d *WebhookDispatcher) attempt(id uint64, now time.Time) (attempted bool, err error) {
+	claim, err := d.claim(id, now)
+	if err != nil || claim == nil {
+		return false, err
+	}
+
+	started := time.Now()
+	statusCode, sendErr := d.send(claim.endpoint, claim.event, claim.delivery, claim.secret)
+	duration := time.Since(started)
+
+	err = d.DB.Transaction(func(tx *gorm.DB) error {
+		if sendErr == nil && statusCode >= 200 && statusCode < 300 {
+			return d.finish(tx, claim.delivery, constants.DeliveryDelivered, statusCode, "", duration)
+		}
+
+		message := ""
+		if sendErr != nil {
+			message = sendErr.Error()
+		} else {
+			message = fmt.Sprintf("unexpected status code %d", statusCode)
+		}
+		if claim.delivery.Attempts+1 >= d.MaxAttempts {
+			return d.finish(tx, claim.delivery, constants.DeliveryFailed, statusCode, message, duration)
+		}
+		return d.retry(tx, claim.delivery, statusCode, message, duration, now)
+	})
+	return true, err
+}
+
+// generic information about a delivery claimed by the dispatcher, with what is needed to send it
+type webhookClaim struct {
+	delivery *WebhookDelivery
+	event    *WebhookEvent
+	endpoint *WebhookEndpoint
+	secret   string
+}
+
+//claim marks the delivery as in flight under a new lease while holding its row lock so another instance cannot send it at the same time
+//returns nil when the delivery is not due anymore or could not be sent at all
+func (d *WebhookDispatcher) claim(id uint64, now time.Time) (claim *webhookClaim, err error) {
+	err = d.DB.Transaction(func(tx *gorm.DB) error {
+		var delivery WebhookDelivery
+		err := forUpdate(tx).Model(WebhookDelivery{}).Where("id = ?", id).Take(&delivery).Error
+		if err != nil {
+			return err
+		}
+		if !delivery.isDue(now) {
+			return nil
+		}
+
+		var event WebhookEvent
+		if err = tx.Model(WebhookEvent{}).Where("id = ?", delivery.EventID).Take(&event).Error; err != nil {
+			return err
+		}
+
+		var endpoint WebhookEndpoint
+		err = tx.Model(WebhookEndpoint{}).Where("id = ?", delivery.EndpointID).Take(&endpoint).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return d.finish(tx, &delivery, constants.DeliveryFailed, 0, constants.WebhookEndpointNotFound, 0)
+		}
+		if err != nil {
+			return err
+		}
+
+		var merchant Merchant
+		if err = tx.Model(Merchant{}).Where("id = ?", delivery.MerchantID).Take(&merchant).Error; err != nil {
+			return err
+		}
+
+		delivery.LeaseID = ksuid.New().String()
+		err = tx.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).UpdateColumns(
+			map[string]interface{}{
+				"status":          deliveryStatus(constants.DeliveryInFlight),
+				"next_attempt_at": now.Add(d.leaseDuration()),
+				"lease_id":        delivery.LeaseID,
+				"updated_at":      time.Now(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+
+		claim = &webhookClaim{delivery: &delivery, event: &event, endpoint: &endpoint, secret: merchant.WebhookSecret}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+//isDue tells whether the delivery is pending and due, or in flight with a lease that ran out
+func (w *WebhookDelivery) isDue(now time.Time) bool {
+	if w.Status != deliveryStatus(constants.DeliveryPending) && w.Status != deliveryStatus(constants.DeliveryInFlight) {
+		return false
+	}
+	return !w.NextAttemptAt.After(now)
+}
+
+//leaseDuration returns how long a claimed delivery stays in flight, longer than the request can take
+func (d *WebhookDispatcher) leaseDuration() time.Duration {
+	timeout := defaultWebhookTimeout
+	if d.Client != nil && d.Client.Timeout > 0 {
+		timeout = d.Client.Timeout
+	}
+	return timeout + webhookLeaseMargin
+}
+
+//send POSTs the signed event to the endpoint and returns the response status code
+func (d *WebhookDispatcher) send(endpoint *WebhookEndpoint, event *WebhookEvent, delivery *WebhookDelivery, secret string) (int, error) {
+	body := []byte(event.Payload)
+	timestamp := time.Now().Unix()
+
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventIDHeader, event.ID)
+	request.Header.Set(WebhookEventTypeHeader, event.Type)
+	request.Header.Set(WebhookDeliveryIDHeader, strconv.FormatUint(delivery.ID, 10))
+	request.Header.Set(WebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, SignWebhookPayload(secret, timestamp, body)))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	//drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+	return response.StatusCode, nil
+}
+
+//finish logs the attempt and closes the delivery as delivered or failed
+func (d *WebhookDispatcher) finish(tx *gorm.DB, delivery *WebhookDelivery, status int, statusCode int, message string, duration time.Duration) error {
+	columns := map[string]interface{}{
+		"status":           deliveryStatus(status),
+		"attempts":         delivery.Attempts + 1,
+		"last_status_code": statusCode,
+		"last_error":       truncate(message, webhookErrorMaxLength),
+		"lease_id":         "",
+		"updated_at":       time.Now(),
+	}
+	if status == constants.DeliveryDelivered {
+		columns["delivered_at"] = time.Now()
+	}
+	if err := leasedDelivery(tx, delivery).UpdateColumns(columns).Error; err != nil {
+		return err
+	}
+	return logWebhookAttempt(tx, delivery, statusCode, message, duration)
+}
+
+//retry logs the failed attempt and schedules the next one
+func (d *WebhookDispatcher) retry(tx *gorm.DB, delivery *WebhookDelivery, statusCode int, message string, duration time.Duration, now time.Time) error {
+	err := leasedDelivery(tx, delivery).UpdateColumns(
+		map[string]interface{}{
+			"status":           deliveryStatus(constants.DeliveryPending),
+			"attempts":         delivery.Attempts + 1,
+			"next_attempt_at":  now.Add(d.Backoff(delivery.Attempts + 1)),
+			"last_status_code": statusCode,
+			"last_error":       truncate(message, webhookErrorMaxLength),
+			"lease_id":         "",
+			"updated_at":       time.Now(),
+		},
+	).Error
+	if err != nil {
+		return err
+	}
+	return logWebhookAttempt(tx, delivery, statusCode, message, duration)
+}
+
+//leasedDelivery selects the delivery as long as it was not claimed again or redelivered since it was read
+//the attempt is still logged when it was, only the delivery's status is left to its new owner
+func leasedDelivery(tx *gorm.DB, delivery *WebhookDelivery) *gorm.DB {
+	return tx.Model(&WebhookDelivery{}).Where("id = ? AND lease_id = ?", delivery.ID, delivery.LeaseID)
+}
+
+//Backoff returns how long to wait after the given number of failed attempts
+func (d *WebhookDispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+//logWebhookAttempt appends the attempt to the delivery log
+func logWebhookAttempt(tx *gorm.DB, delivery *WebhookDelivery, statusCode int, message string, duration time.Duration) error {
+	attempt := WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts + 1,
+		StatusCode: statusCode,
+		Error:      truncate(message, webhookErrorMaxLength),
+		DurationMs: duration.Milliseconds(),
+	}
+	return tx.Create(&attempt).Error
+}
+
+//SignWebhookPayload returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the merchant's secret
+//merchants recompute it to check the v1 value of the signature header
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
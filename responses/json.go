@@ -4,8 +4,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+
+	"github.com/xectich/paymentGateway/apierrors"
 )
 
+//RequestIDHeader carries the ID of the request on requests and responses
+const RequestIDHeader = "X-Request-Id"
+
+//defaultErrorDocsURL is where the error codes are documented unless ERROR_DOCS_URL is set
+const defaultErrorDocsURL = "https://github.com/xectich/paymentGateway/blob/master/docs/errors.md"
+
+// generic information about an error response
+type ErrorBody struct {
+	Code            string `json:"code"`
+	Category        string `json:"category"`
+	Message         string `json:"message"`
+	AuthorizationID string `json:"authorizationId,omitempty"`
+	RequestID       string `json:"requestId,omitempty"`
+	DocURL          string `json:"docUrl"`
+}
+
+// generic information about the envelope of an error response
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
 func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.WriteHeader(statusCode)
 	err := json.NewEncoder(w).Encode(data)
@@ -14,14 +38,28 @@ func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	}
 }
 
+//ERROR writes err with its code, errors without a code of their own are sent with statusCode
 func ERROR(w http.ResponseWriter, statusCode int, err error) {
 	if err != nil {
-		JSON(w, statusCode, struct {
-			Error string `json:"error"`
-		}{
-			Error: err.Error(),
+		apiErr := apierrors.From(err, statusCode)
+		JSON(w, apiErr.Status, ErrorResponse{
+			Error: ErrorBody{
+				Code:            apiErr.Code,
+				Category:        apiErr.Category,
+				Message:         apiErr.Message,
+				AuthorizationID: apiErr.AuthorizationID,
+				RequestID:       w.Header().Get(RequestIDHeader),
+				DocURL:          errorDocsURL() + "#" + apiErr.Code,
+			},
 		})
 		return
 	}
 	JSON(w, http.StatusBadRequest, nil)
 }
+
+func errorDocsURL() string {
+	if url := os.Getenv("ERROR_DOCS_URL"); url != "" {
+		return url
+	}
+	return defaultErrorDocsURL
+}
@@ -2,12 +2,11 @@ package setup
 
 
 import (
-	"fmt"
-	"log"
 	"os"
 
 	"github.com/joho/godotenv"
 	"github.com/xectich/paymentGateway/controllers"
+	"github.com/xectich/paymentGateway/logger"
 )
 
 var server = controllers.Server{}
@@ -17,10 +16,9 @@ func Run() {
 	var err error
 	err = godotenv.Load()
 	if err != nil {
-		log.Fatalf("Error getting env %v", err)
-	} else {
-		fmt.Println("Getting the env values.....")
+		logger.Default().Fatal("cannot load the env values", logger.Fields{"error": err})
 	}
+	logger.SetDefault(logger.NewFromEnv())
 
 	server.Initialize(os.Getenv("DB_DRIVER"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_PORT"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME"))
 
@@ -1,85 +1,139 @@
 package setup
 
 import (
-	"log"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
 	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/logger"
 	"github.com/xectich/paymentGateway/models"
 )
 
-var cards = []models.Card {
-	models.Card{
-		Number: "4000000000000119",
-		CVV: "123",
-		Currency: "USD",
-		ExpirationMonth: 1,
-		ExpirationYear: 23,
-	},
-	models.Card{
-		Number: "4000000000000259",
-		CVV: "453",
-		Currency: "BGN",
-		ExpirationMonth: 4,
-		ExpirationYear: 22,
-	},
-	models.Card{
-		Number: "4000000000003238",
-		CVV: "765",
-		Currency: "GBP",
-		ExpirationMonth: 10,
-		ExpirationYear: 21,
-	},
-	models.Card{
-		Number: "4000000000004422",
-		CVV: "221",
-		Currency: "EUR",
-		ExpirationMonth: 10,
-		ExpirationYear: 25,
-	},
+const defaultTestCardsFile = "setup/test_cards.json"
+
+// generic information about a seeded test card, its bank account and the scenarios scripting its authorizations
+type testCard struct {
+	Number          string                     `json:"number"`
+	CVV             string                     `json:"cvv"`
+	Currency        string                     `json:"currency"`
+	ExpirationMonth int                        `json:"expirationMonth"`
+	ExpirationYear  int                        `json:"expirationYear"`
+	Balance         models.Money               `json:"balance"`
+	BalanceCurrency string                     `json:"balanceCurrency"`
+	Scenarios       []models.SimulatorScenario `json:"scenarios"`
+}
+
+// format of the test cards file, Scenarios apply to every card
+type testCards struct {
+	Cards     []testCard                 `json:"cards"`
+	Scenarios []models.SimulatorScenario `json:"scenarios"`
 }
 
-var bankAccounts = []models.BankAccount {
-	models.BankAccount {
-		CardID: "4000000000000119",
-		Balance: 0,
-		Currency: "USD",
-	},
-	models.BankAccount {
-		CardID: "4000000000000259",
-		Balance: 10,
-		Currency: "CAD",
-	},
-	models.BankAccount {
-		CardID: "4000000000003238",
-		Balance: 1000,
-		Currency: "GBP",
-	},
-	models.BankAccount {
-		CardID: "4000000000004422",
-		Balance: 1000,
-		Currency: "EUR",
-	},
+var seedMerchant = models.MerchantRequest{
+	ID:   123456,
+	Name: "Demo Merchant",
 }
 
 func Load(db *gorm.DB) {
 
-	err := db.Debug().DropTableIfExists(&models.BankAccount{}, &models.Card{},&models.Authorization{}).Error
+	err := db.DropTableIfExists(&models.BankAccount{}, &models.Card{},&models.Authorization{}, &models.IdempotencyKey{}, &models.FXRate{}, &models.Merchant{}, &models.Transaction{}, &models.Hold{}, &models.CardToken{}, &models.AuthorizationStatusHistory{}, &models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.WebhookAttempt{}, &models.SimulatorScenario{}, &models.RiskRule{}, &models.RiskRuleMatch{}, &models.BINCountry{}, &models.CardVerificationFailure{}, &models.CardLockout{}, &models.RateLimitBucket{}).Error
+	if err != nil {
+		logger.Default().Fatal("cannot drop table", logger.Fields{"error": err})
+	}
+	err = db.AutoMigrate(&models.BankAccount{}, &models.Card{},&models.Authorization{}, &models.IdempotencyKey{}, &models.FXRate{}, &models.Merchant{}, &models.Transaction{}, &models.Hold{}, &models.CardToken{}, &models.AuthorizationStatusHistory{}, &models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.WebhookAttempt{}, &models.SimulatorScenario{}, &models.RiskRule{}, &models.RiskRuleMatch{}, &models.BINCountry{}, &models.CardVerificationFailure{}, &models.CardLockout{}, &models.RateLimitBucket{}).Error
+	if err != nil {
+		logger.Default().Fatal("cannot migrate table", logger.Fields{"error": err})
+	}
+	err = models.MigrateForeignKeys(db)
+	if err != nil {
+		logger.Default().Fatal("cannot migrate foreign keys", logger.Fields{"error": err})
+	}
+
+	loadMerchant(db)
+
+	loadTestCards(db)
+
+	//seed the DB rate table so FX_PROVIDER=db works out of the box
+	for _, rate := range models.NewStaticFXRateProvider(models.DefaultFXRates).Pairs() {
+		err = db.Model(&models.FXRate{}).Create(&rate).Error
+		if err != nil {
+			logger.Default().Fatal("cannot setup fx rates table", logger.Fields{"error": err})
+		}
+	}
+}
+
+//loadMerchant seeds the demo merchant, the API key is taken from SEED_MERCHANT_API_KEY when set
+func loadMerchant(db *gorm.DB) {
+	seedMerchant.APIKey = os.Getenv("SEED_MERCHANT_API_KEY")
+
+	merchantI := models.NewMerchantI()
+	merchant, apiKey, err := merchantI.CreateMerchant(db, seedMerchant)
 	if err != nil {
-		log.Fatalf("cannot drop table: %v", err)
+		logger.Default().Fatal("cannot setup merchants table", logger.Fields{"error": err})
+	}
+
+	if seedMerchant.APIKey != "" {
+		logger.Default().Info("seeded merchant", logger.Fields{"merchant_id": merchant.ID})
+		return
+	}
+	logger.Default().Info("seeded merchant with a generated API key", logger.Fields{"merchant_id": merchant.ID, "generated_key": apiKey})
+}
+
+//loadTestCards seeds the cards, their bank accounts and the simulator scenarios from TEST_CARDS_FILE, setup/test_cards.json unless set
+func loadTestCards(db *gorm.DB) {
+	path := os.Getenv("TEST_CARDS_FILE")
+	if path == "" {
+		path = defaultTestCardsFile
 	}
-	err = db.Debug().AutoMigrate(&models.BankAccount{}, &models.Card{},&models.Authorization{}).Error
+	body, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalf("cannot migrate table: %v", err)
+		logger.Default().Fatal("cannot read test cards file", logger.Fields{"error": err})
+	}
+	var file testCards
+	if err = json.Unmarshal(body, &file); err != nil {
+		logger.Default().Fatal("cannot read test cards file", logger.Fields{"error": err})
 	}
 
-	for i, _ := range bankAccounts {
-		err = db.Debug().Model(&models.BankAccount{}).Create(&bankAccounts[i]).Error
+	cardI := models.NewCardI()
+	scenarioI := models.NewSimulatorScenarioI()
+	for _, testCard := range file.Cards {
+		//the card is stored in the vault first, the bank knows it by its fingerprint
+		card, err := cardI.StoreCard(&models.Card{
+			Number:          testCard.Number,
+			CVV:             testCard.CVV,
+			Currency:        testCard.Currency,
+			ExpirationMonth: testCard.ExpirationMonth,
+			ExpirationYear:  testCard.ExpirationYear,
+		}, db)
 		if err != nil {
-			log.Fatalf("cannot setup bank accounts table: %v", err)
+			logger.Default().Fatal("cannot setup cards table", logger.Fields{"error": err})
 		}
+		//only the bank knows the card's CVV
+		models.SetSimulatorCVV(card.Fingerprint, testCard.CVV)
 
-		err = db.Debug().Model(&models.Card{}).Create(&cards[i]).Error
+		bankAccount := models.BankAccount{
+			CardID:   card.Fingerprint,
+			Balance:  testCard.Balance,
+			Currency: testCard.BalanceCurrency,
+		}
+		err = db.Model(&models.BankAccount{}).Create(&bankAccount).Error
 		if err != nil {
-			log.Fatalf("cannot setup cards table: %v", err)
+			logger.Default().Fatal("cannot setup bank accounts table", logger.Fields{"error": err})
+		}
+
+		for i := range testCard.Scenarios {
+			testCard.Scenarios[i].CardID = card.Fingerprint
+			if _, err = scenarioI.CreateScenario(db, &testCard.Scenarios[i]); err != nil {
+				logger.Default().Fatal("cannot setup simulator scenarios table", logger.Fields{"error": err})
+			}
 		}
 	}
-}
\ No newline at end of file
+
+	for i := range file.Scenarios {
+		file.Scenarios[i].CardID = ""
+		if _, err = scenarioI.CreateScenario(db, &file.Scenarios[i]); err != nil {
+			logger.Default().Fatal("cannot setup simulator scenarios table", logger.Fields{"error": err})
+		}
+	}
+}
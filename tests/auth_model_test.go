@@ -22,7 +22,7 @@ func TestFindAuthorizationByID(t *testing.T) {
 	}
 
 	Convey("When I call FindAuthorizationByID..", t, func() {
-		a, err := authorizationInstance.FindAuthorizationByID(auth.ID, server.DB)
+		a, err := authorizationInstance.FindAuthorizationByID(testMerchantID, auth.ID, server.DB)
 		Convey("Auth.ID should match the one from the DB", func() {
 			So(auth.ID, ShouldEqual, a.ID)
 			So(err, ShouldBeNil)
@@ -51,37 +51,37 @@ func TestRequestAuthorization(t *testing.T) {
 		CardNumber:      "400000000000011",
 		Currency:        "USD",
 		CVV:             "123",
-		Amount:          10,
+		Amount:          1000,
 		ExpirationMonth: 1,
-		ExpirationYear:  23,
+		ExpirationYear:  testCardExpirationYear,
 	}
 
 	Convey("When I call RequestAuthorization..", t, func() {
 		Convey("And Card Number is invalid", func() {
-			_, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And CVV is invalid", func() {
 			authRequest.CardNumber = "4000000000000119"
 			authRequest.CVV = "124"
-			_, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And Amount is invalid", func() {
 			authRequest.CVV = "123"
-			authRequest.Amount = 101
-			_, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+			authRequest.Amount = 10100
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And Amount is Expiration Month is invalid", func() {
-			authRequest.Amount = 10
+			authRequest.Amount = 1000
 			authRequest.ExpirationMonth = 2
-			_, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And request is authorized", func() {
 			authRequest.ExpirationMonth = 1
-			_, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 			So(err, ShouldBeNil)
 		})
 	})
@@ -109,26 +109,26 @@ func TestCapture(t *testing.T) {
 		CardNumber:      "4000000000000119",
 		Currency:        "USD",
 		CVV:             "123",
-		Amount:          10,
+		Amount:          1000,
 		ExpirationMonth: 1,
-		ExpirationYear:  23,
+		ExpirationYear:  testCardExpirationYear,
 	}
 
-	auth, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 
 	Convey("When I call Capture...", t, func() {
 		Convey("And amount is 0", func() {
-			_, err = authorizationInstance.Capture(auth.ID, 0, false, server.DB)
+			_, err = authorizationInstance.Capture(testMerchantID, auth.ID, 0, "USD", false, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And amount is more than capture amount", func() {
-			_, err = authorizationInstance.Capture(auth.ID, 1000, false, server.DB)
+			_, err = authorizationInstance.Capture(testMerchantID, auth.ID, 100000, "USD", false, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And amount is captured", func() {
-			auth, err = authorizationInstance.Capture(auth.ID, 5, false, server.DB)
+			auth, err = authorizationInstance.Capture(testMerchantID, auth.ID, 500, "USD", false, server.DB)
 			So(err, ShouldBeNil)
-			So(auth.BalanceCaptured, ShouldEqual, 5)
+			So(auth.BalanceCaptured, ShouldEqual, 500)
 		})
 
 	})
@@ -156,29 +156,29 @@ func TestRefund(t *testing.T) {
 		CardNumber:      "4000000000000119",
 		Currency:        "USD",
 		CVV:             "123",
-		Amount:          10,
+		Amount:          1000,
 		ExpirationMonth: 1,
-		ExpirationYear:  23,
+		ExpirationYear:  testCardExpirationYear,
 	}
 
-	auth, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 
 	Convey("When I call Refund...", t, func() {
 		Convey("And amount is 0", func() {
-			_, err = authorizationInstance.Refund(auth.ID, 0, false, server.DB)
+			_, err = authorizationInstance.Refund(testMerchantID, auth.ID, 0, "USD", false, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And amount is more than capture amount", func() {
-			_, err = authorizationInstance.Capture(auth.ID, 1000, false, server.DB)
+			_, err = authorizationInstance.Capture(testMerchantID, auth.ID, 100000, "USD", false, server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And amount is refunded", func() {
-			auth, err = authorizationInstance.Capture(auth.ID, 5, false, server.DB)
+			auth, err = authorizationInstance.Capture(testMerchantID, auth.ID, 500, "USD", false, server.DB)
 			So(err, ShouldBeNil)
-			So(auth.BalanceCaptured, ShouldEqual, 5)
-			auth, err = authorizationInstance.Refund(auth.ID, 5, false, server.DB)
+			So(auth.BalanceCaptured, ShouldEqual, 500)
+			auth, err = authorizationInstance.Refund(testMerchantID, auth.ID, 500, "USD", false, server.DB)
 			So(err, ShouldBeNil)
-			So(auth.BalanceRefunded, ShouldEqual, 5)			
+			So(auth.BalanceRefunded, ShouldEqual, 500)			
 		})
 
 	})
@@ -205,20 +205,20 @@ func TestVoid(t *testing.T) {
 		CardNumber:      "4000000000000119",
 		Currency:        "USD",
 		CVV:             "123",
-		Amount:          10,
+		Amount:          1000,
 		ExpirationMonth: 1,
-		ExpirationYear:  23,
+		ExpirationYear:  testCardExpirationYear,
 	}
 
-	auth, err := authorizationInstance.RequestAuthorization(authRequest, server.DB)
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
 
 	Convey("When I call Void...", t, func() {
 		Convey("Authorization is not found", func() {
-			_, err = authorizationInstance.Void("test", server.DB)
+			_, err = authorizationInstance.Void(testMerchantID, "test", server.DB)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("And transaction is voided", func() {
-			_, err = authorizationInstance.Void(auth.ID, server.DB)
+			_, err = authorizationInstance.Void(testMerchantID, auth.ID, server.DB)
 			So(err, ShouldBeNil)
 		})
 
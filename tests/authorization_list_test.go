@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListAuthorizations(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	card, err := addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cardToken, err := cardInstance.Tokenize(server.DB, testMerchantID, &card)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	statuses := []string{
+		constants.AuthStatus(constants.Authorized).String(),
+		constants.AuthStatus(constants.Captured).String(),
+		constants.AuthStatus(constants.Refunded).String(),
+		constants.AuthStatus(constants.Voided).String(),
+	}
+	for i, status := range statuses {
+		auth := models.Authorization{
+			ID:                ksuid.New().String(),
+			MerchantID:        testMerchantID,
+			CardToken:         cardToken.Token,
+			BalanceAuthorised: models.Money((i + 1) * 100),
+			CurrencyRequested: "USD",
+			CurrencyCard:      "USD",
+			FXRate:            1,
+			FXRateSource:      models.FXSourceNone,
+			Status:            status,
+		}
+		if err = server.DB.Create(&auth).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	Convey("When I list authorizations..", t, func() {
+		Convey("Pages follow the cursor without gaps or duplicates", func() {
+			seen := map[string]bool{}
+			filter := models.AuthorizationFilter{Limit: 2}
+			pages := 0
+			for {
+				page, err := authorizationInstance.ListAuthorizations(testMerchantID, filter, server.DB)
+				So(err, ShouldBeNil)
+				pages++
+				for _, auth := range page.Data {
+					So(seen[auth.ID], ShouldBeFalse)
+					seen[auth.ID] = true
+				}
+				if !page.HasMore {
+					break
+				}
+				filter.StartingAfter = page.NextCursor
+			}
+			So(pages, ShouldEqual, 2)
+			So(len(seen), ShouldEqual, len(statuses))
+		})
+		Convey("Filters are applied", func() {
+			min := models.Money(200)
+			page, err := authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{MinAmount: &min}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 3)
+
+			page, err = authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{
+				Status:    constants.AuthStatus(constants.Captured).String(),
+				MinAmount: &min,
+			}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 1)
+		})
+		Convey("Other merchants' authorizations are not listed", func() {
+			page, err := authorizationInstance.ListAuthorizations(654321, models.AuthorizationFilter{}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 0)
+		})
+		Convey("Cards are shown by token without the card number", func() {
+			page, err := authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{Limit: 1}, server.DB)
+			So(err, ShouldBeNil)
+			So(page.Data[0].Card.Token, ShouldEqual, cardToken.Token)
+			So(page.Data[0].Card.BIN, ShouldEqual, "400000")
+			So(page.Data[0].Card.Last4, ShouldEqual, "0119")
+		})
+	})
+}
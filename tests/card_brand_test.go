@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDetectCardBrand(t *testing.T) {
+	brands := map[string]constants.CardBrand{
+		"4000000000000119": constants.Visa,
+		"5555555555554444": constants.Mastercard,
+		"2223003122003222": constants.Mastercard,
+		"378282246310005":  constants.Amex,
+		"6011111111111117": constants.Discover,
+		"3530111333300000": constants.JCB,
+		"6200000000000005": constants.UnionPay,
+		"36227206271667":   constants.Diners,
+		"6759649826438453": constants.Maestro,
+	}
+
+	Convey("When I detect the brand of a card number..", t, func() {
+		Convey("The BIN table identifies every brand", func() {
+			for number, expected := range brands {
+				brand, err := models.DetectCardBrand(number)
+				So(err, ShouldBeNil)
+				So(brand, ShouldEqual, expected)
+			}
+		})
+		Convey("Unknown prefixes are rejected", func() {
+			_, err := models.DetectCardBrand("9999999999999995")
+			So(err.Error(), ShouldEqual, constants.UnknownCardBrand)
+		})
+	})
+}
+
+func TestCardBrandRules(t *testing.T) {
+	err := refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	amex := models.CardRequest{
+		Number:          "378282246310005",
+		CVV:             "1234",
+		Currency:        "USD",
+		ExpirationMonth: 4,
+		ExpirationYear:  time.Now().Year() + 2,
+	}
+
+	Convey("When a card is registered..", t, func() {
+		Convey("The CVV length of the brand is enforced", func() {
+			invalid := amex
+			invalid.CVV = "123"
+			_, err := cardInstance.RegisterCard(server.DB, testMerchantID, invalid)
+			So(err.Error(), ShouldEqual, constants.InvalidCVV)
+		})
+		Convey("The card number length of the brand is enforced", func() {
+			//Luhn valid but Amex numbers have 15 digits
+			invalid := amex
+			invalid.Number = "3700000000000007"
+			_, err := cardInstance.RegisterCard(server.DB, testMerchantID, invalid)
+			So(err.Error(), ShouldEqual, constants.InvalidCreditCardNumber)
+		})
+		Convey("The brand is stored and returned", func() {
+			card, err := cardInstance.RegisterCard(server.DB, testMerchantID, amex)
+			So(err, ShouldBeNil)
+			So(card.Brand, ShouldEqual, "amex")
+		})
+	})
+}
+
+func TestMerchantAcceptedCardBrands(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	_, _, err = merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Brands"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	Convey("When a merchant restricts the card brands it accepts..", t, func() {
+		Convey("Unknown brands cannot be set", func() {
+			_, err := merchantI.SetAcceptedCardBrands(server.DB, testMerchantID, []string{"visa", "bankcard"})
+			So(err.Error(), ShouldEqual, constants.UnknownCardBrand)
+		})
+		Convey("Cards of other brands are declined", func() {
+			_, err := merchantI.SetAcceptedCardBrands(server.DB, testMerchantID, []string{"mastercard", "amex"})
+			So(err, ShouldBeNil)
+
+			_, err = authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+			So(err.Error(), ShouldEqual, constants.CardBrandNotAccepted)
+		})
+		Convey("Cards of accepted brands are authorized with their brand", func() {
+			_, err := merchantI.SetAcceptedCardBrands(server.DB, testMerchantID, []string{"Visa"})
+			So(err, ShouldBeNil)
+
+			auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+			So(err, ShouldBeNil)
+			So(auth.CardBrand, ShouldEqual, "visa")
+		})
+	})
+}
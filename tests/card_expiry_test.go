@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCardExpiryDates(t *testing.T) {
+	Convey("When I read a card's expiry..", t, func() {
+		Convey("2 and 4 digit years are the same year", func() {
+			So(models.NormalizeExpirationYear(30), ShouldEqual, 2030)
+			So(models.NormalizeExpirationYear(2030), ShouldEqual, 2030)
+		})
+		Convey("The card is valid until the end of its expiration month", func() {
+			So(models.CardExpiresAt(12, 30), ShouldResemble, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))
+		})
+		Convey("The end of the month is in the configured timezone", func() {
+			os.Setenv("CARD_EXPIRY_TIMEZONE", "America/New_York")
+			defer os.Setenv("CARD_EXPIRY_TIMEZONE", "UTC")
+
+			So(models.CardExpiresAt(1, 2030).UTC(), ShouldResemble, time.Date(2030, 2, 1, 5, 0, 0, 0, time.UTC))
+		})
+	})
+}
+
+func TestAuthorizeExpiredCard(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	card, err := addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//the card expired at the end of January 2020
+	err = server.DB.Model(&models.Card{}).Where("id = ?", card.ID).UpdateColumn("expiration_year", 2020).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  20,
+	}
+
+	Convey("When an expired card is authorized..", t, func() {
+		Convey("It is declined as expired", func() {
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+			So(err.Error(), ShouldEqual, constants.CreditCardExpired)
+		})
+		Convey("A different expiry is declined as a mismatch", func() {
+			mismatched := authRequest
+			mismatched.ExpirationYear = 2021
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, mismatched, server.DB)
+			So(err.Error(), ShouldEqual, constants.NoMatchCardExpirationDate)
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCardLockout(t *testing.T) {
+	maxFailures := os.Getenv("CARD_LOCKOUT_MAX_FAILURES")
+	os.Setenv("CARD_LOCKOUT_MAX_FAILURES", "3")
+	defer os.Setenv("CARD_LOCKOUT_MAX_FAILURES", maxFailures)
+
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	wrongCVV := authRequest
+	wrongCVV.CVV = "124"
+	wrongExpiry := authRequest
+	wrongExpiry.ExpirationMonth = 2
+
+	authorizationInstance.RequestAuthorization(testMerchantID, wrongCVV, server.DB)
+	authorizationInstance.RequestAuthorization(testMerchantID, wrongExpiry, server.DB)
+	_, lastFailureErr := authorizationInstance.RequestAuthorization(testMerchantID, wrongCVV, server.DB)
+	locked, lockedErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, otherMerchantErr := authorizationInstance.RequestAuthorization(testMerchantID+1, authRequest, server.DB)
+
+	cardLockoutI := models.NewCardLockoutI()
+	lockouts, listErr := cardLockoutI.ListCardLockouts(server.DB, "", time.Now())
+	if listErr != nil {
+		log.Fatal(listErr)
+	}
+	var unlockErr error
+	if len(lockouts) > 0 {
+		_, unlockErr = cardLockoutI.UnlockCard(server.DB, lockouts[0].ID)
+	}
+	_, unlockedErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, unknownErr := cardLockoutI.UnlockCard(server.DB, 999999)
+
+	Convey("When the card details keep failing verification..", t, func() {
+		Convey("The attempt reaching the threshold still gets the mismatch", func() {
+			So(lastFailureErr.Error(), ShouldEqual, constants.NoMatchCVV)
+		})
+		Convey("The card is locked for the merchant", func() {
+			So(lockedErr.Error(), ShouldEqual, constants.CardLocked)
+			So(locked.DeclineCode, ShouldEqual, "card_locked")
+
+			So(len(lockouts), ShouldEqual, 1)
+			So(lockouts[0].MerchantID, ShouldEqual, testMerchantID)
+			So(lockouts[0].Failures, ShouldEqual, 3)
+			So(lockouts[0].Last4, ShouldEqual, "0119")
+		})
+		Convey("Other merchants can still use the card", func() {
+			So(otherMerchantErr, ShouldBeNil)
+		})
+		Convey("An admin can unlock the card", func() {
+			So(unlockErr, ShouldBeNil)
+			So(unlockedErr, ShouldBeNil)
+			So(unknownErr.Error(), ShouldEqual, constants.CardLockoutNotFound)
+		})
+	})
+}
+
+func TestLockoutOfRegisteredCard(t *testing.T) {
+	maxFailures := os.Getenv("CARD_LOCKOUT_MAX_FAILURES")
+	os.Setenv("CARD_LOCKOUT_MAX_FAILURES", "2")
+	defer os.Setenv("CARD_LOCKOUT_MAX_FAILURES", maxFailures)
+
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshBankAccountTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//the card is registered through the API, the simulated issuer was never told its CVV
+	registeredNumber := "4111111111111111"
+	card, err := cardInstance.RegisterCard(server.DB, testMerchantID, models.CardRequest{
+		Number:          registeredNumber,
+		CVV:             "111",
+		Currency:        "USD",
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.DB.Create(&models.BankAccount{CardID: vault.Fingerprint(registeredNumber), Balance: 10000, Currency: "USD"}).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardToken:       card.Token,
+		Currency:        "USD",
+		CVV:             "999",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	_, firstErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, secondErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, lockedErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+
+	lockouts, listErr := models.NewCardLockoutI().ListCardLockouts(server.DB, card.Token, time.Now())
+	if listErr != nil {
+		log.Fatal(listErr)
+	}
+
+	Convey("When a card registered through the API is authorized with a wrong CVV..", t, func() {
+		Convey("The simulated issuer refuses the CVV", func() {
+			So(firstErr.Error(), ShouldEqual, constants.NoMatchCVV)
+			So(secondErr.Error(), ShouldEqual, constants.NoMatchCVV)
+		})
+		Convey("The failures lock the card", func() {
+			So(lockedErr.Error(), ShouldEqual, constants.CardLocked)
+			So(len(lockouts), ShouldEqual, 1)
+			So(lockouts[0].Failures, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCardManagement(t *testing.T) {
+	err := refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cardRequest := models.CardRequest{
+		Number:          "4000000000000259",
+		CVV:             "453",
+		Currency:        "bgn",
+		ExpirationMonth: 4,
+		ExpirationYear:  time.Now().Year() + 2,
+	}
+
+	card, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When a merchant registers a card..", t, func() {
+		Convey("A token and the masked number are returned", func() {
+			So(card.Token, ShouldStartWith, "card_")
+			So(card.Number, ShouldEqual, "400000******0259")
+			So(card.Currency, ShouldEqual, "BGN")
+		})
+		Convey("Registering it again returns the same token", func() {
+			again, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+			So(err, ShouldBeNil)
+			So(again.Token, ShouldEqual, card.Token)
+		})
+		Convey("Invalid cards are rejected", func() {
+			invalid := cardRequest
+			invalid.Number = "4000000000000258"
+			_, err := cardInstance.RegisterCard(server.DB, testMerchantID, invalid)
+			So(err.Error(), ShouldEqual, constants.InvalidCreditCardNumber)
+
+			invalid = cardRequest
+			invalid.ExpirationYear = time.Now().Year() - 1
+			_, err = cardInstance.RegisterCard(server.DB, testMerchantID, invalid)
+			So(err.Error(), ShouldEqual, constants.CreditCardExpired)
+		})
+		Convey("Only the merchant's cards are listed", func() {
+			cards, err := cardInstance.ListCards(server.DB, testMerchantID)
+			So(err, ShouldBeNil)
+			So(len(cards), ShouldEqual, 1)
+			So(cards[0].Number, ShouldEqual, "400000******0259")
+
+			cards, err = cardInstance.ListCards(server.DB, 654321)
+			So(err, ShouldBeNil)
+			So(len(cards), ShouldEqual, 0)
+		})
+		Convey("The expiry can be updated", func() {
+			updated, err := cardInstance.UpdateCardExpiry(server.DB, testMerchantID, card.Token, 12, time.Now().Year()+3)
+			So(err, ShouldBeNil)
+			So(updated.ExpirationMonth, ShouldEqual, 12)
+
+			_, err = cardInstance.UpdateCardExpiry(server.DB, testMerchantID, card.Token, 13, time.Now().Year()+3)
+			So(err.Error(), ShouldEqual, constants.InvalidMonth)
+		})
+		Convey("A deleted token is not brought back, registering the card again issues a new one", func() {
+			So(cardInstance.DeleteCard(server.DB, testMerchantID, card.Token), ShouldBeNil)
+			_, err := cardInstance.FindCardByToken(server.DB, testMerchantID, card.Token)
+			So(err.Error(), ShouldEqual, constants.CardTokenNotFound)
+
+			cardRequest.ExpirationMonth, cardRequest.ExpirationYear = 4, time.Now().Year()+2
+			again, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+			So(err, ShouldBeNil)
+			So(again.Token, ShouldNotEqual, card.Token)
+
+			_, err = cardInstance.FindCardByToken(server.DB, testMerchantID, card.Token)
+			So(err.Error(), ShouldEqual, constants.CardTokenNotFound)
+		})
+		Convey("A wiped card cannot be revealed", func() {
+			cards, err := cardInstance.ListCards(server.DB, testMerchantID)
+			So(err, ShouldBeNil)
+			So(len(cards), ShouldEqual, 1)
+			So(cardInstance.WipeCard(server.DB, testMerchantID, cards[0].Token), ShouldBeNil)
+
+			wiped, err := cardInstance.FindCardByNumber(server.DB, cardRequest.Number)
+			So(err, ShouldBeNil)
+			So(wiped.EncryptedPAN, ShouldBeEmpty)
+			_, err = cardInstance.RevealNumber(wiped)
+			So(err.Error(), ShouldEqual, constants.CardWiped)
+
+			_, err = cardInstance.FindCardByToken(server.DB, testMerchantID, cards[0].Token)
+			So(err.Error(), ShouldEqual, constants.CardWiped)
+		})
+	})
+}
+
+func TestCardSharedByMerchants(t *testing.T) {
+	err := refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	otherMerchantID := uint32(654321)
+	expirationYear := time.Now().Year() + 2
+	cardRequest := models.CardRequest{
+		Number:          "4000000000000259",
+		CVV:             "453",
+		Currency:        "BGN",
+		ExpirationMonth: 4,
+		ExpirationYear:  expirationYear,
+	}
+
+	first, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	other, err := cardInstance.RegisterCard(server.DB, otherMerchantID, cardRequest)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, updateErr := cardInstance.UpdateCardExpiry(server.DB, testMerchantID, first.Token, 12, expirationYear+1)
+	firstCard, firstErr := cardInstance.FindCardByToken(server.DB, testMerchantID, first.Token)
+	otherCard, otherErr := cardInstance.FindCardByToken(server.DB, otherMerchantID, other.Token)
+
+	wrongExpiry := cardRequest
+	wrongExpiry.ExpirationMonth = 5
+	_, wrongExpiryErr := cardInstance.RegisterCard(server.DB, otherMerchantID, wrongExpiry)
+
+	wipeErr := cardInstance.WipeCard(server.DB, testMerchantID, first.Token)
+	afterWipe, afterWipeErr := cardInstance.FindCardByToken(server.DB, otherMerchantID, other.Token)
+	var afterWipeNumber string
+	if afterWipeErr == nil {
+		afterWipeNumber, afterWipeErr = cardInstance.RevealNumber(afterWipe)
+	}
+
+	lastWipeErr := cardInstance.WipeCard(server.DB, otherMerchantID, other.Token)
+	wiped, wipedErr := cardInstance.FindCardByNumber(server.DB, cardRequest.Number)
+
+	restored, restoreErr := cardInstance.RegisterCard(server.DB, otherMerchantID, wrongExpiry)
+	restoredCard, restoredCardErr := cardInstance.FindCardByNumber(server.DB, cardRequest.Number)
+
+	Convey("When several merchants use the same card..", t, func() {
+		Convey("Each merchant gets its own token", func() {
+			So(other.Token, ShouldNotEqual, first.Token)
+		})
+		Convey("An expiry update only changes the merchant's token", func() {
+			So(updateErr, ShouldBeNil)
+			So(firstErr, ShouldBeNil)
+			So(firstCard.ExpirationMonth, ShouldEqual, 12)
+			So(otherErr, ShouldBeNil)
+			So(otherCard.ExpirationMonth, ShouldEqual, 4)
+		})
+		Convey("A registration must match the stored expiry", func() {
+			So(wrongExpiryErr.Error(), ShouldEqual, constants.NoMatchCardExpirationDate)
+		})
+		Convey("A wipe only removes the merchant's token while others still use the card", func() {
+			So(wipeErr, ShouldBeNil)
+			So(afterWipeErr, ShouldBeNil)
+			So(afterWipeNumber, ShouldEqual, cardRequest.Number)
+		})
+		Convey("The number is removed once the last token is wiped", func() {
+			So(lastWipeErr, ShouldBeNil)
+			So(wipedErr, ShouldBeNil)
+			So(wiped.EncryptedPAN, ShouldBeEmpty)
+		})
+		Convey("Registering a wiped card again restores it with the merchant's expiry and keeps the stored details", func() {
+			So(restoreErr, ShouldBeNil)
+			So(restored.Token, ShouldNotEqual, other.Token)
+			So(restored.ExpirationMonth, ShouldEqual, 5)
+			So(restoredCardErr, ShouldBeNil)
+			So(restoredCard.EncryptedPAN, ShouldNotBeEmpty)
+			So(restoredCard.ExpirationMonth, ShouldEqual, 4)
+		})
+	})
+}
+
+func TestCardReissue(t *testing.T) {
+	err := refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	otherMerchantID := uint32(654321)
+	expirationYear := time.Now().Year() + 2
+	cardRequest := models.CardRequest{
+		Number:          "4000000000000259",
+		CVV:             "453",
+		Currency:        "BGN",
+		ExpirationMonth: 4,
+		ExpirationYear:  expirationYear,
+	}
+	reissued := cardRequest
+	reissued.ExpirationMonth, reissued.ExpirationYear = 9, expirationYear+3
+
+	//the merchant updates the expiry of the reissued card, deletes its token and registers the card again
+	first, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, updateErr := cardInstance.UpdateCardExpiry(server.DB, testMerchantID, first.Token, reissued.ExpirationMonth, reissued.ExpirationYear)
+	deleteErr := cardInstance.DeleteCard(server.DB, testMerchantID, first.Token)
+	again, againErr := cardInstance.RegisterCard(server.DB, testMerchantID, reissued)
+	other, otherErr := cardInstance.RegisterCard(server.DB, otherMerchantID, reissued)
+
+	stored, err := cardInstance.FindCardByNumber(server.DB, cardRequest.Number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var failures int
+	server.DB.Model(&models.CardVerificationFailure{}).Where("card_id = ?", stored.ID).Count(&failures)
+
+	Convey("When a card was reissued with a new expiry..", t, func() {
+		Convey("The merchant registers it again with the new expiry after deleting its token", func() {
+			So(updateErr, ShouldBeNil)
+			So(deleteErr, ShouldBeNil)
+			So(againErr, ShouldBeNil)
+			So(again.Token, ShouldNotEqual, first.Token)
+			So(again.ExpirationMonth, ShouldEqual, reissued.ExpirationMonth)
+		})
+		Convey("Another merchant registers it with the new expiry", func() {
+			So(otherErr, ShouldBeNil)
+			So(other.ExpirationMonth, ShouldEqual, reissued.ExpirationMonth)
+		})
+		Convey("No verification failure is counted", func() {
+			So(failures, ShouldEqual, 0)
+		})
+	})
+}
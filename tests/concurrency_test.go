@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConcurrentCaptures(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	const workers = 20
+	const amount = models.Money(300)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := authorizationInstance.Capture(testMerchantID, auth.ID, amount, "USD", false, server.DB); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	Convey("When I call Capture in parallel..", t, func() {
+		captured, err := authorizationInstance.FindAuthorizationByID(testMerchantID, auth.ID, server.DB)
+		So(err, ShouldBeNil)
+
+		ba, err := bankAccountInstance.FindBankAccountByCardID(server.DB, vault.Fingerprint(testCardNumber))
+		So(err, ShouldBeNil)
+
+		Convey("The captured balance should never go past the authorized balance", func() {
+			So(succeeded, ShouldEqual, 3)
+			So(captured.BalanceCaptured, ShouldEqual, models.Money(succeeded)*amount)
+			So(captured.BalanceCaptured, ShouldBeLessThanOrEqualTo, captured.BalanceAuthorised)
+		})
+		Convey("The bank account should match the authorization", func() {
+			So(ba.Balance, ShouldEqual, 10000-captured.BalanceCaptured)
+			So(ba.BalanceAuthorised, ShouldEqual, captured.BalanceAuthorised-captured.BalanceCaptured)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeclinedAuthorizations(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	_, err = authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wrongCVV := authRequest
+	wrongCVV.CVV = "124"
+	declined, declineErr := authorizationInstance.RequestAuthorization(testMerchantID, wrongCVV, server.DB)
+
+	tooMuch := authRequest
+	tooMuch.Amount = 1000000
+	_, fundsErr := authorizationInstance.RequestAuthorization(testMerchantID, tooMuch, server.DB)
+
+	invalidAmount := authRequest
+	invalidAmount.Amount = 0
+	_, amountErr := authorizationInstance.RequestAuthorization(testMerchantID, invalidAmount, server.DB)
+
+	Convey("When an authorization request is declined..", t, func() {
+		Convey("It is stored as a declined authorization with the reason", func() {
+			So(declineErr.Error(), ShouldEqual, constants.NoMatchCVV)
+			So(declined.ID, ShouldNotBeEmpty)
+			So(declined.Status, ShouldEqual, constants.AuthStatus(constants.Declined).String())
+			So(declined.DeclineCode, ShouldEqual, "incorrect_cvv")
+			So(declined.AmountRequested, ShouldEqual, 1000)
+			So(declined.BalanceAuthorised, ShouldEqual, 0)
+
+			found, err := authorizationInstance.FindAuthorizationByID(testMerchantID, declined.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(found.CardToken, ShouldStartWith, "card_")
+		})
+		Convey("Declines can be listed by reason", func() {
+			So(fundsErr.Error(), ShouldEqual, constants.AmountExeedsBalance)
+
+			page, err := authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{
+				Status: constants.AuthStatus(constants.Declined).String(),
+			}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 2)
+
+			page, err = authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{DeclineCode: "insufficient_funds"}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 1)
+		})
+		Convey("Invalid requests are not stored", func() {
+			So(amountErr.Error(), ShouldEqual, constants.InvalidAmount)
+		})
+		Convey("The approval rate counts approved and declined attempts", func() {
+			report, err := authorizationInstance.ApprovalRates(models.ApprovalRateFilter{MerchantID: testMerchantID}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(report.Merchants), ShouldEqual, 1)
+
+			rate := report.Merchants[0]
+			So(rate.Attempts, ShouldEqual, 3)
+			So(rate.Approved, ShouldEqual, 1)
+			So(rate.Declined, ShouldEqual, 2)
+			So(rate.ApprovalRate, ShouldAlmostEqual, 1.0/3.0, 0.0001)
+			So(len(rate.Declines), ShouldEqual, 2)
+			So(rate.Declines[0].Share, ShouldEqual, 0.5)
+		})
+	})
+}
+
+func TestCrossCurrencyDecline(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//1 EUR = 2 USD, the 100 EUR asked for are 200 USD on a card with 100 USD
+	models.SetFXRateProvider(models.NewStaticFXRateProvider(models.StaticFXRates{
+		Base:  "USD",
+		Rates: map[string]float64{"USD": 1, "EUR": 0.5},
+	}))
+	defer models.SetFXRateProvider(models.NewStaticFXRateProvider(models.DefaultFXRates))
+
+	declined, declineErr := authorizationInstance.RequestAuthorization(testMerchantID, models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "EUR",
+		CVV:             "123",
+		Amount:          10000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}, server.DB)
+
+	Convey("When an authorization in another currency than the card's is declined..", t, func() {
+		So(declineErr.Error(), ShouldEqual, constants.AmountExeedsBalance)
+
+		Convey("The amount is recorded in the requested currency", func() {
+			So(declined.AmountRequested, ShouldEqual, 10000)
+			So(declined.CurrencyRequested, ShouldEqual, "EUR")
+			So(declined.CurrencyCard, ShouldEqual, "USD")
+			So(declined.FXRate, ShouldEqual, 2)
+
+			found, err := authorizationInstance.FindAuthorizationByID(testMerchantID, declined.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(found.AmountRequested, ShouldEqual, 10000)
+		})
+	})
+}
+
+func TestDeclineOfWipedCard(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshCardTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cardRequest := models.CardRequest{
+		Number:          "4000000000000259",
+		CVV:             "453",
+		Currency:        "USD",
+		ExpirationMonth: 4,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	card, err := cardInstance.RegisterCard(server.DB, testMerchantID, cardRequest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cardInstance.WipeCard(server.DB, testMerchantID, card.Token); err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      cardRequest.Number,
+		Currency:        "USD",
+		CVV:             "453",
+		Amount:          1000,
+		ExpirationMonth: 4,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	byNumber, byNumberErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	byToken := authRequest
+	byToken.CardNumber, byToken.CardToken = "", card.Token
+	_, byTokenErr := authorizationInstance.RequestAuthorization(testMerchantID, byToken, server.DB)
+	//another merchant never had a token for the card
+	other, otherErr := authorizationInstance.RequestAuthorization(testMerchantID+1, authRequest, server.DB)
+
+	cards, listErr := cardInstance.ListCards(server.DB, testMerchantID)
+	var liveTokens int
+	server.DB.Model(&models.CardToken{}).Where("deleted = ?", false).Count(&liveTokens)
+
+	Convey("When a wiped card is authorized..", t, func() {
+		Convey("It is declined as wiped", func() {
+			So(byNumberErr.Error(), ShouldEqual, constants.CardWiped)
+			So(byTokenErr.Error(), ShouldEqual, constants.CardWiped)
+			So(otherErr.Error(), ShouldEqual, constants.CardWiped)
+		})
+		Convey("The decline keeps the merchant's wiped token", func() {
+			So(byNumber.CardToken, ShouldEqual, card.Token)
+			So(other.CardToken, ShouldBeEmpty)
+		})
+		Convey("No live token is issued for the card", func() {
+			So(listErr, ShouldBeNil)
+			So(len(cards), ShouldEqual, 0)
+			So(liveTokens, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/apierrors"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/middlewares"
+	"github.com/xectich/paymentGateway/models"
+	"github.com/xectich/paymentGateway/responses"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestErrorCodes(t *testing.T) {
+	Convey("When an error is returned to a client..", t, func() {
+		Convey("Domain errors have their own code and status", func() {
+			err := apierrors.From(apierrors.New(constants.AmountExeedsBalance), http.StatusInternalServerError)
+			So(err.Code, ShouldEqual, "insufficient_funds")
+			So(err.Category, ShouldEqual, apierrors.CategoryCard)
+			So(err.Status, ShouldEqual, http.StatusPaymentRequired)
+		})
+		Convey("Errors with a detail keep the code of their prefix", func() {
+			err := apierrors.WithDetail(constants.InvalidQueryParameter, "limit")
+			So(err.Error(), ShouldEqual, constants.InvalidQueryParameter+"limit")
+			So(apierrors.Is(err, constants.InvalidQueryParameter), ShouldBeTrue)
+		})
+		Convey("Other errors get a code from the status", func() {
+			err := apierrors.From(errors.New("unexpected end of JSON input"), http.StatusUnprocessableEntity)
+			So(err.Code, ShouldEqual, "invalid_request_body")
+			So(err.Status, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+	})
+}
+
+func TestErrorResponse(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//authorize sends the request through the request ID middleware the same way the router would
+	authorize := func(body string, requestID string) *httptest.ResponseRecorder {
+		token, err := auth.CreateToken(testMerchantID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mid := strconv.FormatUint(uint64(testMerchantID), 10)
+		req := httptest.NewRequest(http.MethodPut, "/"+mid+"/authorize", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if requestID != "" {
+			req.Header.Set(responses.RequestIDHeader, requestID)
+		}
+		req = mux.SetURLVars(req, map[string]string{"mid": mid})
+		rr := httptest.NewRecorder()
+		middlewares.SetMiddlewareRequestID(http.HandlerFunc(server.RequestAuthorization)).ServeHTTP(rr, req)
+		return rr
+	}
+
+	declined, err := json.Marshal(models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          100000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When an authorization fails..", t, func() {
+		Convey("A decline is returned as 402 with its code", func() {
+			rr := authorize(string(declined), "")
+			So(rr.Code, ShouldEqual, http.StatusPaymentRequired)
+
+			response := responses.ErrorResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+			So(response.Error.Code, ShouldEqual, "insufficient_funds")
+			So(response.Error.Category, ShouldEqual, apierrors.CategoryCard)
+			So(response.Error.Message, ShouldEqual, constants.AmountExeedsBalance)
+			So(response.Error.DocURL, ShouldEndWith, "#insufficient_funds")
+			So(response.Error.RequestID, ShouldStartWith, "req_")
+			So(response.Error.RequestID, ShouldEqual, rr.Header().Get(responses.RequestIDHeader))
+		})
+		Convey("The client's request ID is echoed back", func() {
+			rr := authorize(`{"cardNumber":"4000000000000119"`, "checkout-42")
+
+			response := responses.ErrorResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(response.Error.Code, ShouldEqual, "invalid_request_body")
+			So(response.Error.RequestID, ShouldEqual, "checkout-42")
+		})
+	})
+}
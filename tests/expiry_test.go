@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthorizationExpiry(t *testing.T) {
+	err := refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	_, _, err = merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Test Merchant", AuthorizationTTL: 3600})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          5000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When an authorization is created..", t, func() {
+		Convey("It expires after the merchant's validity window", func() {
+			So(auth.ExpiresAt, ShouldNotBeNil)
+			So(*auth.ExpiresAt, ShouldHappenWithin, time.Minute, time.Now().Add(time.Hour))
+		})
+	})
+
+	//move the expiry into the past instead of waiting for it
+	err = server.DB.Model(&models.Authorization{}).Where("id = ?", auth.ID).UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When an authorization has expired..", t, func() {
+		Convey("It cannot be captured", func() {
+			_, err := authorizationInstance.Capture(testMerchantID, auth.ID, 1000, "USD", false, server.DB)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, constants.AuthorizationExpired)
+		})
+	})
+
+	expired, err := authorizationInstance.ExpireAuthorizations(time.Now(), server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When the sweeper runs..", t, func() {
+		Convey("The stale authorization is expired", func() {
+			So(expired, ShouldEqual, 1)
+
+			found, err := authorizationInstance.FindAuthorizationByID(testMerchantID, auth.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(found.Status, ShouldEqual, constants.AuthStatus(constants.Expired).String())
+		})
+		Convey("Its hold is released", func() {
+			available, err := bankAccountInstance.AvailableBalance(server.DB, vault.Fingerprint(testCardNumber))
+			So(err, ShouldBeNil)
+			So(available, ShouldEqual, 10000)
+		})
+		Convey("And the expiry is recorded in the ledger", func() {
+			transactions, err := models.NewTransactionI().ListTransactions(testMerchantID, auth.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(len(transactions), ShouldEqual, 2)
+			So(transactions[1].Type, ShouldEqual, constants.TransactionType(constants.ExpireTransaction).String())
+			So(transactions[1].Amount, ShouldEqual, 5000)
+		})
+		Convey("And it is not expired twice", func() {
+			again, err := authorizationInstance.ExpireAuthorizations(time.Now(), server.DB)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAuthorizationExpiryBackfill(t *testing.T) {
+	err := refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	_, _, err = merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Test Merchant", AuthorizationTTL: 3600})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ttl := os.Getenv("AUTHORIZATION_TTL")
+	os.Setenv("AUTHORIZATION_TTL", "48h")
+	defer os.Setenv("AUTHORIZATION_TTL", ttl)
+
+	//authorizations stored before expiry existed, of a merchant with its own window and of one without
+	createdAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	withTTL := models.Authorization{ID: ksuid.New().String(), MerchantID: testMerchantID, CurrencyRequested: "USD", CurrencyCard: "USD", Status: constants.AuthStatus(constants.Authorized).String(), CreatedAt: createdAt}
+	withoutTTL := models.Authorization{ID: ksuid.New().String(), MerchantID: 654321, CurrencyRequested: "USD", CurrencyCard: "USD", Status: constants.AuthStatus(constants.Authorized).String(), CreatedAt: createdAt}
+	for _, auth := range []*models.Authorization{&withTTL, &withoutTTL} {
+		if err = server.DB.Create(auth).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err = models.MigrateSchemaChanges(server.DB); err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When authorizations without an expiry are migrated..", t, func() {
+		Convey("They get the validity window of their merchant", func() {
+			found, err := authorizationInstance.FindAuthorizationByID(testMerchantID, withTTL.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(found.ExpiresAt, ShouldNotBeNil)
+			So(*found.ExpiresAt, ShouldHappenWithin, time.Second, createdAt.Add(time.Hour))
+		})
+		Convey("Or AUTHORIZATION_TTL when their merchant has none", func() {
+			found, err := authorizationInstance.FindAuthorizationByID(654321, withoutTTL.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(found.ExpiresAt, ShouldNotBeNil)
+			So(*found.ExpiresAt, ShouldHappenWithin, time.Second, createdAt.Add(48*time.Hour))
+		})
+	})
+}
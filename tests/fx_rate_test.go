@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStaticFXRateProvider(t *testing.T) {
+	provider := models.NewStaticFXRateProvider(models.StaticFXRates{
+		Base:  "USD",
+		Rates: map[string]float64{"EUR": 0.5, "GBP": 0.25},
+	})
+
+	Convey("When I ask the static provider for a rate..", t, func() {
+		Convey("Cross rates are derived through the base currency", func() {
+			rate, err := provider.Rate("EUR", "GBP")
+			So(err, ShouldBeNil)
+			So(rate.Rate, ShouldEqual, 0.5)
+			So(rate.Source, ShouldEqual, models.FXSourceStatic)
+		})
+		Convey("Unknown currencies are rejected", func() {
+			_, err := provider.Rate("USD", "XYZ")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDBFXRateProvider(t *testing.T) {
+	err := refreshFXRateTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rates := []models.FXRate{
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: 0.90, Source: models.FXSourceDB, EffectiveFrom: time.Now().Add(-48 * time.Hour)},
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: 0.92, Source: models.FXSourceDB, EffectiveFrom: time.Now().Add(-time.Hour)},
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: 0.95, Source: models.FXSourceDB, EffectiveFrom: time.Now().Add(time.Hour)},
+	}
+	for i := range rates {
+		if err = server.DB.Create(&rates[i]).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	provider := models.NewDBFXRateProvider(server.DB)
+
+	Convey("When I ask the DB provider for a rate..", t, func() {
+		Convey("The latest rate already in effect is returned", func() {
+			rate, err := provider.Rate("USD", "EUR")
+			So(err, ShouldBeNil)
+			So(rate.Rate, ShouldEqual, 0.92)
+		})
+		Convey("The inverse pair is used when the pair is missing", func() {
+			rate, err := provider.Rate("EUR", "USD")
+			So(err, ShouldBeNil)
+			So(rate.Rate, ShouldAlmostEqual, 1/0.92)
+		})
+	})
+}
+
+func TestHTTPFXRateProvider(t *testing.T) {
+	calls := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"USD_EUR":0.92}`))
+	}))
+
+	provider := models.NewHTTPFXRateProvider(api.URL+"?q={pair}", "", time.Second, time.Hour, 24*time.Hour)
+
+	Convey("When I ask the HTTP provider for a rate..", t, func() {
+		rate, err := provider.Rate("USD", "EUR")
+		So(err, ShouldBeNil)
+		So(rate.Rate, ShouldEqual, 0.92)
+
+		Convey("Fresh rates are served from the cache", func() {
+			_, err := provider.Rate("USD", "EUR")
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("A stale rate is served when the API is down", func() {
+			api.Close()
+			provider.CacheTTL = 0
+			rate, err := provider.Rate("USD", "EUR")
+			So(err, ShouldBeNil)
+			So(rate.Rate, ShouldEqual, 0.92)
+			So(rate.Source, ShouldEqual, models.FXSourceStale)
+		})
+	})
+}
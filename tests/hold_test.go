@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultipleHoldsOnOneCard(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          5000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	first, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest.Amount = 3000
+	second, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When a card has several authorizations..", t, func() {
+		Convey("The available balance subtracts every hold", func() {
+			available, err := bankAccountInstance.AvailableBalance(server.DB, vault.Fingerprint(testCardNumber))
+			So(err, ShouldBeNil)
+			So(available, ShouldEqual, 2000)
+		})
+		Convey("An authorization above the available balance is rejected", func() {
+			authRequest.Amount = 2001
+			_, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Voiding one authorization only releases its own hold", func() {
+			_, err := authorizationInstance.Void(testMerchantID, first.ID, server.DB)
+			So(err, ShouldBeNil)
+
+			available, err := bankAccountInstance.AvailableBalance(server.DB, vault.Fingerprint(testCardNumber))
+			So(err, ShouldBeNil)
+			So(available, ShouldEqual, 7000)
+
+			captured, err := authorizationInstance.Capture(testMerchantID, second.ID, 3000, "USD", false, server.DB)
+			So(err, ShouldBeNil)
+			So(captured.BalanceCaptured, ShouldEqual, 3000)
+
+			ba, err := bankAccountInstance.FindBankAccountByCardID(server.DB, vault.Fingerprint(testCardNumber))
+			So(err, ShouldBeNil)
+			So(ba.Balance, ShouldEqual, 7000)
+			So(ba.BalanceAuthorised, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/middlewares"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	err := refreshIdempotencyKeyTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	_, _, err = merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: 123456, Name: "Test Merchant"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := auth.CreateToken(123456)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	calls := 0
+	handler := middlewares.SetMiddlewareIdempotency(server.DB, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"first"}`))
+	})
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/123456/capture", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(middlewares.IdempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	Convey("When I retry a request with the same Idempotency-Key..", t, func() {
+		first := send("key-1", `{"id":"abc","amount":5}`)
+		second := send("key-1", `{"id":"abc","amount":5}`)
+		Convey("The handler should only run once and the response should be replayed", func() {
+			So(calls, ShouldEqual, 1)
+			So(second.Code, ShouldEqual, first.Code)
+			So(second.Body.String(), ShouldEqual, first.Body.String())
+			So(second.Header().Get(middlewares.IdempotentReplayedHeader), ShouldEqual, "true")
+		})
+		Convey("And the payload differs the request should be rejected", func() {
+			rr := send("key-1", `{"id":"abc","amount":6}`)
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(calls, ShouldEqual, 1)
+		})
+		Convey("And the merchant was disabled the response should not be replayed", func() {
+			_, err := merchantI.SetDisabled(server.DB, 123456, true)
+			So(err, ShouldBeNil)
+
+			rr := send("key-1", `{"id":"abc","amount":5}`)
+			So(rr.Code, ShouldEqual, http.StatusForbidden)
+			So(rr.Header().Get(middlewares.IdempotentReplayedHeader), ShouldEqual, "")
+
+			_, err = merchantI.SetDisabled(server.DB, 123456, false)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestIdempotencyKeyLeaseAndExpiry(t *testing.T) {
+	err := refreshIdempotencyKeyTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, _, err = models.NewMerchantI().CreateMerchant(server.DB, models.MerchantRequest{ID: 123456, Name: "Test Merchant"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := auth.CreateToken(123456)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idempotencyI := models.NewIdempotencyKeyI()
+	reserve := func(key string) (*models.IdempotencyKey, bool, error) {
+		return idempotencyI.ReserveIdempotencyKey(server.DB, 123456, key, "hash", time.Hour, time.Hour)
+	}
+
+	//a reservation whose lease ran out is taken over by the next retry
+	leased, _, err := reserve("lease-1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, inProgress, inProgressErr := reserve("lease-1")
+	server.DB.Model(&models.IdempotencyKey{}).Where("id = ?", leased.ID).UpdateColumn("reserved_until", time.Now().Add(-time.Second))
+	takenOver, tookOver, takeOverErr := reserve("lease-1")
+	_, secondTakeOver, _ := reserve("lease-1")
+
+	//an expired key is purged and can be reserved again
+	expired, _, err := reserve("expired-1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.DB.Model(&models.IdempotencyKey{}).Where("id = ?", expired.ID).UpdateColumn("expires_at", time.Now().Add(-time.Second))
+	purged, purgeErr := idempotencyI.PurgeExpiredIdempotencyKeys(server.DB, time.Now())
+	var remaining int
+	server.DB.Model(&models.IdempotencyKey{}).Where("key = ?", "expired-1").Count(&remaining)
+
+	//a handler that panicked gives the key back
+	calls := 0
+	handler := middlewares.SetMiddlewareIdempotency(server.DB, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func() (rr *httptest.ResponseRecorder, panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		req := httptest.NewRequest(http.MethodPost, "/123456/capture", bytes.NewBufferString(`{"id":"abc"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(middlewares.IdempotencyKeyHeader, "panic-1")
+		rr = httptest.NewRecorder()
+		handler(rr, req)
+		return rr, false
+	}
+	_, panicked := send()
+	retried, _ := send()
+
+	Convey("When an idempotency key was reserved..", t, func() {
+		Convey("A retry during the lease is still in progress", func() {
+			So(inProgressErr, ShouldBeNil)
+			So(inProgress, ShouldBeFalse)
+		})
+		Convey("A retry after the lease ran out takes the key over once", func() {
+			So(takeOverErr, ShouldBeNil)
+			So(tookOver, ShouldBeTrue)
+			So(takenOver.ID, ShouldEqual, leased.ID)
+			So(secondTakeOver, ShouldBeFalse)
+		})
+		Convey("Expired keys are purged", func() {
+			So(purgeErr, ShouldBeNil)
+			So(purged, ShouldEqual, 1)
+			So(remaining, ShouldEqual, 0)
+		})
+		Convey("A key whose handler panicked can be retried", func() {
+			So(panicked, ShouldBeTrue)
+			So(retried.Code, ShouldEqual, http.StatusCreated)
+			So(calls, ShouldEqual, 2)
+		})
+	})
+}
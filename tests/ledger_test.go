@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransactionLedger(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = authorizationInstance.Capture(testMerchantID, auth.ID, 500, "USD", false, server.DB); err != nil {
+		log.Fatal(err)
+	}
+	if auth, err = authorizationInstance.Refund(testMerchantID, auth.ID, 200, "USD", false, server.DB); err != nil {
+		log.Fatal(err)
+	}
+
+	transactionI := models.NewTransactionI()
+
+	Convey("When I list the transactions of an authorization..", t, func() {
+		transactions, err := transactionI.ListTransactions(testMerchantID, auth.ID, server.DB)
+		So(err, ShouldBeNil)
+
+		Convey("Every operation is recorded in order", func() {
+			So(len(transactions), ShouldEqual, 3)
+			So(transactions[0].Type, ShouldEqual, constants.TransactionType(constants.AuthorizeTransaction).String())
+			So(transactions[1].Type, ShouldEqual, constants.TransactionType(constants.CaptureTransaction).String())
+			So(transactions[1].Amount, ShouldEqual, 500)
+			So(transactions[2].Type, ShouldEqual, constants.TransactionType(constants.RefundTransaction).String())
+			So(transactions[2].Amount, ShouldEqual, 200)
+		})
+		Convey("The balances derived from the ledger match the authorization", func() {
+			balances, err := transactionI.Balances(auth.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(balances.Authorised, ShouldEqual, auth.BalanceAuthorised)
+			So(balances.Captured, ShouldEqual, auth.BalanceCaptured)
+			So(balances.Refunded, ShouldEqual, auth.BalanceRefunded)
+		})
+		Convey("Another merchant cannot list them", func() {
+			transactions, err := transactionI.ListTransactions(654321, auth.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(len(transactions), ShouldEqual, 0)
+		})
+	})
+
+	Convey("When the aggregate balances no longer match the ledger..", t, func() {
+		err := server.DB.Model(&models.Authorization{}).Where("id = ?", auth.ID).UpdateColumn("balance_captured", 0).Error
+		So(err, ShouldBeNil)
+
+		_, err = authorizationInstance.Capture(testMerchantID, auth.ID, 100, "USD", false, server.DB)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, constants.LedgerMismatch)
+	})
+}
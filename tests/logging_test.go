@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/logger"
+	"github.com/xectich/paymentGateway/middlewares"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	l := logger.New(&out, constants.LogInfo).With(logger.Fields{"request_id": "req_1"})
+
+	l.Debug("hidden")
+	l.Info("authorizing", logger.Fields{"cardNumber": "4000000000000119", "cvv": "123", "amount": 1000})
+	l.Warn(`bad request {"number":"4000 0000 0000 0119","cvv":"123"}`)
+	l.Error("invalid card number 4000000000000118 for order 123456789012")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	var first map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &first)
+
+	Convey("When logging..", t, func() {
+		Convey("Lines below the level are dropped", func() {
+			So(len(lines), ShouldEqual, 3)
+			So(out.String(), ShouldNotContainSubstring, "hidden")
+		})
+		Convey("Lines are JSON with the logger's fields", func() {
+			So(first["level"], ShouldEqual, "info")
+			So(first["msg"], ShouldEqual, "authorizing")
+			So(first["request_id"], ShouldEqual, "req_1")
+			So(first["amount"], ShouldEqual, 1000)
+		})
+		Convey("Card numbers and CVVs are redacted", func() {
+			So(first["cardNumber"], ShouldEqual, "[REDACTED]")
+			So(first["cvv"], ShouldEqual, "[REDACTED]")
+			So(lines[1], ShouldNotContainSubstring, "4000 0000 0000 0119")
+			So(lines[1], ShouldContainSubstring, "************0119")
+			So(lines[1], ShouldNotContainSubstring, `123`)
+		})
+		Convey("Card numbers failing the Luhn check are redacted too", func() {
+			So(lines[2], ShouldNotContainSubstring, "4000000000000118")
+			So(lines[2], ShouldContainSubstring, "************0118")
+		})
+		Convey("Shorter numbers are kept", func() {
+			So(lines[2], ShouldContainSubstring, "123456789012")
+		})
+	})
+}
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	previous := logger.Default()
+	logger.SetDefault(logger.New(&out, constants.LogInfo))
+	defer logger.SetDefault(previous)
+
+	router := mux.NewRouter()
+	router.Use(middlewares.SetMiddlewareRequestID)
+	router.Use(middlewares.SetMiddlewareLogging)
+	router.HandleFunc("/{mid}/authorizations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/123456/authorizations/abc", nil)
+	req.Header.Set("X-Request-Id", "req_trace")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	var handling, access map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &handling)
+	json.Unmarshal([]byte(lines[len(lines)-1]), &access)
+
+	Convey("When a request is served..", t, func() {
+		Convey("The handler logs with the request ID", func() {
+			So(handling["request_id"], ShouldEqual, "req_trace")
+		})
+		Convey("An access log line records the route, status and latency", func() {
+			So(access["msg"], ShouldEqual, "request")
+			So(access["level"], ShouldEqual, "warn")
+			So(access["request_id"], ShouldEqual, "req_trace")
+			So(access["route"], ShouldEqual, "GET /{mid}/authorizations/{id}")
+			So(access["status"], ShouldEqual, 404)
+			So(access["latency_ms"], ShouldNotBeNil)
+		})
+	})
+}
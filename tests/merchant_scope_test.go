@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCrossMerchantAccess(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	owner, _, err := merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Owner"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	other, _, err := merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: 654321, Name: "Other"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	authorization, err := authorizationInstance.RequestAuthorization(owner.ID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//send calls the handler the same way the router would for the merchant
+	send := func(handler http.HandlerFunc, merchantID uint32, body string) *httptest.ResponseRecorder {
+		token, err := auth.CreateToken(merchantID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mid := strconv.FormatUint(uint64(merchantID), 10)
+		req := httptest.NewRequest(http.MethodPost, "/"+mid+"/capture", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = mux.SetURLVars(req, map[string]string{"mid": mid})
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	Convey("When another merchant uses the authorization ID..", t, func() {
+		Convey("The model reports it as not found", func() {
+			_, err := authorizationInstance.FindAuthorizationByID(other.ID, authorization.ID, server.DB)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, constants.AuthorizationNotFound)
+
+			_, err = authorizationInstance.Capture(other.ID, authorization.ID, 500, "USD", false, server.DB)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, constants.AuthorizationNotFound)
+		})
+		Convey("Capture, refund and void return 404", func() {
+			body := `{"id":"` + authorization.ID + `","amount":500}`
+			So(send(server.Capture, other.ID, body).Code, ShouldEqual, http.StatusNotFound)
+			So(send(server.Refund, other.ID, body).Code, ShouldEqual, http.StatusNotFound)
+			So(send(server.Void, other.ID, body).Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("The owner can still capture it", func() {
+			body := `{"id":"` + authorization.ID + `","amount":500}`
+			So(send(server.Capture, owner.ID, body).Code, ShouldEqual, http.StatusCreated)
+		})
+	})
+}
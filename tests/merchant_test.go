@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMerchantCredentials(t *testing.T) {
+	err := refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	merchant, apiKey, err := merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: 123456, Name: "Test Merchant"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When I verify merchant credentials..", t, func() {
+		Convey("The API key is not stored in plain text", func() {
+			So(merchant.APIKeyHash, ShouldNotEqual, apiKey)
+			So(merchant.APIKeyHash, ShouldNotContainSubstring, apiKey)
+		})
+		Convey("And the API key matches", func() {
+			_, err := merchantI.VerifyCredentials(server.DB, merchant.ID, apiKey)
+			So(err, ShouldBeNil)
+		})
+		Convey("And the API key is wrong", func() {
+			_, err := merchantI.VerifyCredentials(server.DB, merchant.ID, "sk_wrong")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("And the merchant does not exist", func() {
+			_, err := merchantI.VerifyCredentials(server.DB, 1, apiKey)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("When I rotate the API key..", t, func() {
+		_, newKey, err := merchantI.RotateAPIKey(server.DB, merchant.ID)
+		So(err, ShouldBeNil)
+
+		_, err = merchantI.VerifyCredentials(server.DB, merchant.ID, apiKey)
+		So(err, ShouldNotBeNil)
+
+		_, err = merchantI.VerifyCredentials(server.DB, merchant.ID, newKey)
+		So(err, ShouldBeNil)
+		apiKey = newKey
+	})
+
+	Convey("When I disable the merchant..", t, func() {
+		_, err := merchantI.SetDisabled(server.DB, merchant.ID, true)
+		So(err, ShouldBeNil)
+
+		_, err = merchantI.VerifyCredentials(server.DB, merchant.ID, apiKey)
+		So(err, ShouldNotBeNil)
+
+		Convey("And enable it again", func() {
+			_, err := merchantI.SetDisabled(server.DB, merchant.ID, false)
+			So(err, ShouldBeNil)
+
+			_, err = merchantI.VerifyCredentials(server.DB, merchant.ID, apiKey)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/metrics"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+//scrapeMetrics returns what /metrics would answer
+func scrapeMetrics() string {
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return rr.Body.String()
+}
+
+//metricValue returns the value of the sample, 0 if it was not written yet, labels are written ordered by name
+func metricValue(body string, sample string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, sample+" ") {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, sample+" "), 64)
+			if err != nil {
+				return 0
+			}
+			return value
+		}
+	}
+	return 0
+}
+
+func TestMetricsFormat(t *testing.T) {
+	metrics.FXLookupFailures.WithLabelValues("test", `bad "code"`).Inc()
+	metrics.FXLookupDuration.WithLabelValues("test").Observe(0.003)
+	body := scrapeMetrics()
+
+	Convey("When the metrics are scraped..", t, func() {
+		Convey("Every metric has its type", func() {
+			So(body, ShouldContainSubstring, "# TYPE gateway_fx_lookup_failures_total counter")
+			So(body, ShouldContainSubstring, "# TYPE gateway_fx_lookup_duration_seconds histogram")
+		})
+		Convey("Label values are escaped", func() {
+			So(metricValue(body, `gateway_fx_lookup_failures_total{code="bad \"code\"",provider="test"}`), ShouldBeGreaterThanOrEqualTo, 1)
+		})
+		Convey("Histograms have cumulative buckets, a sum and a count", func() {
+			So(metricValue(body, `gateway_fx_lookup_duration_seconds_bucket{provider="test",le="0.0025"}`), ShouldEqual, 0)
+			So(metricValue(body, `gateway_fx_lookup_duration_seconds_bucket{provider="test",le="0.005"}`), ShouldEqual, 1)
+			So(metricValue(body, `gateway_fx_lookup_duration_seconds_bucket{provider="test",le="+Inf"}`), ShouldEqual, 1)
+			So(metricValue(body, `gateway_fx_lookup_duration_seconds_count{provider="test"}`), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestOperationMetrics(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authorize := func(amount int64, currency string) *httptest.ResponseRecorder {
+		token, err := auth.CreateToken(testMerchantID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		body, err := json.Marshal(models.AuthorizationRequest{
+			CardNumber:      testCardNumber,
+			Currency:        currency,
+			CVV:             "123",
+			Amount:          models.Money(amount),
+			ExpirationMonth: 1,
+			ExpirationYear:  testCardExpirationYear,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		mid := strconv.FormatUint(uint64(testMerchantID), 10)
+		req := httptest.NewRequest(http.MethodPut, "/"+mid+"/authorize", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = mux.SetURLVars(req, map[string]string{"mid": mid})
+		rr := httptest.NewRecorder()
+		server.RequestAuthorization(rr, req)
+		return rr
+	}
+
+	approved := `gateway_operations_total{code="",currency="USD",merchant="123456",operation="authorize",outcome="success"}`
+	declined := `gateway_operations_total{code="insufficient_funds",currency="USD",merchant="123456",operation="authorize",outcome="declined"}`
+	before := scrapeMetrics()
+	approvedCode := authorize(1000, "USD").Code
+	declinedCode := authorize(100000, "USD").Code
+	authorize(1000, "ZZZ")
+	authorize(1000, "NOT-A-CURRENCY")
+	after := scrapeMetrics()
+
+	Convey("When authorizations are requested..", t, func() {
+		Convey("Approved and declined requests are answered", func() {
+			So(approvedCode, ShouldEqual, http.StatusCreated)
+			So(declinedCode, ShouldEqual, http.StatusPaymentRequired)
+		})
+		Convey("Approved and declined requests are counted with their code", func() {
+			So(metricValue(after, approved)-metricValue(before, approved), ShouldEqual, 1)
+			So(metricValue(after, declined)-metricValue(before, declined), ShouldEqual, 1)
+		})
+		Convey("Currencies that are not ISO 4217 codes are counted as invalid", func() {
+			So(after, ShouldContainSubstring, `currency="invalid"`)
+			So(after, ShouldNotContainSubstring, `currency="ZZZ"`)
+			So(after, ShouldNotContainSubstring, `currency="NOT-A-CURRENCY"`)
+		})
+		Convey("The open authorizations and their holds are reported", func() {
+			stats, err := authorizationInstance.OpenAuthorizationStats(server.DB)
+			So(err, ShouldBeNil)
+			So(stats.OpenAuthorizations[constants.AuthStatus(constants.Authorized).String()], ShouldEqual, 1)
+			So(stats.HeldBalances["USD"], ShouldEqual, models.Money(1000))
+		})
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"log"
 	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
@@ -16,10 +17,17 @@ import (
 )
 
 
+const testMerchantID uint32 = 123456
+const testCardNumber = "4000000000000119"
+
+//the test card is sent with a 2 digit expiration year a few years ahead
+var testCardExpirationYear = time.Now().Year()%100 + 3
+
 var server = controllers.Server{}
 var authorizationInstance = models.Authorization{}
 var bankAccountInstance = models.BankAccount{}
 var cardInstance = models.Card{}
+var vault *models.Vault
 
 func TestMain(m *testing.M) {
 	var err error
@@ -29,6 +37,12 @@ func TestMain(m *testing.M) {
 	}
 	Database()
 
+	vault, err = models.NewVaultFromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring the card vault %v\n", err)
+	}
+	models.SetVault(vault)
+
 	os.Exit(m.Run())
 }
 
@@ -48,11 +62,11 @@ func Database() {
 }
 
 func refreshAuthorizationTable() error {
-	err := server.DB.DropTableIfExists(&models.Authorization{}).Error
+	err := server.DB.DropTableIfExists(&models.Authorization{}, &models.Transaction{}, &models.AuthorizationStatusHistory{}, &models.SimulatorScenario{}, &models.RiskRule{}, &models.RiskRuleMatch{}, &models.BINCountry{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.Authorization{}).Error
+	err = server.DB.AutoMigrate(&models.Authorization{}, &models.Transaction{}, &models.AuthorizationStatusHistory{}, &models.Merchant{}, &models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.SimulatorScenario{}, &models.RiskRule{}, &models.RiskRuleMatch{}, &models.BINCountry{}).Error
 	if err != nil {
 		return err
 	}
@@ -61,11 +75,11 @@ func refreshAuthorizationTable() error {
 }
 
 func refreshCardTable() error {
-	err := server.DB.DropTableIfExists(&models.Card{}).Error
+	err := server.DB.DropTableIfExists(&models.Card{}, &models.CardToken{}, &models.CardVerificationFailure{}, &models.CardLockout{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.Card{}).Error
+	err = server.DB.AutoMigrate(&models.Card{}, &models.CardToken{}, &models.CardVerificationFailure{}, &models.CardLockout{}).Error
 	if err != nil {
 		return err
 	}
@@ -74,11 +88,76 @@ func refreshCardTable() error {
 }
 
 func refreshBankAccountTable() error {
-	err := server.DB.DropTableIfExists(&models.BankAccount{}).Error
+	err := server.DB.DropTableIfExists(&models.BankAccount{}, &models.Hold{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.BankAccount{}, &models.Hold{}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully refreshed table")
+	return nil
+}
+
+func refreshIdempotencyKeyTable() error {
+	err := server.DB.DropTableIfExists(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully refreshed table")
+	return nil
+}
+
+func refreshFXRateTable() error {
+	err := server.DB.DropTableIfExists(&models.FXRate{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.FXRate{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.BankAccount{}).Error
+	log.Printf("Successfully refreshed table")
+	return nil
+}
+
+func refreshMerchantTable() error {
+	err := server.DB.DropTableIfExists(&models.Merchant{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.Merchant{}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully refreshed table")
+	return nil
+}
+
+func refreshWebhookTables() error {
+	err := server.DB.DropTableIfExists(&models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.WebhookAttempt{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.WebhookEndpoint{}, &models.WebhookEvent{}, &models.WebhookDelivery{}, &models.WebhookAttempt{}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("Successfully refreshed table")
+	return nil
+}
+
+func refreshRateLimitTable() error {
+	err := server.DB.DropTableIfExists(&models.RateLimitBucket{}).Error
+	if err != nil {
+		return err
+	}
+	err = server.DB.AutoMigrate(&models.RateLimitBucket{}).Error
 	if err != nil {
 		return err
 	}
@@ -92,8 +171,9 @@ func addAuthorization() (models.Authorization, error) {
 
 	auth := models.Authorization{
 		ID:                ksuid.New().String(),
-		CardNumber:        "4000000000000119",
-		BalanceAuthorised: 5,
+		MerchantID:        testMerchantID,
+		CardToken:         "card_test",
+		BalanceAuthorised: 500,
 		BalanceCaptured:   0,
 		CurrencyRequested: "USD",
 		CurrencyCard:      "USD",
@@ -113,8 +193,8 @@ func addBankAccount() (models.BankAccount, error) {
 	refreshBankAccountTable()
 
 	ba := models.BankAccount{
-		CardID: "4000000000000119",
-		Balance: 100,
+		CardID: vault.Fingerprint(testCardNumber),
+		Balance: 10000,
 		Currency: "USD",
 	}
 
@@ -131,16 +211,17 @@ func addCard() (models.Card, error) {
 	refreshCardTable()
 
 	card := models.Card{
-		Number: "4000000000000119",
+		Number: testCardNumber,
 		CVV: "123",
 		Currency: "USD",
 		ExpirationMonth: 1,
-		ExpirationYear: 23,
+		ExpirationYear: testCardExpirationYear,
 	}
 
-	err := server.DB.Model(&models.Card{}).Create(&card).Error
+	stored, err := cardInstance.StoreCard(&card, server.DB)
 	if err != nil {
-		log.Fatalf("cannot add to bank account table: %v", err)
+		log.Fatalf("cannot add to card table: %v", err)
 	}
-	return card, nil
+	models.SetSimulatorCVV(stored.Fingerprint, "123")
+	return *stored, nil
 }
\ No newline at end of file
@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/xectich/paymentGateway/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMoneyConvert(t *testing.T) {
+	Convey("When I convert Money between currencies..", t, func() {
+		Convey("Currencies without minor units are respected", func() {
+			So(models.CurrencyExponent("JPY"), ShouldEqual, 0)
+			So(models.Money(1000).Convert(150.5, "USD", "JPY"), ShouldEqual, 1505)
+		})
+		Convey("Currencies with three decimals are respected", func() {
+			So(models.CurrencyExponent("KWD"), ShouldEqual, 3)
+			So(models.Money(1000).Convert(0.307, "USD", "KWD"), ShouldEqual, 3070)
+		})
+		Convey("The result is rounded to the nearest minor unit", func() {
+			So(models.Money(333).Convert(0.5, "EUR", "GBP"), ShouldEqual, 167)
+			So(models.Money(999).Convert(1.1, "EUR", "USD"), ShouldEqual, 1099)
+		})
+	})
+}
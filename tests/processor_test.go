@@ -0,0 +1,311 @@
+package tests
+
+import (
+	"log"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/mockacquirer"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProcessorRouting(t *testing.T) {
+	router := models.NewProcessorRouter(models.ProcessorRoutes{
+		Routes: []models.ProcessorRoute{
+			{MerchantID: testMerchantID, Currency: "eur", Processor: models.ProcessorHTTP},
+			{CardBrand: "Amex", Processor: models.ProcessorHTTP},
+		},
+	}, models.NewSimulatorProcessor(), models.NewHTTPProcessor("http://localhost:9090", "", time.Second))
+
+	Convey("When an authorization is routed..", t, func() {
+		Convey("The first matching route picks the processor", func() {
+			processor, err := router.Route(testMerchantID, "EUR", "visa")
+			So(err, ShouldBeNil)
+			So(processor.Name(), ShouldEqual, models.ProcessorHTTP)
+
+			processor, err = router.Route(1, "USD", "amex")
+			So(err, ShouldBeNil)
+			So(processor.Name(), ShouldEqual, models.ProcessorHTTP)
+		})
+		Convey("Anything else goes to the simulator", func() {
+			processor, err := router.Route(1, "EUR", "visa")
+			So(err, ShouldBeNil)
+			So(processor.Name(), ShouldEqual, models.ProcessorSimulator)
+
+			processor, err = router.Processor("")
+			So(err, ShouldBeNil)
+			So(processor.Name(), ShouldEqual, models.ProcessorSimulator)
+		})
+		Convey("Unknown processors are refused", func() {
+			_, err := router.Processor("unknown")
+			So(err.Error(), ShouldEqual, constants.UnknownProcessor+"unknown")
+		})
+	})
+}
+
+func TestHTTPProcessor(t *testing.T) {
+	acquirer := httptest.NewServer(mockacquirer.NewAcquirer("acq_key").Handler())
+	defer acquirer.Close()
+
+	processor := models.NewHTTPProcessor(acquirer.URL, "acq_key", time.Second)
+	card := &models.Card{Fingerprint: "fp", Brand: "visa", BIN: "411111", Last4: "1111", ExpirationMonth: 1, ExpirationYear: testCardExpirationYear}
+
+	authorized, authorizeErr := processor.Authorize(nil, models.ProcessorRequest{Card: card, Amount: 1000, Currency: "USD"})
+	_, captureErr := processor.Capture(nil, models.ProcessorRequest{Reference: authorized.Reference, Amount: 600})
+	_, overCaptureErr := processor.Capture(nil, models.ProcessorRequest{Reference: authorized.Reference, Amount: 600})
+	_, refundErr := processor.Refund(nil, models.ProcessorRequest{Reference: authorized.Reference, Amount: 600, Final: true})
+	declined, declineErr := processor.Authorize(nil, models.ProcessorRequest{Card: card, Amount: 1051, Currency: "USD"})
+	_, unknownErr := processor.Void(nil, models.ProcessorRequest{Reference: "acq_unknown"})
+
+	unauthorized := models.NewHTTPProcessor(acquirer.URL, "wrong", time.Second)
+	_, unauthorizedErr := unauthorized.Authorize(nil, models.ProcessorRequest{Card: card, Amount: 1000, Currency: "USD"})
+	unreachable := models.NewHTTPProcessor("http://127.0.0.1:1", "", time.Second)
+	_, unreachableErr := unreachable.Authorize(nil, models.ProcessorRequest{Card: card, Amount: 1000, Currency: "USD"})
+
+	Convey("When operations are sent to the acquirer..", t, func() {
+		Convey("Approved operations return the acquirer's reference", func() {
+			So(authorizeErr, ShouldBeNil)
+			So(authorized.Reference, ShouldStartWith, "acq_")
+			So(authorized.ResponseCode, ShouldEqual, models.ResponseApproved)
+			So(captureErr, ShouldBeNil)
+			So(refundErr, ShouldBeNil)
+		})
+		Convey("Response codes are turned into the gateway's errors", func() {
+			So(overCaptureErr.Error(), ShouldEqual, constants.InvalidAmount)
+			So(declineErr.Error(), ShouldEqual, constants.AmountExeedsBalance)
+			So(declined.ResponseCode, ShouldEqual, models.ResponseInsufficientFunds)
+			So(unknownErr.Error(), ShouldEqual, constants.HoldNotFound)
+		})
+		Convey("An acquirer that does not answer is unavailable", func() {
+			So(unauthorizedErr.Error(), ShouldEqual, constants.ProcessorUnavailable)
+			So(unreachableErr.Error(), ShouldEqual, constants.ProcessorUnavailable)
+		})
+	})
+}
+
+func TestAuthorizationProcessor(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	acquirer := httptest.NewServer(mockacquirer.NewAcquirer("").Handler())
+	defer acquirer.Close()
+	models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{Default: models.ProcessorHTTP},
+		models.NewSimulatorProcessor(), models.NewHTTPProcessor(acquirer.URL, "", time.Second)))
+	defer models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{}, models.NewSimulatorProcessor()))
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	authorized, authorizeErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	captured, captureErr := authorizationInstance.Capture(testMerchantID, authorized.ID, 1000, "USD", false, server.DB)
+
+	doNotHonor := authRequest
+	doNotHonor.Amount = 1005
+	declined, declineErr := authorizationInstance.RequestAuthorization(testMerchantID, doNotHonor, server.DB)
+
+	Convey("When an authorization is handled by the acquirer..", t, func() {
+		Convey("It stores the processor and its reference", func() {
+			So(authorizeErr, ShouldBeNil)
+			So(authorized.Processor, ShouldEqual, models.ProcessorHTTP)
+			So(authorized.ProcessorReference, ShouldStartWith, "acq_")
+			So(authorized.ProcessorResponseCode, ShouldEqual, models.ResponseApproved)
+		})
+		Convey("Later operations go to the same processor", func() {
+			So(captureErr, ShouldBeNil)
+			So(captured.Status, ShouldEqual, constants.AuthStatus(constants.Captured).String())
+			So(captured.ProcessorReference, ShouldEqual, authorized.ProcessorReference)
+		})
+		Convey("Declines of the acquirer are recorded", func() {
+			So(declineErr.Error(), ShouldEqual, constants.ProcessorDeclined)
+			So(declined.DeclineCode, ShouldEqual, "processor_declined")
+			So(declined.Processor, ShouldEqual, models.ProcessorHTTP)
+			So(declined.ProcessorResponseCode, ShouldEqual, models.ResponseDoNotHonor)
+		})
+	})
+}
+
+func TestAuthorizationProcessorTimeout(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//amounts ending in 97 are answered after the processor gave up waiting
+	acquirer := mockacquirer.NewAcquirer("")
+	acquirer.Delay = 300 * time.Millisecond
+	acquirerServer := httptest.NewServer(acquirer.Handler())
+	defer acquirerServer.Close()
+	models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{Default: models.ProcessorHTTP},
+		models.NewSimulatorProcessor(), models.NewHTTPProcessor(acquirerServer.URL, "", 100*time.Millisecond)))
+	defer models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{}, models.NewSimulatorProcessor()))
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1097,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	_, timeoutErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+
+	authRequest.Amount = 2000
+	authorized, authorizeErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, captureTimeoutErr := authorizationInstance.Capture(testMerchantID, authorized.ID, 1097, "USD", false, server.DB)
+	time.Sleep(2 * acquirer.Delay)
+	_, conflictErr := authorizationInstance.Capture(testMerchantID, authorized.ID, 500, "USD", false, server.DB)
+	retried, retryErr := authorizationInstance.Capture(testMerchantID, authorized.ID, 1097, "USD", false, server.DB)
+
+	var stored int
+	err = server.DB.Model(&models.Authorization{}).Count(&stored).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When the acquirer answers after the processor gave up waiting..", t, func() {
+		Convey("The authorization fails and is reversed with the acquirer", func() {
+			So(timeoutErr.Error(), ShouldEqual, constants.ProcessorTimeout)
+			So(authorizeErr, ShouldBeNil)
+			So(stored, ShouldEqual, 1)
+			So(acquirer.Held(), ShouldEqual, models.Money(2000-1097))
+		})
+		Convey("A retried capture is applied by the acquirer only once", func() {
+			So(captureTimeoutErr.Error(), ShouldEqual, constants.ProcessorTimeout)
+			So(retryErr, ShouldBeNil)
+			So(retried.BalanceCaptured, ShouldEqual, models.Money(1097))
+			So(acquirer.Captured(authorized.ID), ShouldEqual, models.Money(1097))
+		})
+		Convey("A different capture in its place is refused", func() {
+			So(conflictErr.Error(), ShouldEqual, constants.ProcessorOperationConflict)
+		})
+	})
+}
+
+//inspectingProcessor is the simulator checking that the gateway holds no lock on the authorization while it is asked
+//an authorization whose ID is in failStore is stored by the processor itself so the gateway cannot store it again
+type inspectingProcessor struct {
+	*models.SimulatorProcessor
+	lockErrs  []error
+	failStore map[uint64]bool
+	voided    []string
+	attempts  uint64
+}
+
+func (p *inspectingProcessor) Authorize(db *gorm.DB, request models.ProcessorRequest) (models.ProcessorResponse, error) {
+	p.attempts++
+	if p.failStore[p.attempts] {
+		server.DB.Exec("INSERT INTO authorizations (id, merchant_id, currency_requested, currency_card, status, balance_captured, balance_authorised, balance_refunded) VALUES (?, ?, 'USD', 'USD', 'Declined', 0, 0, 0)", request.AuthorizationID, request.MerchantID)
+	}
+	return p.SimulatorProcessor.Authorize(db, request)
+}
+
+func (p *inspectingProcessor) Capture(db *gorm.DB, request models.ProcessorRequest) (models.ProcessorResponse, error) {
+	p.lockErrs = append(p.lockErrs, server.DB.Exec("SELECT id FROM authorizations WHERE id = ? FOR UPDATE NOWAIT", request.AuthorizationID).Error)
+	return p.SimulatorProcessor.Capture(db, request)
+}
+
+func (p *inspectingProcessor) Void(db *gorm.DB, request models.ProcessorRequest) (models.ProcessorResponse, error) {
+	p.voided = append(p.voided, request.AuthorizationID)
+	return p.SimulatorProcessor.Void(db, request)
+}
+
+func TestProcessorOutsideTransactions(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lease := os.Getenv("PROCESSOR_OPERATION_LEASE")
+	os.Setenv("PROCESSOR_OPERATION_LEASE", "100ms")
+	defer os.Setenv("PROCESSOR_OPERATION_LEASE", lease)
+
+	processor := &inspectingProcessor{SimulatorProcessor: models.NewSimulatorProcessor(), failStore: map[uint64]bool{2: true}}
+	models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{}, processor))
+	defer models.SetProcessorRouter(models.NewProcessorRouter(models.ProcessorRoutes{}, models.NewSimulatorProcessor()))
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	authorized, authorizeErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	_, captureErr := authorizationInstance.Capture(testMerchantID, authorized.ID, 400, "USD", false, server.DB)
+
+	_, notStoredErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	available, availableErr := bankAccountInstance.AvailableBalance(server.DB, vault.Fingerprint(testCardNumber))
+
+	leasedUntil := time.Now().Add(time.Hour)
+	err = server.DB.Model(&models.Authorization{}).Where("id = ?", authorized.ID).UpdateColumns(map[string]interface{}{"lease_id": "other", "leased_until": leasedUntil}).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, busyErr := authorizationInstance.Void(testMerchantID, authorized.ID, server.DB)
+
+	Convey("When the processor is asked for an operation..", t, func() {
+		Convey("The authorization is not locked while the processor answers", func() {
+			So(authorizeErr, ShouldBeNil)
+			So(captureErr, ShouldBeNil)
+			So(len(processor.lockErrs), ShouldEqual, 1)
+			So(processor.lockErrs[0], ShouldBeNil)
+		})
+		Convey("An authorization that cannot be stored is voided with the processor", func() {
+			So(notStoredErr, ShouldNotBeNil)
+			So(len(processor.voided), ShouldEqual, 1)
+			So(availableErr, ShouldBeNil)
+			So(available, ShouldEqual, models.Money(10000-400-600))
+		})
+		Convey("Another operation waiting for the processor keeps the authorization busy", func() {
+			So(busyErr.Error(), ShouldEqual, constants.AuthorizationBusy)
+		})
+	})
+}
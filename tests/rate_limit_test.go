@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xectich/paymentGateway/auth"
+	"github.com/xectich/paymentGateway/middlewares"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limits := models.RateLimits{
+		Default: &models.RateLimit{Requests: 2, PeriodSeconds: 60},
+		Routes: map[string]models.RateLimit{
+			"POST /login": {Requests: 1, PeriodSeconds: 60},
+		},
+		Merchants: map[uint32]models.MerchantRateLimits{
+			654321: {Default: &models.RateLimit{Requests: 3, PeriodSeconds: 60}},
+		},
+	}
+	limiter := models.NewRateLimiter(models.NewMemoryRateLimitStore(), limits)
+
+	router := mux.NewRouter()
+	router.Use(middlewares.SetMiddlewareRateLimit(limiter))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router.HandleFunc("/login", ok).Methods("POST")
+	router.HandleFunc("/{mid}/authorizations", ok).Methods("GET")
+
+	send := func(method, path string, merchantID uint32, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if merchantID != 0 {
+			token, err := auth.CreateToken(merchantID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := send("GET", "/123456/authorizations", 123456, "10.0.0.1:1234")
+	second := send("GET", "/123456/authorizations", 123456, "10.0.0.2:1234")
+	third := send("GET", "/123456/authorizations", 123456, "10.0.0.3:1234")
+	otherMerchant := []int{}
+	for i := 0; i < 3; i++ {
+		otherMerchant = append(otherMerchant, send("GET", "/654321/authorizations", 654321, "10.0.0.1:1234").Code)
+	}
+	firstLogin := send("POST", "/login", 0, "10.0.0.1:1234")
+	secondLogin := send("POST", "/login", 0, "10.0.0.1:4321")
+	otherIPLogin := send("POST", "/login", 0, "10.0.0.2:1234")
+
+	Convey("When a merchant goes over its limit..", t, func() {
+		Convey("The requests within the limit go through with the rate limit headers", func() {
+			So(first.Code, ShouldEqual, http.StatusOK)
+			So(first.Header().Get(middlewares.RateLimitLimitHeader), ShouldEqual, "2")
+			So(first.Header().Get(middlewares.RateLimitRemainingHeader), ShouldEqual, "1")
+			So(second.Code, ShouldEqual, http.StatusOK)
+			So(second.Header().Get(middlewares.RateLimitRemainingHeader), ShouldEqual, "0")
+		})
+		Convey("The next request is refused whatever its IP address", func() {
+			So(third.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(third.Header().Get(middlewares.RetryAfterHeader), ShouldEqual, "30")
+			So(third.Body.String(), ShouldContainSubstring, `"code":"rate_limited"`)
+		})
+		Convey("A merchant with its own limit gets it", func() {
+			So(otherMerchant, ShouldResemble, []int{http.StatusOK, http.StatusOK, http.StatusOK})
+		})
+		Convey("Logins are limited by IP address", func() {
+			So(firstLogin.Code, ShouldEqual, http.StatusOK)
+			So(secondLogin.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(otherIPLogin.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	trustProxy, hops := os.Getenv("RATE_LIMIT_TRUST_PROXY"), os.Getenv("RATE_LIMIT_PROXY_HOPS")
+	os.Setenv("RATE_LIMIT_TRUST_PROXY", "true")
+	defer os.Setenv("RATE_LIMIT_TRUST_PROXY", trustProxy)
+	defer os.Setenv("RATE_LIMIT_PROXY_HOPS", hops)
+
+	limiter := models.NewRateLimiter(models.NewMemoryRateLimitStore(), models.RateLimits{
+		Routes: map[string]models.RateLimit{"POST /login": {Requests: 1, PeriodSeconds: 60}},
+	})
+	router := mux.NewRouter()
+	router.Use(middlewares.SetMiddlewareRateLimit(limiter))
+	router.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }).Methods("POST")
+
+	login := func(forwardedFor string) int {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	os.Setenv("RATE_LIMIT_PROXY_HOPS", "")
+	first := login("198.51.100.1, 203.0.113.7")
+	spoofed := login("198.51.100.2, 203.0.113.7")
+	otherClient := login("198.51.100.1, 203.0.113.8")
+
+	os.Setenv("RATE_LIMIT_PROXY_HOPS", "2")
+	firstBehindTwo := login("198.51.100.1, 203.0.113.9, 10.0.0.5")
+	spoofedBehindTwo := login("198.51.100.2, 203.0.113.9, 10.0.0.6")
+
+	Convey("When requests come through a trusted proxy..", t, func() {
+		Convey("They are counted by the address the proxy added", func() {
+			So(first, ShouldEqual, http.StatusOK)
+			So(otherClient, ShouldEqual, http.StatusOK)
+			So(firstBehindTwo, ShouldEqual, http.StatusOK)
+		})
+		Convey("A spoofed leading address does not get a bucket of its own", func() {
+			So(spoofed, ShouldEqual, http.StatusTooManyRequests)
+			So(spoofedBehindTwo, ShouldEqual, http.StatusTooManyRequests)
+		})
+	})
+}
+
+func TestDBRateLimitStore(t *testing.T) {
+	err := refreshRateLimitTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limits := models.RateLimits{Default: &models.RateLimit{Requests: 2, PeriodSeconds: 10}}
+	//two limiters sharing the table behave like two replicas
+	replicaA := models.NewRateLimiter(models.NewDBRateLimitStore(server.DB), limits)
+	replicaB := models.NewRateLimiter(models.NewDBRateLimitStore(server.DB), limits)
+
+	//whole seconds so the timestamps survive the round trip to Postgres
+	now := time.Now().Truncate(time.Second)
+	first, _, firstErr := replicaA.Allow("merchant:123456", 123456, "GET /{mid}/authorizations", now)
+	second, _, _ := replicaB.Allow("merchant:123456", 123456, "GET /{mid}/authorizations", now)
+	third, _, _ := replicaA.Allow("merchant:123456", 123456, "GET /{mid}/authorizations", now)
+	refilled, _, _ := replicaB.Allow("merchant:123456", 123456, "GET /{mid}/authorizations", now.Add(5*time.Second))
+
+	Convey("When replicas share the Postgres store..", t, func() {
+		Convey("They take from the same bucket", func() {
+			So(firstErr, ShouldBeNil)
+			So(first.Allowed, ShouldBeTrue)
+			So(second.Allowed, ShouldBeTrue)
+			So(third.Allowed, ShouldBeFalse)
+			So(third.RetryAfter, ShouldEqual, 5*time.Second)
+		})
+		Convey("The bucket refills over time", func() {
+			So(refilled.Allowed, ShouldBeTrue)
+			So(refilled.Remaining, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRiskScreening(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	merchantI := models.NewMerchantI()
+	_, _, err = merchantI.CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Risk", Country: "gb"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	if _, err = riskRuleI.SetBINCountry(server.DB, models.BINCountry{Prefix: "4000", Country: "US"}); err != nil {
+		log.Fatal(err)
+	}
+	ruleRequests := []models.RiskRuleRequest{
+		{Name: "Large USD payments", Type: constants.RiskRuleType(constants.AmountThresholdRule).String(), Amount: 5000, Currency: "USD", Score: 60},
+		{Name: "Foreign cards", Type: constants.RiskRuleType(constants.CountryMismatchRule).String(), Score: 30},
+		{Name: "CVV guessing", Type: constants.RiskRuleType(constants.CVVFailuresRule).String(), MaxCount: 2, WindowSeconds: 3600, Score: 100},
+	}
+	for _, ruleRequest := range ruleRequests {
+		if _, err = riskRuleI.CreateRiskRule(server.DB, ruleRequest); err != nil {
+			log.Fatal(err)
+		}
+	}
+	_, unknownTypeErr := riskRuleI.CreateRiskRule(server.DB, models.RiskRuleRequest{Name: "Unknown", Type: "ip_velocity", Score: 10})
+	_, invalidRuleErr := riskRuleI.CreateRiskRule(server.DB, models.RiskRuleRequest{Name: "No window", Type: constants.RiskRuleType(constants.CardVelocityRule).String(), MaxCount: 5, Score: 10})
+	_, noCurrencyErr := riskRuleI.CreateRiskRule(server.DB, models.RiskRuleRequest{Name: "Large payments", Type: constants.RiskRuleType(constants.AmountThresholdRule).String(), Amount: 5000, Score: 60})
+	_, unknownCurrencyErr := riskRuleI.CreateRiskRule(server.DB, models.RiskRuleRequest{Name: "Large payments", Type: constants.RiskRuleType(constants.AmountThresholdRule).String(), Amount: 5000, Currency: "ZZZ", Score: 60})
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	allowed, allowedErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+
+	large := authRequest
+	large.Amount = 6000
+	reviewed, reviewedErr := authorizationInstance.RequestAuthorization(testMerchantID, large, server.DB)
+
+	wrongCVV := authRequest
+	wrongCVV.CVV = "124"
+	authorizationInstance.RequestAuthorization(testMerchantID, wrongCVV, server.DB)
+	authorizationInstance.RequestAuthorization(testMerchantID, wrongCVV, server.DB)
+	blocked, blockedErr := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+
+	Convey("When an authorization request is screened..", t, func() {
+		Convey("It is allowed below the review score", func() {
+			So(allowedErr, ShouldBeNil)
+			So(allowed.RiskDecision, ShouldEqual, constants.RiskDecision(constants.RiskAllow).String())
+			So(allowed.RiskScore, ShouldEqual, 30)
+		})
+		Convey("It is authorized and flagged for review from the review score", func() {
+			So(reviewedErr, ShouldBeNil)
+			So(reviewed.RiskDecision, ShouldEqual, constants.RiskDecision(constants.RiskReview).String())
+			So(reviewed.RiskScore, ShouldEqual, 90)
+
+			page, err := authorizationInstance.ListAuthorizations(testMerchantID, models.AuthorizationFilter{RiskDecision: "review"}, server.DB)
+			So(err, ShouldBeNil)
+			So(len(page.Data), ShouldEqual, 1)
+		})
+		Convey("It is declined from the block score with the matched rules", func() {
+			So(blockedErr.Error(), ShouldEqual, constants.RiskBlocked)
+			So(blocked.DeclineCode, ShouldEqual, "risk_blocked")
+			So(blocked.RiskDecision, ShouldEqual, constants.RiskDecision(constants.RiskBlock).String())
+			So(blocked.BalanceAuthorised, ShouldEqual, 0)
+
+			assessment, err := authorizationInstance.RiskAssessment(testMerchantID, blocked.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(assessment.Score, ShouldEqual, 130)
+			So(len(assessment.Rules), ShouldEqual, 2)
+			So(assessment.Rules[0].RuleName, ShouldEqual, "Foreign cards")
+			So(assessment.Rules[1].RuleType, ShouldEqual, constants.RiskRuleType(constants.CVVFailuresRule).String())
+		})
+		Convey("Invalid rules are refused", func() {
+			So(unknownTypeErr.Error(), ShouldEqual, constants.UnknownRiskRuleType+"ip_velocity")
+			So(invalidRuleErr.Error(), ShouldEqual, constants.InvalidRiskRule)
+		})
+		Convey("Amount thresholds need a currency", func() {
+			So(noCurrencyErr.Error(), ShouldEqual, constants.InvalidRiskRule)
+			So(unknownCurrencyErr.Error(), ShouldEqual, constants.InvalidCurrency)
+		})
+	})
+}
+
+func TestMigrateAmountThresholdRules(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//rules stored before amount thresholds needed a currency
+	amountThreshold := constants.RiskRuleType(constants.AmountThresholdRule).String()
+	withoutCurrency := models.RiskRule{Name: "Large payments", Type: amountThreshold, Amount: 5000, Score: 60}
+	withCurrency := models.RiskRule{Name: "Large USD payments", Type: amountThreshold, Amount: 5000, Currency: "USD", Score: 60}
+	for _, rule := range []*models.RiskRule{&withoutCurrency, &withCurrency} {
+		if err = server.DB.Create(rule).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err = models.MigrateSchemaChanges(server.DB); err != nil {
+		log.Fatal(err)
+	}
+
+	riskRuleI := models.NewRiskRuleI()
+	migratedWithout, withoutErr := riskRuleI.FindRiskRuleByID(server.DB, withoutCurrency.ID)
+	migratedWith, withErr := riskRuleI.FindRiskRuleByID(server.DB, withCurrency.ID)
+
+	Convey("When amount thresholds are migrated..", t, func() {
+		Convey("Those without a currency are disabled", func() {
+			So(withoutErr, ShouldBeNil)
+			So(migratedWithout.Disabled, ShouldBeTrue)
+		})
+		Convey("Those with a currency are left as they are", func() {
+			So(withErr, ShouldBeNil)
+			So(migratedWith.Disabled, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSimulatorScenarios(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	card, err := addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scenarioI := models.NewSimulatorScenarioI()
+	scenarios := []models.SimulatorScenario{
+		{CardID: card.Fingerprint, Amount: 1059, Outcome: constants.SimulatorOutcome(constants.OutcomeSuspectedFraud).String()},
+		{CardID: card.Fingerprint, Amount: 1010, Outcome: constants.SimulatorOutcome(constants.OutcomePartialApproval).String(), ApprovedAmount: 600},
+		{Amount: 1091, Outcome: constants.SimulatorOutcome(constants.OutcomeTimeout).String()},
+		{Amount: 1051, Outcome: constants.SimulatorOutcome(constants.OutcomeInsufficientFunds).String()},
+		{Amount: 1099, Outcome: constants.SimulatorOutcome(constants.OutcomeDelayed).String(), DelayMs: 200},
+	}
+	for i := range scenarios {
+		if _, err = scenarioI.CreateScenario(server.DB, &scenarios[i]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	_, unknownErr := scenarioI.CreateScenario(server.DB, &models.SimulatorScenario{Outcome: "approve_twice"})
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	authorize := func(amount models.Money) (*models.Authorization, error) {
+		request := authRequest
+		request.Amount = amount
+		return authorizationInstance.RequestAuthorization(testMerchantID, request, server.DB)
+	}
+
+	fraud, fraudErr := authorize(1059)
+	partial, partialErr := authorize(1010)
+	_, timeoutErr := authorize(1091)
+	_, fundsErr := authorize(1051)
+	//the bank account must stay free while the simulator delays its answer
+	var delayed *models.Authorization
+	var delayedErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		delayed, delayedErr = authorize(1099)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	delayLockErr := server.DB.Exec("SELECT id FROM bank_accounts WHERE card_id = ? FOR UPDATE NOWAIT", card.Fingerprint).Error
+	<-done
+	approved, approvedErr := authorize(1000)
+
+	Convey("When an authorization hits a simulator scenario..", t, func() {
+		Convey("Declines are scripted by card and amount", func() {
+			So(fraudErr.Error(), ShouldEqual, constants.SuspectedFraud)
+			So(fraud.DeclineCode, ShouldEqual, "suspected_fraud")
+			So(fraud.ProcessorResponseCode, ShouldEqual, models.ResponseSuspectedFraud)
+			So(fundsErr.Error(), ShouldEqual, constants.AmountExeedsBalance)
+		})
+		Convey("A partial approval authorizes less than requested", func() {
+			So(partialErr, ShouldBeNil)
+			So(partial.AmountRequested, ShouldEqual, 1010)
+			So(partial.BalanceAuthorised, ShouldEqual, 600)
+			So(partial.ProcessorResponseCode, ShouldEqual, models.ResponsePartialApproval)
+
+			available, err := bankAccountInstance.AvailableBalance(server.DB, card.Fingerprint)
+			So(err, ShouldBeNil)
+			So(available, ShouldEqual, 10000-600-1099-1000)
+		})
+		Convey("A timeout fails without an authorization", func() {
+			So(timeoutErr.Error(), ShouldEqual, constants.ProcessorUnavailable)
+		})
+		Convey("Delayed and unscripted authorizations go through the bank account", func() {
+			So(delayedErr, ShouldBeNil)
+			So(delayed.BalanceAuthorised, ShouldEqual, 1099)
+			So(delayLockErr, ShouldBeNil)
+			So(approvedErr, ShouldBeNil)
+			So(approved.ProcessorResponseCode, ShouldEqual, models.ResponseApproved)
+		})
+		Convey("Unknown outcomes are refused", func() {
+			So(unknownErr.Error(), ShouldEqual, constants.UnknownSimulatorOutcome+"approve_twice")
+		})
+	})
+}
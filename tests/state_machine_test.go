@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func status(s int) string {
+	return constants.AuthStatus(s).String()
+}
+
+func TestValidateTransition(t *testing.T) {
+	Convey("When I validate a status transition..", t, func() {
+		Convey("A new authorization can be approved or declined", func() {
+			So(models.ValidateTransition("", status(constants.Authorized)), ShouldBeNil)
+			So(models.ValidateTransition("", status(constants.Declined)), ShouldBeNil)
+			So(models.ValidateTransition("", status(constants.Captured)), ShouldNotBeNil)
+		})
+		Convey("A captured authorization can be refunded", func() {
+			So(models.ValidateTransition(status(constants.Captured), status(constants.PartiallyRefunded)), ShouldBeNil)
+			So(models.ValidateTransition(status(constants.Captured), status(constants.Refunded)), ShouldBeNil)
+		})
+		Convey("A captured authorization cannot be voided or captured again", func() {
+			So(models.ValidateTransition(status(constants.Captured), status(constants.Voided)), ShouldNotBeNil)
+			So(models.ValidateTransition(status(constants.Captured), status(constants.Captured)), ShouldNotBeNil)
+		})
+		Convey("Final statuses cannot change", func() {
+			for _, final := range []int{constants.Voided, constants.Refunded, constants.Expired, constants.Declined} {
+				So(models.ValidateTransition(status(final), status(constants.Authorized)), ShouldNotBeNil)
+				So(models.ValidateTransition(status(final), status(constants.Refunded)), ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestAuthorizationLifecycle(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When an authorization goes through its lifecycle..", t, func() {
+		a, err := authorizationInstance.Capture(testMerchantID, auth.ID, 400, "USD", false, server.DB)
+		So(err, ShouldBeNil)
+		So(a.Status, ShouldEqual, status(constants.PartiallyCaptured))
+
+		a, err = authorizationInstance.Capture(testMerchantID, auth.ID, 600, "USD", false, server.DB)
+		So(err, ShouldBeNil)
+		So(a.Status, ShouldEqual, status(constants.Captured))
+
+		_, err = authorizationInstance.Void(testMerchantID, auth.ID, server.DB)
+		So(err, ShouldNotBeNil)
+
+		a, err = authorizationInstance.Refund(testMerchantID, auth.ID, 300, "USD", false, server.DB)
+		So(err, ShouldBeNil)
+		So(a.Status, ShouldEqual, status(constants.PartiallyRefunded))
+
+		a, err = authorizationInstance.Refund(testMerchantID, auth.ID, 700, "USD", false, server.DB)
+		So(err, ShouldBeNil)
+		So(a.Status, ShouldEqual, status(constants.Refunded))
+		So(a.BalanceRefunded, ShouldEqual, 1000)
+
+		Convey("Every change of status is recorded with a reason", func() {
+			history, err := authorizationInstance.ListStatusHistory(testMerchantID, auth.ID, server.DB)
+			So(err, ShouldBeNil)
+			So(len(history), ShouldEqual, 5)
+			So(history[0].FromStatus, ShouldEqual, "")
+			So(history[0].ToStatus, ShouldEqual, status(constants.Authorized))
+			So(history[4].FromStatus, ShouldEqual, status(constants.PartiallyRefunded))
+			So(history[4].ToStatus, ShouldEqual, status(constants.Refunded))
+			So(history[4].Reason, ShouldEqual, models.ReasonFullRefund)
+		})
+	})
+}
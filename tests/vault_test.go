@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"log"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVaultEncryption(t *testing.T) {
+	fingerprint := vault.Fingerprint(testCardNumber)
+	ciphertext, wrappedKey, err := vault.Encrypt(testCardNumber, fingerprint)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When a card number is encrypted..", t, func() {
+		Convey("It decrypts back to the card number", func() {
+			number, err := vault.Decrypt(ciphertext, wrappedKey, fingerprint)
+			So(err, ShouldBeNil)
+			So(number, ShouldEqual, testCardNumber)
+		})
+		Convey("It does not decrypt for another card", func() {
+			_, err := vault.Decrypt(ciphertext, wrappedKey, vault.Fingerprint("4000000000000259"))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The ciphertext does not contain the card number", func() {
+			So(ciphertext, ShouldNotContainSubstring, testCardNumber)
+		})
+	})
+}
+
+func TestAuthorizeWithCardToken(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	card, err := addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      testCardNumber,
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+
+	first, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	second, err := authorizationInstance.RequestAuthorization(testMerchantID, models.AuthorizationRequest{
+		CardToken: first.CardToken,
+		Currency:  "USD",
+		Amount:    500,
+	}, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When a card is authorized..", t, func() {
+		Convey("The authorization refers to the card by token", func() {
+			So(first.CardToken, ShouldStartWith, "card_")
+			So(second.CardToken, ShouldEqual, first.CardToken)
+		})
+		Convey("The card number and CVV are not stored in plain text", func() {
+			stored, err := cardInstance.FindCardByID(server.DB, card.ID)
+			So(err, ShouldBeNil)
+			So(stored.EncryptedPAN, ShouldNotContainSubstring, testCardNumber)
+			So(server.DB.Dialect().HasColumn("cards", "cvv_check"), ShouldBeFalse)
+
+			number, err := cardInstance.RevealNumber(stored)
+			So(err, ShouldBeNil)
+			So(number, ShouldEqual, testCardNumber)
+		})
+		Convey("Another merchant cannot use the token", func() {
+			_, err := cardInstance.FindCardByToken(server.DB, 654321, first.CardToken)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMigrateAuthorizationCards(t *testing.T) {
+	err := refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//authorizations stored by older versions with the card number, one of them from before merchants existed
+	err = server.DB.Exec("ALTER TABLE authorizations ADD COLUMN card_number varchar(20)").Error
+	if err != nil {
+		log.Fatal(err)
+	}
+	legacy := map[string]string{"merchant": ksuid.New().String(), "none": ksuid.New().String()}
+	for owner, id := range legacy {
+		auth := models.Authorization{
+			ID:                id,
+			MerchantID:        testMerchantID,
+			CurrencyRequested: "USD",
+			CurrencyCard:      "USD",
+			Status:            constants.AuthStatus(constants.Authorized).String(),
+		}
+		if err = server.DB.Create(&auth).Error; err != nil {
+			log.Fatal(err)
+		}
+		err = server.DB.Exec("UPDATE authorizations SET card_number = ?, card_token = '' WHERE id = ?", testCardNumber, auth.ID).Error
+		if err == nil && owner == "none" {
+			err = server.DB.Exec("UPDATE authorizations SET merchant_id = NULL WHERE id = ?", auth.ID).Error
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	migrateErr := models.MigrateCardVault(server.DB)
+	tokens := map[string]models.CardToken{}
+	for owner, id := range legacy {
+		var auth models.Authorization
+		server.DB.Model(models.Authorization{}).Where("id = ?", id).Take(&auth)
+		var token models.CardToken
+		server.DB.Model(models.CardToken{}).Where("token = ?", auth.CardToken).Take(&token)
+		tokens[owner] = token
+	}
+
+	Convey("When authorizations still hold card numbers..", t, func() {
+		Convey("They are linked to card tokens and the numbers are dropped", func() {
+			So(migrateErr, ShouldBeNil)
+			So(tokens["merchant"].MerchantID, ShouldEqual, testMerchantID)
+			So(server.DB.Dialect().HasColumn("authorizations", "card_number"), ShouldBeFalse)
+		})
+		Convey("Authorizations without a merchant get a token of merchant 0", func() {
+			So(tokens["none"].Token, ShouldNotBeEmpty)
+			So(tokens["none"].MerchantID, ShouldEqual, 0)
+			So(tokens["none"].CardID, ShouldEqual, tokens["merchant"].CardID)
+		})
+	})
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xectich/paymentGateway/constants"
+	"github.com/xectich/paymentGateway/models"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+//webhookReceiver records the requests of a local endpoint that answers with status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.requests = append(wr.requests, r)
+	wr.bodies = append(wr.bodies, body)
+	w.WriteHeader(wr.status)
+}
+
+func (wr *webhookReceiver) setStatus(status int) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.status = status
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	err := refreshMerchantTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, _, err = models.NewMerchantI().CreateMerchant(server.DB, models.MerchantRequest{ID: testMerchantID, Name: "Test Merchant"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = refreshWebhookTables()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = refreshAuthorizationTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = addCard()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = addBankAccount()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//the receivers run on the loopback address
+	models.AllowWebhookPrivateAddresses(true)
+	defer models.AllowWebhookPrivateAddresses(false)
+
+	receiver := &webhookReceiver{status: http.StatusOK}
+	endpoint := httptest.NewServer(receiver)
+	defer endpoint.Close()
+
+	webhookI := models.NewWebhookI()
+	_, secret, err := webhookI.RegisterEndpoint(server.DB, testMerchantID, endpoint.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authRequest := models.AuthorizationRequest{
+		CardNumber:      "4000000000000119",
+		Currency:        "USD",
+		CVV:             "123",
+		Amount:          1000,
+		ExpirationMonth: 1,
+		ExpirationYear:  testCardExpirationYear,
+	}
+	auth, err := authorizationInstance.RequestAuthorization(testMerchantID, authRequest, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dispatcher := models.NewWebhookDispatcher(server.DB, endpoint.Client())
+	attempted, err := dispatcher.DeliverDue(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When an authorization is created..", t, func() {
+		Convey("The merchant receives a signed authorization.created event", func() {
+			So(attempted, ShouldEqual, 1)
+			So(len(receiver.requests), ShouldEqual, 1)
+
+			request, body := receiver.requests[0], receiver.bodies[0]
+			So(request.Header.Get(models.WebhookEventTypeHeader), ShouldEqual, constants.WebhookEventType(constants.AuthorizationCreatedEvent).String())
+
+			var timestamp int64
+			var signature string
+			_, err := fmt.Sscanf(strings.Replace(request.Header.Get(models.WebhookSignatureHeader), ",", " ", 1), "t=%d v1=%s", &timestamp, &signature)
+			So(err, ShouldBeNil)
+			So(signature, ShouldEqual, models.SignWebhookPayload(secret, timestamp, body))
+
+			payload := models.WebhookPayload{}
+			So(json.Unmarshal(body, &payload), ShouldBeNil)
+			So(payload.Data.ID, ShouldEqual, auth.ID)
+			So(payload.Data.Card.Token, ShouldEqual, auth.CardToken)
+			So(payload.Data.Card.Last4, ShouldEqual, "0119")
+		})
+		Convey("And the delivery is logged", func() {
+			deliveries, err := webhookI.ListDeliveries(server.DB, testMerchantID)
+			So(err, ShouldBeNil)
+			So(len(deliveries), ShouldEqual, 1)
+			So(deliveries[0].Status, ShouldEqual, constants.WebhookDeliveryStatus(constants.DeliveryDelivered).String())
+
+			detail, err := webhookI.FindDeliveryByID(server.DB, testMerchantID, deliveries[0].ID)
+			So(err, ShouldBeNil)
+			So(len(detail.Attempts), ShouldEqual, 1)
+			So(detail.Attempts[0].StatusCode, ShouldEqual, http.StatusOK)
+		})
+	})
+
+	//the endpoint starts failing before the capture
+	receiver.setStatus(http.StatusInternalServerError)
+	_, err = authorizationInstance.Capture(testMerchantID, auth.ID, 500, "USD", false, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	_, err = dispatcher.DeliverDue(now)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When the endpoint fails..", t, func() {
+		deliveries, err := webhookI.ListDeliveries(server.DB, testMerchantID)
+		So(err, ShouldBeNil)
+		failed := deliveries[0]
+
+		Convey("The delivery is retried later with a backoff", func() {
+			So(failed.EventType, ShouldEqual, constants.WebhookEventType(constants.AuthorizationCapturedEvent).String())
+			So(failed.Status, ShouldEqual, constants.WebhookDeliveryStatus(constants.DeliveryPending).String())
+			So(failed.Attempts, ShouldEqual, 1)
+			So(failed.LastStatusCode, ShouldEqual, http.StatusInternalServerError)
+			So(failed.NextAttemptAt, ShouldHappenWithin, time.Second, now.Add(dispatcher.Backoff(1)))
+			So(dispatcher.Backoff(3), ShouldEqual, 4*dispatcher.Backoff(1))
+		})
+		Convey("And it is not attempted again before it is due", func() {
+			attempted, err := dispatcher.DeliverDue(now)
+			So(err, ShouldBeNil)
+			So(attempted, ShouldEqual, 0)
+		})
+	})
+
+	receiver.setStatus(http.StatusNoContent)
+
+	Convey("When the merchant asks for a redelivery..", t, func() {
+		deliveries, err := webhookI.ListDeliveries(server.DB, testMerchantID)
+		So(err, ShouldBeNil)
+
+		_, err = webhookI.Redeliver(server.DB, testMerchantID, deliveries[0].ID)
+		So(err, ShouldBeNil)
+
+		attempted, err := dispatcher.DeliverDue(time.Now())
+		So(err, ShouldBeNil)
+		So(attempted, ShouldEqual, 1)
+
+		detail, err := webhookI.FindDeliveryByID(server.DB, testMerchantID, deliveries[0].ID)
+		So(err, ShouldBeNil)
+		So(detail.Delivery.Status, ShouldEqual, constants.WebhookDeliveryStatus(constants.DeliveryDelivered).String())
+		So(len(detail.Attempts), ShouldEqual, 2)
+
+		_, err = webhookI.Redeliver(server.DB, testMerchantID+1, deliveries[0].ID)
+		So(err, ShouldNotBeNil)
+	})
+
+	//a second endpoint looks at its delivery while the request is being sent
+	var inFlightStatus string
+	var lockErr error
+	inspector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseUint(r.Header.Get(models.WebhookDeliveryIDHeader), 10, 64)
+		if detail, err := webhookI.FindDeliveryByID(server.DB, testMerchantID, id); err == nil {
+			inFlightStatus = detail.Delivery.Status
+		}
+		lockErr = server.DB.Exec("SELECT id FROM webhook_deliveries WHERE id = ? FOR UPDATE NOWAIT", id).Error
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer inspector.Close()
+
+	_, _, err = webhookI.RegisterEndpoint(server.DB, testMerchantID, inspector.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = authorizationInstance.Refund(testMerchantID, auth.ID, 100, "USD", false, server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = dispatcher.DeliverDue(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Convey("When a delivery is being sent..", t, func() {
+		Convey("It is in flight without its row being locked", func() {
+			So(inFlightStatus, ShouldEqual, constants.WebhookDeliveryStatus(constants.DeliveryInFlight).String())
+			So(lockErr, ShouldBeNil)
+		})
+	})
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	endpoint := httptest.NewServer(receiver)
+	defer endpoint.Close()
+
+	webhookI := models.NewWebhookI()
+	refused := map[string]error{}
+	for _, endpointURL := range []string{
+		endpoint.URL,
+		"http://localhost/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"https://10.1.2.3/hooks",
+		"https://192.168.0.10/hooks",
+		"http://[::1]/hooks",
+		"http://0.0.0.0/hooks",
+	} {
+		_, _, refused[endpointURL] = webhookI.RegisterEndpoint(server.DB, testMerchantID, endpointURL)
+	}
+
+	//a host resolving to a private address once registered is refused when the webhook is sent
+	client := models.NewWebhookHTTPClient(time.Second)
+	_, dialErr := client.Post(endpoint.URL, "application/json", strings.NewReader("{}"))
+
+	models.AllowWebhookPrivateAddresses(true)
+	allowedResponse, allowedErr := client.Post(endpoint.URL, "application/json", strings.NewReader("{}"))
+	models.AllowWebhookPrivateAddresses(false)
+	if allowedErr == nil {
+		allowedResponse.Body.Close()
+	}
+
+	Convey("When a webhook URL points to a private address..", t, func() {
+		Convey("It cannot be registered", func() {
+			for endpointURL, err := range refused {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, constants.WebhookURLNotPublic)
+				So(endpointURL, ShouldNotBeEmpty)
+			}
+		})
+		Convey("Webhooks are not sent to it", func() {
+			So(dialErr, ShouldNotBeNil)
+			So(dialErr.Error(), ShouldContainSubstring, constants.WebhookURLNotPublic)
+			So(len(receiver.requests), ShouldEqual, 0)
+		})
+		Convey("Unless private addresses were explicitly allowed", func() {
+			So(allowedErr, ShouldBeNil)
+			So(len(receiver.requests), ShouldEqual, 1)
+		})
+	})
+}